@@ -0,0 +1,192 @@
+package afk
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Default time after which the user is returned automatically
+const DefaultTimeout = 24 * time.Hour
+
+// Categories with their own auto-return timeouts
+var Categories = map[string]time.Duration{
+	"gn":   12 * time.Hour,
+	"food": time.Hour,
+	"work": 10 * time.Hour,
+}
+
+// Status is stored as json in the key with ttl, so it's removed when the user is returned automatically
+type Status struct {
+	Username string    `json:"username"`
+	Category string    `json:"category,omitempty"`
+	Message  string    `json:"message"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+}
+
+func key(channel, username string) string {
+	return "afk:" + channel + ":" + strings.ToLower(username)
+}
+
+// Parse reads the status from the params: [gn|food|work] [for <duration>] <message>
+func Parse(username string, params []string, now time.Time) (*Status, error) {
+	status := &Status{Username: username, Since: now}
+	timeout := DefaultTimeout
+	if len(params) > 0 {
+		if t, ok := Categories[params[0]]; ok {
+			status.Category, timeout = params[0], t
+			params = params[1:]
+		}
+	}
+	if len(params) > 1 && params[0] == "for" {
+		d, err := time.ParseDuration(params[1])
+		if err != nil || d <= 0 {
+			return nil, errors.New("wrong time format, use e.g. 30m")
+		}
+		if d > 7*24*time.Hour {
+			return nil, errors.New("timeout can't be longer than a week")
+		}
+		timeout = d
+		params = params[2:]
+	}
+	status.Message = strings.Join(params, " ")
+	status.Until = now.Add(timeout)
+	return status, nil
+}
+
+func Set(conn redis.Conn, channel string, status *Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	ttl := int(time.Until(status.Until) / time.Second)
+	if ttl <= 0 {
+		return errors.New("timeout is in the past")
+	}
+	_, err = conn.Do("SET", key(channel, status.Username), data, "EX", ttl)
+	return err
+}
+
+func decode(data []byte, err error) (*Status, error) {
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Get returns nil if the user isn't afk
+func Get(conn redis.Conn, channel, username string) (*Status, error) {
+	return decode(redis.Bytes(conn.Do("GET", key(channel, username))))
+}
+
+// Return removes the status and returns it, nil if the user wasn't afk
+func Return(conn redis.Conn, channel, username string) (*Status, error) {
+	k := key(channel, username)
+	conn.Send("MULTI")
+	conn.Send("GET", k)
+	conn.Send("DEL", k)
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	return decode(redis.Bytes(values[0], nil))
+}
+
+// List returns everybody who is afk in the channel
+func List(conn redis.Conn, channel string) ([]Status, error) {
+	var list []Status
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", key(channel, "*"), "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			// the key may expire between scan and get
+			status, err := decode(redis.Bytes(conn.Do("GET", k)))
+			if err != nil {
+				return nil, err
+			}
+			if status != nil {
+				list = append(list, *status)
+			}
+		}
+		if cursor == 0 {
+			return list, nil
+		}
+	}
+}
+
+var mention = regexp.MustCompile(`@(\w+)`)
+
+// Mentions returns every user mentioned in the text once
+func Mentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range mention.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(match[1])
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Describe is the category and the message of the status
+func (s *Status) Describe() string {
+	str := s.Message
+	if s.Category != "" {
+		str = "(" + s.Category + ") " + str
+	}
+	return strings.TrimSpace(str)
+}
+
+// RemoveLegacy deletes statuses of the old format, they were gob encoded afk:<user> fields of the channel hash
+// without expiry, so nothing else removes them
+func RemoveLegacy(conn redis.Conn, channel string) (int, error) {
+	removed := 0
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("HSCAN", channel, cursor, "MATCH", "afk:*", "COUNT", 100))
+		if err != nil {
+			return removed, err
+		}
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return removed, err
+		}
+		pairs, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return removed, err
+		}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			n, err := redis.Int(conn.Do("HDEL", channel, pairs[i]))
+			if err != nil {
+				return removed, err
+			}
+			removed += n
+		}
+		if cursor == 0 {
+			return removed, nil
+		}
+	}
+}
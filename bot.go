@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,24 +18,30 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"twitchStats/afk"
+	"twitchStats/commands/auth"
 	pb "twitchStats/commands/pb"
 	"twitchStats/database"
+	"twitchStats/followers"
+	"twitchStats/identity"
 	"twitchStats/logsparser"
+	"twitchStats/modes"
+	"twitchStats/points"
+	"twitchStats/profile"
 	"twitchStats/request"
+	"twitchStats/roles"
+	"twitchStats/seen"
 	"twitchStats/statistics"
+	"twitchStats/tells"
 	"twitchStats/terminal"
+	"twitchStats/timers"
 
 	"github.com/gomodule/redigo/redis"
 	"google.golang.org/grpc"
 )
 
-const (
-	LOW = iota
-	MIDDLE
-	TOP
-)
-
 const (
 	BotName = "funwayz"
 	Port    = "6667"
@@ -47,13 +54,34 @@ type Bot struct {
 	OAuth       string
 	Conn        net.Conn
 	StopChannel chan struct{}
-	Authority   map[string]int
-	Status      string
+	Roles       *roles.Resolver
+	Modes       modes.State
 	Warn        Warn
 	BadWords    map[string]struct{}
 	Spam        Spam
 	Stats       map[string]*statistics.Stats
 	GrpcClient  pb.CommandsClient
+	Users       Users
+	// renamed users, their today stats are moved by checkStats
+	Renames chan *identity.Change
+	// recipients of the undelivered !tell messages
+	Tells *tells.Pending
+	// chat lines since the connection, timers don't post into a dead chat
+	Lines int64
+}
+
+// Users are the known names of the user ids, so the database is touched only for new users and renames
+type Users struct {
+	sync.Mutex
+	byID    map[string]identity.User
+	byLogin map[string]string
+}
+
+// ID returns the id of the user seen in chat, empty if the user wasn't seen
+func (u *Users) ID(login string) string {
+	u.Lock()
+	defer u.Unlock()
+	return u.byLogin[login]
 }
 
 type Bttv struct {
@@ -97,13 +125,23 @@ func (bot *Bot) Connect() {
 		terminal.Output.Println("Unable to connect!")
 	}
 	fmt.Fprintf(bot.Conn, "CAP REQ :twitch.tv/tags\r\n")
+	fmt.Fprintf(bot.Conn, "CAP REQ :twitch.tv/commands\r\n")
 	fmt.Fprintf(bot.Conn, "PASS %s\r\n", bot.OAuth)
 	fmt.Fprintf(bot.Conn, "NICK %s\r\n", BotName)
 	fmt.Fprintf(bot.Conn, "JOIN %s\r\n", bot.Channel)
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	grpcConn, err := grpc.Dial("localhost:3434", opts...)
+	config, err := auth.LoadConfig()
+	if err != nil {
+		terminal.Output.Log(err)
+		return
+	}
+	opts, err := config.DialOptions("bot")
+	if err != nil {
+		terminal.Output.Log(err)
+		return
+	}
+	grpcConn, err := grpc.Dial(config.Address, opts...)
 	if err != nil {
 		terminal.Output.Println("Unable to connect to grpc")
 	}
@@ -128,19 +166,20 @@ func (bot *Bot) Connect() {
 	status, err := redis.String(redisConn.Do("GET", "status:"+bot.Channel))
 	if err != nil {
 		terminal.Output.Log(err)
-		_, err = redisConn.Do("SET", "status:"+bot.Channel, "Running")
+		_, err = redisConn.Do("SET", "status:"+bot.Channel, modes.Running.String())
 	}
-
-	if status != "" {
-		bot.Status = status
-	} else {
-		bot.Status = "Running"
+	set, err := modes.Parse(status)
+	if err != nil {
+		terminal.Output.Log(err)
 	}
+	bot.Modes.Store(set)
 
 	getLastVods(bot.ChannelId)
 
 	go bot.checkStatus(redisInvalidateConn, redisConn)
 	go bot.checkReminders()
+	go bot.runTimers()
+	go bot.trackFollowers()
 	go bot.reader(wg, redisConn)
 	terminal.Output.Println("connected to " + bot.Channel)
 	wg.Wait()
@@ -159,6 +198,21 @@ func (bot *Bot) SendMessage(msg string) {
 	fmt.Fprintf(bot.Conn, "PRIVMSG %s :%s\r\n", bot.Channel, msg)
 }
 
+func (bot *Bot) setStatus(status string) error {
+	_, err := bot.GrpcClient.SetChannelStatus(context.Background(), &pb.ChannelStatus{Channel: bot.Channel, Status: status})
+	return err
+}
+
+// setMode enables or disables the mode keeping the others
+func (bot *Bot) setMode(mode modes.Mode, enabled bool) error {
+	_, err := bot.GrpcClient.SetChannelMode(context.Background(), &pb.ChannelMode{Channel: bot.Channel, Mode: mode.String(), Enabled: enabled})
+	return err
+}
+
+func (bot *Bot) Whisper(username, msg string) {
+	bot.SendMessage("/w " + username + " " + msg)
+}
+
 // reader and parser
 func (bot *Bot) reader(wg *sync.WaitGroup, redisConn redis.Conn) {
 	tp := textproto.NewReader(bufio.NewReader(bot.Conn))
@@ -186,10 +240,56 @@ func (bot *Bot) reader(wg *sync.WaitGroup, redisConn redis.Conn) {
 }
 
 type Message struct {
-	Username string
-	Text     string
-	Emotes   string
-	ID       string
+	UserID      string
+	DisplayName string
+	Username    string
+	Text        string
+	Emotes      string
+	ID          string
+	Roles       []string
+	SubMonths   int
+}
+
+// Split tags of the irc message into the map, e.g. @badges=broadcaster/1;emotes=;id=... :rest
+func parseTags(line string) (map[string]string, string) {
+	tags := make(map[string]string)
+	if !strings.HasPrefix(line, "@") {
+		return tags, line
+	}
+	index := strings.Index(line, " ")
+	if index == -1 {
+		return tags, ""
+	}
+	for _, tag := range strings.Split(line[1:index], ";") {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags, line[index+1:]
+}
+
+// :username!username@username.tmi.twitch.tv PRIVMSG #channel :text
+func parsePrivmsg(line string) (*Message, error) {
+	tags, rest := parseTags(line)
+	nameEnd := strings.Index(rest, "!")
+	textStart := strings.Index(rest, " :")
+	if !strings.HasPrefix(rest, ":") || nameEnd == -1 || textStart == -1 {
+		return nil, errors.New("malformed message: " + line)
+	}
+	badges := roles.ParseBadges(tags["badges"])
+	return &Message{
+		UserID:      tags["user-id"],
+		DisplayName: tags["display-name"],
+		Username:    rest[1:nameEnd],
+		Text:        rest[textStart+2:],
+		Emotes:      tags["emotes"],
+		ID:          tags["id"],
+		Roles:       roles.FromBadges(badges),
+		SubMonths:   roles.SubMonths(tags["badge-info"]),
+	}, nil
 }
 
 func (bot *Bot) logsWriter(logChan <-chan *Message) {
@@ -201,6 +301,8 @@ func (bot *Bot) logsWriter(logChan <-chan *Message) {
 	}
 	defer logfile.Close()
 	w := bufio.NewWriter(logfile)
+	conn := pool.Get()
+	defer conn.Close()
 	for {
 		select {
 		case message := <-logChan:
@@ -217,6 +319,9 @@ func (bot *Bot) logsWriter(logChan <-chan *Message) {
 
 			fmt.Fprintf(w, "[%s] %s: %s\n", time.Now().Format("2006-01-02 15:04:05 -0700 MST"), message.Username, message.Text)
 			w.Flush()
+			if err := seen.Update(conn, bot.Channel, message.Username, message.Text, t); err != nil {
+				terminal.Output.Log(err)
+			}
 		case <-bot.StopChannel:
 			return
 		}
@@ -236,10 +341,12 @@ type Warn struct {
 func (bot *Bot) timeout(username, reason string, seconds int) {
 	bot.SendMessage("/timeout " + username + " " + strconv.Itoa(seconds))
 	bot.SendMessage("@" + username + " " + reason)
+	go bot.strike(username, reason, seconds)
 }
 
 func (bot *Bot) ban(username string) {
 	bot.SendMessage("/ban " + username)
+	go bot.strike(username, "ban", 0)
 }
 
 func (bot *Bot) warning(username, id, reason string, seconds int) {
@@ -266,6 +373,13 @@ func (bot *Bot) warning(username, id, reason string, seconds int) {
 	bot.timeout(username, reason, seconds)
 }
 
+// strike saves the punishment into the profile history, seconds is 0 for the ban
+func (bot *Bot) strike(username, reason string, seconds int) {
+	if err := profile.AddStrike(bot.Channel[1:], username, reason, time.Duration(seconds)*time.Second); err != nil {
+		terminal.Output.Log(err)
+	}
+}
+
 func (bot *Bot) checkMessage(msg *Message) bool {
 	split := strings.Split(msg.Text, " ")
 	if len(split) > 1 {
@@ -280,12 +394,22 @@ func (bot *Bot) checkMessage(msg *Message) bool {
 	return false
 }
 
+// renames of the users are published to every bot
+const renamesKey = "renames"
+
+// checkReminders sends the announcements to the chat, marks the recipients of the new tells and applies renames
 func (bot *Bot) checkReminders() {
 	conn := pool.Get()
 	defer conn.Close()
-	conn.Send("SUBSCRIBE", "reminders:"+bot.Channel)
+	conn.Send("SUBSCRIBE", "reminders:"+bot.Channel, "tells:"+bot.Channel, renamesKey)
 	conn.Flush()
-	conn.Receive()
+	for i := 0; i < 3; i++ {
+		conn.Receive()
+	}
+	// loaded after subscribing, so tells sent meanwhile aren't missed
+	if err := bot.Tells.Load(); err != nil {
+		terminal.Output.Log(err)
+	}
 	for {
 		select {
 		case <-bot.StopChannel:
@@ -295,72 +419,90 @@ func (bot *Bot) checkReminders() {
 				terminal.Output.Log(err)
 				return
 			}
-			reminders, err := redis.Strings(conn.Receive())
+			message, err := redis.Strings(conn.Receive())
 			if err != nil {
 				terminal.Output.Log(err)
 				continue
 			}
-			bot.SendMessage(reminders[2])
+			switch message[1] {
+			case "tells:" + bot.Channel:
+				bot.Tells.Add(message[2])
+			case renamesKey:
+				var change identity.Change
+				if err := json.Unmarshal([]byte(message[2]), &change); err != nil {
+					terminal.Output.Log(err)
+					continue
+				}
+				bot.applyRename(&change)
+			default:
+				bot.SendMessage(message[2])
+			}
 		}
 	}
 }
 
-type afkData struct {
-	Message string
-	Time    time.Time
+// deliverTells sends messages left for the user with !tell
+func (bot *Bot) deliverTells(username string) {
+	list, err := bot.Tells.Take(username)
+	if err != nil {
+		terminal.Output.Log(err)
+		return
+	}
+	for i := range list {
+		if list[i].Whisper {
+			bot.Whisper(username, list[i].Text())
+		} else {
+			bot.SendMessage("@" + username + " " + list[i].Text())
+		}
+	}
 }
 
 func (bot *Bot) checkAfk(ch <-chan *Message) {
-	re := regexp.MustCompile(`@(\w+)`)
-	var b bytes.Buffer
-	var retText string
-	var afk afkData
-	var dec *gob.Decoder
 	conn := pool.Get()
+	defer conn.Close()
+	if _, err := afk.RemoveLegacy(conn, bot.Channel); err != nil {
+		terminal.Output.Log(err)
+	}
 	for {
 		select {
 		case msg := <-ch:
-			field := "afk:" + msg.Username
+			bot.deliverTells(msg.Username)
 			if err := conn.Err(); err != nil {
 				terminal.Output.Log(err)
 				return
 			}
-			data, err := redis.Bytes(conn.Do("HGET", bot.Channel, field))
-			if err == nil {
-				dec = gob.NewDecoder(&b)
-				b.Write(data)
-				err := dec.Decode(&afk)
+			// !afk itself sets the status, it mustn't return the user
+			if !strings.HasPrefix(msg.Text, "!afk") {
+				status, err := afk.Return(conn, bot.Channel, msg.Username)
 				if err != nil {
 					terminal.Output.Log(err)
+				} else if status != nil {
+					bot.SendMessage(fmt.Sprintf("%s was afk: %s (%s)", msg.Username, status.Describe(), time.Since(status.Since).Truncate(time.Second)))
 				}
-				retText = fmt.Sprintf("%s was afk: %s (%s)", msg.Username, afk.Message, time.Since(afk.Time).Truncate(time.Second))
-				bot.SendMessage(retText)
-				conn.Do("HDEL", bot.Channel, field)
-				b.Reset()
-			} else {
-				terminal.Output.Log(err)
 			}
-			match := re.FindStringSubmatch(msg.Text)
-			if len(match) == 0 {
-				continue
+			var away []string
+			for _, name := range afk.Mentions(msg.Text) {
+				if name == msg.Username {
+					continue
+				}
+				status, err := afk.Get(conn, bot.Channel, name)
+				if err != nil {
+					terminal.Output.Log(err)
+					continue
+				}
+				if status != nil {
+					away = append(away, fmt.Sprintf("%s is afk: %s. Last seen: %s ago", name, status.Describe(), time.Since(status.Since).Truncate(time.Second)))
+				}
 			}
-			field = "afk:" + match[1]
-			data, err = redis.Bytes(conn.Do("HGET", bot.Channel, field))
-			if err != nil {
-				terminal.Output.Log(err)
-				continue
+			if len(away) > 0 {
+				bot.SendMessage("@" + msg.Username + " " + strings.Join(away, " | "))
 			}
-			b.Write(data)
-			dec = gob.NewDecoder(&b)
-			dec.Decode(&afk)
-			retText = fmt.Sprintf("@%s %s is afk: %s. Last seen: %s ago", msg.Username, match[1], afk.Message, time.Since(afk.Time).Truncate(time.Second))
-			bot.SendMessage(retText)
-			b.Reset()
 		case <-bot.StopChannel:
 			return
 		}
 	}
 }
+
 func (bot *Bot) pasteWriter(msg *Message) {
 	pasteFile, err := os.OpenFile("paste.txt", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
@@ -394,9 +536,15 @@ func (bot *Bot) checkStatus(invalidateConn redis.Conn, getConn redis.Conn) {
 		status, err := redis.String(getConn.Do("GET", "status:"+bot.Channel))
 		if err != nil {
 			terminal.Output.Log(err)
+			continue
 		}
-		terminal.Output.Println(status)
-		bot.Status = status
+		set, err := modes.Parse(status)
+		if err != nil {
+			terminal.Output.Log(err)
+			continue
+		}
+		terminal.Output.Println(set.String())
+		bot.Modes.Store(set)
 	}
 }
 
@@ -407,6 +555,18 @@ func (bot *Bot) checkStats(ch <-chan string) {
 	defer ticker1.Stop()
 	db := database.Connect()
 	defer db.Close()
+	if err := points.CreateTable(db); err != nil {
+		terminal.Output.Log(err)
+	}
+	if err := profile.CreateTables(db); err != nil {
+		terminal.Output.Log(err)
+	}
+	if err := identity.CreateTables(db); err != nil {
+		terminal.Output.Log(err)
+	}
+	if err := identity.PrepareStats(db); err != nil {
+		terminal.Output.Log(err)
+	}
 	conn := pool.Get()
 	defer conn.Close()
 	var b bytes.Buffer
@@ -419,6 +579,14 @@ func (bot *Bot) checkStats(ch <-chan string) {
 				terminal.Output.Log(err)
 				continue
 			}
+			if err := seen.SetPresent(conn, bot.Channel, tempMap); err != nil {
+				terminal.Output.Log(err)
+			}
+			rates, err := points.RatesOf(db, bot.Channel[1:])
+			if err != nil {
+				terminal.Output.Log(err)
+				rates = points.DefaultRates
+			}
 			for k, stats := range bot.Stats {
 				_, ok := tempMap[k]
 				tx, err := db.Begin()
@@ -433,17 +601,35 @@ func (bot *Bot) checkStats(ch <-chan string) {
 				stats.MsgCountPrev = stats.MsgCount
 				if ok {
 					stats.WatchTime += watchTimeDiff
-					_, err := tx.Exec("INSERT INTO Stats(Channel, Username, MsgCount, WatchTime) VALUES($1,$2,$3,$4) ON CONFLICT(Channel, Username) DO UPDATE SET MsgCount=MsgCount+$5, WatchTime=WatchTime+$6;", bot.Channel[1:], k, stats.MsgCount, stats.WatchTime, msgCountDiff, watchTimeDiff)
+					_, err := tx.Exec("INSERT INTO Stats(Channel, Username, MsgCount, WatchTime, UserId) VALUES($1,$2,$3,$4,NULLIF($5,'')) ON CONFLICT(Channel, Username) DO UPDATE SET MsgCount=MsgCount+$6, WatchTime=WatchTime+$7, UserId=COALESCE(NULLIF($5,''), UserId);", bot.Channel[1:], k, stats.MsgCount, stats.WatchTime, bot.Users.ID(k), msgCountDiff, watchTimeDiff)
 					if err != nil {
 						terminal.Output.Println(err)
 					}
+					// viewers earn points for the time they were present, time of chat messages is counted too
+					minutes := (stats.WatchTime - stats.PaidTime) / time.Minute
+					stats.PaidTime += minutes * time.Minute
+					if err := points.Credit(tx, bot.Channel[1:], k, int(minutes)*rates.PerMinute, points.Watch); err != nil {
+						terminal.Output.Println(err)
+					}
 				} else {
-					_, err := tx.Exec("INSERT INTO Stats(Channel, Username, MsgCount) VALUES($1,$2,$3) ON CONFLICT(Channel, Username) DO UPDATE SET MsgCount=MsgCount+$4;", bot.Channel[1:], k, stats.MsgCount, msgCountDiff)
+					_, err := tx.Exec("INSERT INTO Stats(Channel, Username, MsgCount, UserId) VALUES($1,$2,$3,NULLIF($4,'')) ON CONFLICT(Channel, Username) DO UPDATE SET MsgCount=MsgCount+$5, UserId=COALESCE(NULLIF($4,''), UserId);", bot.Channel[1:], k, stats.MsgCount, bot.Users.ID(k), msgCountDiff)
 					if err != nil {
 						terminal.Output.Println(err)
 					}
 				}
 
+				if ok || msgCountDiff > 0 {
+					if err := profile.MarkSeen(tx, bot.Channel[1:], k, stats.LastCheck); err != nil {
+						terminal.Output.Println(err)
+					}
+				}
+				if msgCountDiff > rates.MaxMessages {
+					msgCountDiff = rates.MaxMessages
+				}
+				if err := points.Credit(tx, bot.Channel[1:], k, msgCountDiff*rates.PerMessage, points.Chat); err != nil {
+					terminal.Output.Println(err)
+				}
+
 				tx.Commit()
 				delete(tempMap, k)
 			}
@@ -452,6 +638,18 @@ func (bot *Bot) checkStats(ch <-chan string) {
 				stats.LastCheck = time.Now()
 				bot.Stats[k] = &stats
 			}
+		case change := <-bot.Renames:
+			if stats, ok := bot.Stats[change.OldLogin]; ok {
+				if current, ok := bot.Stats[change.NewLogin]; ok {
+					current.MsgCount += stats.MsgCount
+					current.MsgCountPrev += stats.MsgCountPrev
+					current.WatchTime += stats.WatchTime
+					current.PaidTime += stats.PaidTime
+				} else {
+					bot.Stats[change.NewLogin] = stats
+				}
+				delete(bot.Stats, change.OldLogin)
+			}
 		case name := <-ch:
 			if stats, ok := bot.Stats[name]; ok {
 				stats.MsgCount += 1
@@ -522,61 +720,268 @@ type Spam struct {
 	Messages []string
 }
 
+func (spam *Spam) Contains(text string) bool {
+	spam.RLock()
+	defer spam.RUnlock()
+	for i := range spam.Messages {
+		if strings.Contains(text, spam.Messages[i]) {
+			return true
+		}
+	}
+	return false
+}
+
 func (bot *Bot) parseChat(line string, logChan chan<- *Message, afkChan chan<- *Message, statsChan chan<- string, redisConn redis.Conn) {
-	if strings.Contains(line, "PRIVMSG") {
-		re := regexp.MustCompile(`emotes=(.*?);|@(.*?)\.tmi\.twitch\.tv|PRIVMSG.*?:(.*)|id=(.*?);`)
-		match := re.FindAllStringSubmatch(line[1:], -1)
-		message := Message{
-			Emotes:   match[0][1],
-			ID:       match[1][4],
-			Username: match[4][2],
-			Text:     match[5][3],
-		}
-		logChan <- &message
+	if tags, rest := parseTags(line); strings.HasPrefix(rest, ":tmi.twitch.tv USERNOTICE ") {
+		bot.userNotice(tags)
+	} else if strings.Contains(line, "PRIVMSG") {
+		message, err := parsePrivmsg(line)
+		if err != nil {
+			terminal.Output.Log(err)
+			return
+		}
+		if message.Text == "" {
+			return
+		}
+		logChan <- message
+		atomic.AddInt64(&bot.Lines, 1)
 		messageLength := len(message.Text)
-		switch bot.Status {
-		case "Running":
-			if messageLength >= 300 && messageLength <= 2000 {
-				go bot.pasteWriter(&message)
+		// modes work on top of the normal processing, so the chat stays moderated during votes
+		mode := bot.Modes.Load()
+		if mode.Has(modes.SpamAttack) && bot.Spam.Contains(message.Text) {
+			bot.ban(message.Username)
+			return
+		}
+		if messageLength >= 300 && messageLength <= 2000 {
+			go bot.pasteWriter(message)
+		}
+		bot.observe(message)
+		statsChan <- message.Username
+		if bot.checkMessage(message) {
+			return
+		}
+		afkChan <- message
+		if message.Text[0] == '!' {
+			bot.processCommands(message)
+		} else if mode.Has(modes.Vote) && messageLength == 1 && message.Text[0] >= '0' && message.Text[0] <= '9' {
+			// numbers are counted without the command, options with names need !vote <name>,
+			// so ordinary short messages don't vote by the prefix of the name
+			message.Text = "!vote " + message.Text
+			bot.processCommands(message)
+		}
+
+	} else if strings.HasPrefix(line, "PING") { // response to keep connection alive
+		bot.Pong(line)
+	}
+}
+
+// timerState is the last post of the timer
+type timerState struct {
+	posted time.Time
+	lines  int64
+}
+
+// runTimers posts messages of the timers, they are reloaded every check,
+// so changes from chat and terminal are picked up without restart
+func (bot *Bot) runTimers() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	state := make(map[int64]timerState)
+	for {
+		select {
+		case <-bot.StopChannel:
+			return
+		case now := <-ticker.C:
+			list, err := timers.List(bot.Channel)
+			if err != nil {
+				terminal.Output.Log(err)
+				continue
 			}
-			statsChan <- message.Username
-			if bot.checkMessage(&message) {
-				return
+			lines := atomic.LoadInt64(&bot.Lines)
+			live, checked := false, false
+			for i := range list {
+				t := &list[i]
+				last, ok := state[t.ID]
+				if !ok {
+					// the first post waits for the full interval
+					state[t.ID] = timerState{posted: now, lines: lines}
+					continue
+				}
+				if !t.Enabled || now.Sub(last.posted) < t.Interval || lines-last.lines < int64(t.MinLines) {
+					continue
+				}
+				if t.LiveOnly {
+					if !checked {
+						if live, err = terminal.IsLive(bot.Channel); err != nil {
+							terminal.Output.Log(err)
+						}
+						checked = true
+					}
+					if !live {
+						continue
+					}
+				}
+				bot.SendMessage(t.Next())
+				state[t.ID] = timerState{posted: now, lines: atomic.LoadInt64(&bot.Lines)}
+				if err := timers.Advance(t); err != nil {
+					terminal.Output.Log(err)
+				}
 			}
-			afkChan <- &message
-			if message.Text[0] == '!' {
-				bot.processCommands(&message)
+		}
+	}
+}
+
+// observe updates the name of the user id and moves the data of the renamed user
+func (bot *Bot) observe(msg *Message) {
+	if msg.UserID == "" {
+		return
+	}
+	user := identity.User{ID: msg.UserID, Login: msg.Username, DisplayName: msg.DisplayName}
+	bot.Users.Lock()
+	known, ok := bot.Users.byID[user.ID]
+	bot.Users.Unlock()
+	if ok && known == user {
+		return
+	}
+	db := database.Connect()
+	defer db.Close()
+	change, err := identity.Observe(db, user, time.Now())
+	if err != nil {
+		terminal.Output.Log(err)
+		return
+	}
+	bot.Users.Lock()
+	delete(bot.Users.byLogin, known.Login)
+	bot.Users.byID[user.ID] = user
+	bot.Users.byLogin[user.Login] = user.ID
+	bot.Users.Unlock()
+	if change != nil && change.Renamed() {
+		bot.rename(change)
+	}
+}
+
+// rename moves everything keyed by the login to the new one,
+// every running bot is notified, so their chat state follows the rename
+func (bot *Bot) rename(change *identity.Change) {
+	terminal.Output.Println(fmt.Sprintf("%s was renamed to %s", change.OldLogin, change.NewLogin))
+	if err := identity.Rename(change); err != nil {
+		terminal.Output.Log(err)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if err := seen.Rename(conn, change.OldLogin, change.NewLogin); err != nil {
+		terminal.Output.Log(err)
+	}
+	data, err := json.Marshal(change)
+	if err == nil {
+		_, err = conn.Do("PUBLISH", renamesKey, data)
+	}
+	if err != nil {
+		terminal.Output.Log(err)
+		bot.applyRename(change)
+	}
+}
+
+// applyRename moves the state of the channel and the today stats to the new login
+func (bot *Bot) applyRename(change *identity.Change) {
+	if err := bot.Roles.Reload(); err != nil {
+		terminal.Output.Log(err)
+	}
+	if err := bot.Tells.Load(); err != nil {
+		terminal.Output.Log(err)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	status, err := afk.Return(conn, bot.Channel, change.OldLogin)
+	if err != nil {
+		terminal.Output.Log(err)
+	} else if status != nil {
+		status.Username = change.NewLogin
+		if err := afk.Set(conn, bot.Channel, status); err != nil {
+			terminal.Output.Log(err)
+		}
+	}
+	bot.Warn.Lock()
+	if warnings, ok := bot.Warn.Warnings[change.OldLogin]; ok {
+		bot.Warn.Warnings[change.NewLogin] = warnings
+		delete(bot.Warn.Warnings, change.OldLogin)
+	}
+	bot.Warn.Unlock()
+	bot.Renames <- change
+}
+
+// trackFollowers saves new followers of the channel and reports follow-bot waves
+func (bot *Bot) trackFollowers() {
+	ticker := time.NewTicker(2 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-bot.StopChannel:
+			return
+		case <-ticker.C:
+			if _, err := followers.Poll(bot.ChannelId); err != nil {
+				terminal.Output.Log(err)
+				continue
 			}
-		case "Smartvote":
-			if messageLength == 1 {
-				message.Text = "!vote " + message.Text
-				bot.processCommands(&message)
+			waves, err := followers.CheckWaves(bot.ChannelId, followers.DefaultWaveConfig)
+			if err != nil {
+				terminal.Output.Log(err)
+				continue
 			}
-		case "SpamAttack":
-			bot.Spam.RLock()
-			for i := range bot.Spam.Messages {
-				if strings.Contains(message.Text, bot.Spam.Messages[i]) {
-					bot.ban(message.Username)
-				}
+			for i := range waves {
+				terminal.Output.Println(bot.Channel + " follow-bot " + waves[i].String() + fmt.Sprintf(", run 'followers block %d' to ban them", waves[i].ID))
 			}
-			bot.Spam.RUnlock()
 		}
+	}
+}
 
-	} else if strings.HasPrefix(line, "PING") { // response to keep connection alive
-		bot.Pong(line)
+// blockWave bans followers of the wave, the delay keeps the bot under the chat rate limit
+func (bot *Bot) blockWave(waveID int64) (int, error) {
+	list, err := followers.WaveFollowers(bot.ChannelId, waveID)
+	if err != nil {
+		return 0, err
+	}
+	for i, f := range list {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		bot.ban(f.Login)
+		if err := followers.MarkBlocked(bot.ChannelId, f.UserID); err != nil {
+			return i, err
+		}
+	}
+	return len(list), nil
+}
+
+// Subs and raids give bonus points, gifted subs are counted for the gifter.
+// A mystery gift is followed by a subgift notice for every sub, so it's skipped
+func (bot *Bot) userNotice(tags map[string]string) {
+	var reason string
+	switch tags["msg-id"] {
+	case "sub", "resub", "subgift":
+		reason = points.Sub
+	case "raid":
+		reason = points.Raid
+	default:
+		return
+	}
+	if err := points.Bonus(bot.Channel[1:], tags["login"], reason); err != nil {
+		terminal.Output.Log(err)
 	}
 }
 
 // chat commands
 func (bot *Bot) processCommands(message *Message) {
-	level := bot.Authority[message.Username]
+	level := bot.Roles.Level(message.Username, message.Roles)
 	stream, err := bot.GrpcClient.ParseAndExec(context.Background(), &pb.Message{
-		Channel:  bot.Channel,
-		Username: message.Username,
-		Text:     message.Text,
-		Emotes:   message.Emotes,
-		Id:       message.ID,
-		Level:    int32(level),
+		Channel:   bot.Channel,
+		Username:  message.Username,
+		Text:      message.Text,
+		Emotes:    message.Emotes,
+		Id:        message.ID,
+		Level:     int32(level),
+		Roles:     message.Roles,
+		SubMonths: int32(message.SubMonths),
 	})
 	if err != nil {
 		terminal.Output.Log(err)
@@ -591,66 +996,115 @@ func (bot *Bot) processCommands(message *Message) {
 			terminal.Output.Log(err)
 			break
 		}
+		if in.Result != nil {
+			bot.replyResult(message.Username, in.Result)
+			continue
+		}
 		bot.SendMessage(in.Text)
 	}
 }
 
-func initBadWords() map[string]struct{} {
-	file, err := ioutil.ReadFile("badwords.txt")
+// How the results of failed commands are delivered to the user
+const (
+	PolicyReply   = "reply"
+	PolicyWhisper = "whisper"
+	PolicySilent  = "silent"
+)
+
+var defaultResultPolicy = map[pb.ResultType]string{
+	pb.ResultType_PERMISSION_DENIED: PolicyReply,
+	pb.ResultType_COOLDOWN:          PolicySilent,
+	pb.ResultType_BAD_USAGE:         PolicyReply,
+	pb.ResultType_INTERNAL_ERROR:    PolicySilent,
+}
+
+func resultPolicyField(t pb.ResultType) string {
+	return "policy:" + strings.ToLower(t.String())
+}
+
+// policy is stored per channel in the channel hash, e.g. policy:cooldown -> whisper
+func (bot *Bot) resultPolicy(t pb.ResultType) string {
+	conn := pool.Get()
+	defer conn.Close()
+	policy, err := redis.String(conn.Do("HGET", bot.Channel, resultPolicyField(t)))
 	if err != nil {
-		terminal.Output.Log(err)
-	}
-	var m map[string]struct{}
-	if err = json.Unmarshal(file, &m); err != nil {
-		terminal.Output.Log(err)
+		return defaultResultPolicy[t]
 	}
-	return m
+	return policy
 }
 
-func initAuthority() map[string]int {
-	file, err := ioutil.ReadFile("authority.txt")
-	if err != nil {
-		terminal.Output.Log(err)
+func (bot *Bot) setResultPolicy(result, policy string) error {
+	t, ok := pb.ResultType_value[strings.ToUpper(result)]
+	if !ok || pb.ResultType(t) == pb.ResultType_OK {
+		return errors.New("unknown result: " + result)
 	}
-	var m map[string]string
-	if err = json.Unmarshal(file, &m); err != nil {
-		terminal.Output.Log(err)
+	switch policy {
+	case PolicyReply, PolicyWhisper, PolicySilent:
+	default:
+		return errors.New("unknown policy: " + policy)
 	}
-	mp := make(map[string]int)
-	for k := range m {
-		switch m[k] {
-		case "top":
-			mp[k] = TOP
-		case "middle":
-			mp[k] = MIDDLE
-		case "low":
-			mp[k] = LOW
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("HSET", bot.Channel, resultPolicyField(pb.ResultType(t)), policy)
+	return err
+}
+
+func formatResult(r *pb.Result) string {
+	switch r.Type {
+	case pb.ResultType_PERMISSION_DENIED:
+		return fmt.Sprintf("!%s: %s", r.Command, r.Text)
+	case pb.ResultType_COOLDOWN:
+		return fmt.Sprintf("!%s is on cooldown, try again in %ds", r.Command, r.Cooldown)
+	case pb.ResultType_BAD_USAGE:
+		if r.Help == "" {
+			return fmt.Sprintf("!%s: %s", r.Command, r.Text)
 		}
+		return fmt.Sprintf("!%s: %s. Usage: %s", r.Command, r.Text, r.Help)
+	default:
+		return fmt.Sprintf("!%s failed: %s", r.Command, r.Text)
 	}
-	return mp
 }
 
-func (bot *Bot) changeAuthority(username, level string) {
-	file, err := ioutil.ReadFile("authority.txt")
+func (bot *Bot) replyResult(username string, r *pb.Result) {
+	if r.Type == pb.ResultType_INTERNAL_ERROR {
+		terminal.Output.Log(formatResult(r))
+	}
+	switch bot.resultPolicy(r.Type) {
+	case PolicyReply:
+		bot.SendMessage("@" + username + " " + formatResult(r))
+	case PolicyWhisper:
+		bot.Whisper(username, formatResult(r))
+	}
+}
+
+func initBadWords() map[string]struct{} {
+	file, err := ioutil.ReadFile("badwords.txt")
 	if err != nil {
 		terminal.Output.Log(err)
 	}
-	var m map[string]string
+	var m map[string]struct{}
 	if err = json.Unmarshal(file, &m); err != nil {
 		terminal.Output.Log(err)
 	}
-	m[username] = level
-	newAuthority, err := json.Marshal(m)
-	if err != nil {
-		terminal.Output.Log(err)
+	return m
+}
+
+// level is either a name of the level or a number, "none" removes the override
+func (bot *Bot) changeAuthority(username, level string) {
+	if level == "none" {
+		if err := bot.Roles.RemoveOverride(username); err != nil {
+			terminal.Output.Log(err)
+		}
 		return
 	}
-	err = ioutil.WriteFile("authority.txt", newAuthority, 0644)
+	l, err := roles.ParseLevel(bot.Channel, level)
 	if err != nil {
 		terminal.Output.Log(err)
 		return
 	}
-	bot.Authority = initAuthority()
+	if err := bot.Roles.SetOverride(username, l); err != nil {
+		terminal.Output.Log(err)
+	}
 }
 
 func (bot *Bot) updateEmotes() {
@@ -820,6 +1274,11 @@ func startBot(channel string, botInstances map[string]*Bot) {
 	if err != nil {
 		terminal.Output.Log(err)
 	}
+	resolver, err := roles.NewResolver(channel)
+	if err != nil {
+		terminal.Output.Log(err)
+		return
+	}
 	bot := Bot{
 		Channel:     channel,
 		ChannelId:   channelId,
@@ -827,9 +1286,12 @@ func startBot(channel string, botInstances map[string]*Bot) {
 		OAuth:       os.Getenv("TWITCH_OAUTH_ENV"),
 		StopChannel: make(chan struct{}),
 		BadWords:    initBadWords(),
-		Authority:   initAuthority(),
+		Roles:       resolver,
 		Stats:       make(map[string]*statistics.Stats),
 		Warn:        Warn{Warnings: make(map[string]*[]Warning)},
+		Users:       Users{byID: make(map[string]identity.User), byLogin: make(map[string]string)},
+		Renames:     make(chan *identity.Change),
+		Tells:       tells.NewPending(channel),
 	}
 	botInstances[channel] = &bot
 	bot.Connect()
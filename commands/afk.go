@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"twitchStats/afk"
+	pb "twitchStats/commands/pb"
+)
+
+// !afk [gn|food|work] [for <time>] <message>
+func (s *CommandsServer) AfkCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	status, err := afk.Parse(msg.Username, strings.Fields(body), time.Now())
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	return afk.Set(conn, msg.Channel, status)
+}
+
+// !whoafk
+func (s *CommandsServer) WhoAfkCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	conn := pool.Get()
+	defer conn.Close()
+	list, err := afk.List(conn, msg.Channel)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		stream.Send(&pb.ReturnMessage{Text: "nobody is afk"})
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Since.Before(list[j].Since)
+	})
+	items := make([]string, len(list))
+	for i := range list {
+		items[i] = fmt.Sprintf("%s %s (%s)", list[i].Username, list[i].Describe(), time.Since(list[i].Since).Truncate(time.Minute))
+	}
+	stream.Send(&pb.ReturnMessage{Text: strings.Join(items, ", ")})
+	return nil
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	_, b, _, _ = runtime.Caller(0)
+	basepath   = filepath.Dir(b)
+	name       = "/rpc.json"
+	path       = basepath + name
+)
+
+const defaultAddress = "localhost:3434"
+
+// Client is allowed to call the commands server.
+// It is identified either by the token or by the CN of its certificate, which must be equal to Name
+type Client struct {
+	Name     string `json:"name"`
+	Token    string `json:"token"`
+	CertFile string `json:"cert"`
+	KeyFile  string `json:"key"`
+	// the highest level the client may send in pb.Message
+	MaxLevel int `json:"max_level"`
+}
+
+type TLS struct {
+	CertFile   string `json:"cert"`
+	KeyFile    string `json:"key"`
+	CAFile     string `json:"ca"`
+	ServerName string `json:"server_name"`
+}
+
+type Config struct {
+	// address the server listens on
+	Listen string `json:"listen"`
+	// address the clients dial
+	Address string   `json:"address"`
+	TLS     *TLS     `json:"tls"`
+	Clients []Client `json:"clients"`
+	// address of the http overlay for OBS, it's disabled if empty
+	Overlay string `json:"overlay"`
+	// token of the external player which reports youtube and local songs to the overlay, reports are disabled if empty
+	PlayerToken string `json:"player_token"`
+}
+
+// LoadConfig reads rpc.json, without it the server listens on localhost without any authentication
+func LoadConfig() (*Config, error) {
+	config := &Config{}
+	file, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(file, config); err != nil {
+			return nil, err
+		}
+	}
+	if config.Listen == "" {
+		config.Listen = defaultAddress
+	}
+	if config.Address == "" {
+		config.Address = config.Listen
+	}
+	return config, nil
+}
+
+func (config *Config) client(name string) *Client {
+	for i := range config.Clients {
+		if config.Clients[i].Name == name {
+			return &config.Clients[i]
+		}
+	}
+	return nil
+}
+
+func (config *Config) ServerOptions() ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	if config.TLS != nil {
+		cert, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+		if config.TLS.CAFile != "" {
+			pool, err := loadCA(config.TLS.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			// clients without a certificate are still allowed to authenticate with a token
+			tlsConfig.ClientCAs = pool
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	opts = append(opts,
+		grpc.UnaryInterceptor(config.unaryInterceptor),
+		grpc.StreamInterceptor(config.streamInterceptor),
+	)
+	return opts, nil
+}
+
+// DialOptions for the client with the given name from the config
+func (config *Config) DialOptions(name string) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+	client := config.client(name)
+	if config.TLS != nil {
+		tlsConfig := &tls.Config{ServerName: config.TLS.ServerName}
+		if config.TLS.CAFile != "" {
+			pool, err := loadCA(config.TLS.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if client != nil && client.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(client.CertFile, client.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	if client != nil && client.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: client.Token, secure: config.TLS != nil}))
+	}
+	return opts, nil
+}
+
+func loadCA(file string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse CA certificate " + file)
+	}
+	return pool, nil
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+type clientKey struct{}
+
+// authenticate the caller by the certificate or by the token
+func (config *Config) authenticate(ctx context.Context) (*Client, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			if client := config.client(info.State.VerifiedChains[0][0].Subject.CommonName); client != nil {
+				return client, nil
+			}
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		for i := range config.Clients {
+			if config.Clients[i].Token != "" && subtle.ConstantTimeCompare([]byte(config.Clients[i].Token), []byte(token)) == 1 {
+				return &config.Clients[i], nil
+			}
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "unknown client")
+}
+
+func (config *Config) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if len(config.Clients) == 0 {
+		return handler(ctx, req)
+	}
+	client, err := config.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, clientKey{}, client), req)
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+func (config *Config) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if len(config.Clients) == 0 {
+		return handler(srv, ss)
+	}
+	client, err := config.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), clientKey{}, client)})
+}
+
+// MaxLevel returns the level ceiling of the authenticated client.
+// ok is false when authentication is disabled
+func MaxLevel(ctx context.Context) (level int, ok bool) {
+	client, ok := ctx.Value(clientKey{}).(*Client)
+	if !ok {
+		return 0, false
+	}
+	return client.MaxLevel, true
+}
+
+// RequireLevel fails when the client isn't allowed to act with the given level
+func RequireLevel(ctx context.Context, level int) error {
+	if max, ok := MaxLevel(ctx); ok && max < level {
+		return status.Error(codes.PermissionDenied, "client is not allowed to call this method")
+	}
+	return nil
+}
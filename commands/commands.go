@@ -11,19 +11,25 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
+	"twitchStats/commands/auth"
 	pb "twitchStats/commands/pb"
 	"twitchStats/database"
 	"twitchStats/database/cache"
 	"twitchStats/logsparser"
 	"twitchStats/markov"
+	"twitchStats/modes"
+	"twitchStats/permissions"
+	"twitchStats/player"
 	"twitchStats/request"
+	"twitchStats/roles"
+	"twitchStats/songqueue"
 	"twitchStats/spotify"
 	"twitchStats/statistics"
 
@@ -31,10 +37,11 @@ import (
 	"google.golang.org/grpc"
 )
 
+// Levels of the commands, see roles package for the rest of them
 const (
-	LOW = iota
-	MIDDLE
-	TOP
+	LOW    = roles.Viewer
+	MIDDLE = roles.Middle
+	TOP    = roles.Top
 )
 
 var pool *redis.Pool
@@ -42,6 +49,7 @@ var pool *redis.Pool
 type CommandsServer struct {
 	pb.UnimplementedCommandsServer
 
+	sync.Mutex
 	m map[string]*Commands
 }
 
@@ -51,15 +59,12 @@ type Commands struct {
 }
 
 func (s *CommandsServer) initCommands(channel string) {
-	totalInPlaylist, err := spotify.GetTotalInPlaylist()
-	if err != nil {
-		fmt.Println(err)
-	}
-	c := &Commands{Utils: Utils{RequestedSongs: RequestedSongs{TotalInPlaylist: totalInPlaylist}}, Commands: map[string]*Command{
+	c := &Commands{Utils: Utils{Songs: songqueue.New(channel)}, Commands: map[string]*Command{
 		// !logs <username, timeStart, timeEnd>
 		"logs": &Command{
 			Enabled: true,
 			Name:    "logs",
+			Usage:   "!logs <username>,<timeStart>,<timeEnd>",
 			Cd:      60,
 			Level:   TOP,
 			Handler: s.LogsCommand,
@@ -68,14 +73,88 @@ func (s *CommandsServer) initCommands(channel string) {
 		"smartvote": &Command{
 			Enabled: true,
 			Name:    "smartvote",
+			Usage:   "!smartvote <lowerBound>-<upperBound> [time] [weighted]",
 			Cd:      30,
 			Level:   TOP,
 			Handler: s.SmartVoteCommand,
 		},
+		// !poll <optional: time> <optional: weighted> <title> | <option> | <option>...
+		"poll": &Command{
+			Enabled: true,
+			Name:    "poll",
+			Usage:   "!poll [time] [weighted] <title> | <option> | <option>...",
+			Cd:      30,
+			Level:   TOP,
+			Handler: s.PollCommand,
+		},
+		// !prediction [open <title> | <outcome> | <outcome>... | lock | resolve <outcome> | cancel]
+		"prediction": &Command{
+			Enabled: true,
+			Name:    "prediction",
+			Usage:   "!prediction [open <title> | <outcome> | <outcome>... | lock | resolve <outcome> | cancel]",
+			Cd:      5,
+			Level:   roles.Moderator,
+			Handler: s.PredictionCommand,
+		},
+		// !bet <outcome> <amount|all>
+		"bet": &Command{
+			Enabled: true,
+			Name:    "bet",
+			Usage:   "!bet <outcome> <amount|all>",
+			Cd:      3,
+			Level:   LOW,
+			Handler: s.BetCommand,
+		},
+		// !points [user] | history [user]
+		"points": &Command{
+			Enabled: true,
+			Name:    "points",
+			Usage:   "!points [user] | history [user]",
+			Cd:      5,
+			Level:   LOW,
+			Handler: s.PointsCommand,
+		},
+		// !give <user> <amount>
+		"give": &Command{
+			Enabled: true,
+			Name:    "give",
+			Usage:   "!give <user> <amount>",
+			Cd:      5,
+			Level:   LOW,
+			Handler: s.GiveCommand,
+		},
+		// !leaderboard
+		"leaderboard": &Command{
+			Enabled: true,
+			Name:    "leaderboard",
+			Usage:   "!leaderboard",
+			Cd:      15,
+			Level:   LOW,
+			Handler: s.LeaderboardCommand,
+		},
+		// !addpoints <user> <amount>
+		"addpoints": &Command{
+			Enabled: true,
+			Name:    "addpoints",
+			Usage:   "!addpoints <user> <amount>",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.AddPointsCommand,
+		},
+		// !pointrates [<minute|message|messages|sub|raid> <value>]
+		"pointrates": &Command{
+			Enabled: true,
+			Name:    "pointrates",
+			Usage:   "!pointrates [<minute|message|messages|sub|raid> <value>]",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.PointRatesCommand,
+		},
 		// !stopvote
 		"stopvote": &Command{
 			Enabled: true,
 			Name:    "stopvote",
+			Usage:   "!stopvote",
 			Cd:      15,
 			Level:   TOP,
 			Handler: s.StopVoteCommand,
@@ -84,6 +163,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"voteoptions": &Command{
 			Enabled: true,
 			Name:    "voteoptions",
+			Usage:   "!voteoptions",
 			Cd:      5,
 			Level:   MIDDLE,
 			Handler: s.VoteOptionsCommand,
@@ -92,6 +172,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"asciify": &Command{
 			Enabled: true,
 			Name:    "asciify",
+			Usage:   "!asciify <emote>",
 			Cd:      10,
 			Level:   MIDDLE,
 			Handler: s.Asciify,
@@ -100,6 +181,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"asciify~": &Command{
 			Enabled: true,
 			Name:    "asciify~",
+			Usage:   "!asciify~ <emote>",
 			Cd:      10,
 			Level:   MIDDLE,
 			Handler: s.Asciify,
@@ -108,6 +190,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"ш": &Command{
 			Enabled: true,
 			Name:    "ш",
+			Usage:   "!ш",
 			Cd:      25,
 			Level:   MIDDLE,
 			Handler: s.Markov,
@@ -116,6 +199,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"r": &Command{
 			Enabled: true,
 			Name:    "r",
+			Usage:   "!r <song name | spotify link | youtube link | local:<file>>",
 			Cd:      0,
 			Level:   LOW,
 			Handler: s.RequestTrack,
@@ -124,22 +208,88 @@ func (s *CommandsServer) initCommands(channel string) {
 		"removesong": &Command{
 			Enabled: true,
 			Name:    "removesong",
+			Usage:   "!removesong <song name>",
 			Cd:      0,
 			Level:   LOW,
 			Handler: s.RemoveRequestedTrack,
 		},
+		// !sr <rules|set|ban|unban|bans> <params>
+		"sr": &Command{
+			Enabled: true,
+			Name:    "sr",
+			Usage:   "!sr rules | set <maxlength|limit|cooldown|explicit|level|subonly> <value> | ban|unban <artist|track|keyword> <value> | bans",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.SongRulesCommand,
+		},
 		// !song
 		"song": &Command{
 			Enabled: true,
 			Name:    "song",
+			Usage:   "!song",
 			Cd:      10,
 			Level:   LOW,
 			Handler: s.CurrentTrack,
 		},
+		// !voteskip <optional: percent>
+		"voteskip": &Command{
+			Enabled: true,
+			Name:    "voteskip",
+			Usage:   "!voteskip",
+			Cd:      0,
+			Level:   LOW,
+			Handler: s.VoteSkipCommand,
+		},
+		// !skip
+		"skip": &Command{
+			Enabled: true,
+			Name:    "skip",
+			Usage:   "!skip",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.SkipCommand,
+		},
+		// !pause
+		"pause": &Command{
+			Enabled: true,
+			Name:    "pause",
+			Usage:   "!pause",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.PauseCommand,
+		},
+		// !resume
+		"resume": &Command{
+			Enabled: true,
+			Name:    "resume",
+			Usage:   "!resume",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.ResumeCommand,
+		},
+		// !volume <0-100>
+		"volume": &Command{
+			Enabled: true,
+			Name:    "volume",
+			Usage:   "!volume <0-100>",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.VolumeCommand,
+		},
+		// !lastsongs <optional: count>
+		"lastsongs": &Command{
+			Enabled: true,
+			Name:    "lastsongs",
+			Usage:   "!lastsongs <optional: count>",
+			Cd:      10,
+			Level:   LOW,
+			Handler: s.LastSongsCommand,
+		},
 		// !mr
 		"mr": &Command{
 			Enabled: true,
 			Name:    "mr",
+			Usage:   "!mr",
 			Cd:      10,
 			Level:   LOW,
 			Handler: s.GetUserSongs,
@@ -148,6 +298,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"commands": &Command{
 			Enabled: true,
 			Name:    "commands",
+			Usage:   "!commands",
 			Cd:      3,
 			Level:   LOW,
 			Handler: s.GetCommands,
@@ -156,6 +307,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"level": &Command{
 			Enabled: true,
 			Name:    "level",
+			Usage:   "!level",
 			Cd:      10,
 			Level:   LOW,
 			Handler: s.GetLevel,
@@ -164,22 +316,61 @@ func (s *CommandsServer) initCommands(channel string) {
 		"vote": &Command{
 			Enabled: true,
 			Name:    "vote",
+			Usage:   "!vote <number or name of the option>",
 			Cd:      0,
 			Level:   LOW,
 			Handler: s.VoteCommand,
 		},
-		// !remind <time> <message>
+		// !remind [@user] <time|at 21:00> [every <period>] <message>
 		"remind": &Command{
 			Enabled: true,
 			Name:    "remind",
+			Usage:   "!remind [@user] <time|at 21:00> [every <period>] <message>",
 			Cd:      5,
 			Level:   MIDDLE,
 			Handler: s.RemindCommand,
 		},
-		// !afk <message>
+		// !reminders [list] | cancel <id>
+		"reminders": &Command{
+			Enabled: true,
+			Name:    "reminders",
+			Usage:   "!reminders [list] | cancel <id>",
+			Cd:      5,
+			Level:   MIDDLE,
+			Handler: s.RemindersCommand,
+		},
+		// !timer list | add <name> <interval> [lines <n>] [live] <message> | <message>... | msg <name> <message> | del <name> | on|off <name>
+		"timer": &Command{
+			Enabled: true,
+			Name:    "timer",
+			Usage:   "!timer list | add <name> <interval> [lines <n>] [live] <message> | <message>... | msg <name> <message> | del <name> | on|off <name>",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.TimerCommand,
+		},
+		// !tell [whisper] <user> <message>
+		"tell": &Command{
+			Enabled: true,
+			Name:    "tell",
+			Usage:   "!tell [whisper] <user> <message>",
+			Cd:      5,
+			Level:   MIDDLE,
+			Handler: s.TellCommand,
+		},
+		// !whoafk
+		"whoafk": &Command{
+			Enabled: true,
+			Name:    "whoafk",
+			Usage:   "!whoafk",
+			Cd:      10,
+			Level:   MIDDLE,
+			Handler: s.WhoAfkCommand,
+		},
+		// !afk [gn|food|work] [for <time>] <message>
 		"afk": &Command{
 			Enabled: true,
 			Name:    "afk",
+			Usage:   "!afk [gn|food|work] [for <time>] <message>",
 			Cd:      5,
 			Level:   MIDDLE,
 			Handler: s.AfkCommand,
@@ -188,14 +379,34 @@ func (s *CommandsServer) initCommands(channel string) {
 		"stalk": &Command{
 			Enabled: true,
 			Name:    "stalk",
+			Usage:   "!stalk <username>",
 			Cd:      5,
 			Level:   MIDDLE,
 			Handler: s.StalkCommand,
 		},
+		// !whois <username>
+		"whois": &Command{
+			Enabled: true,
+			Name:    "whois",
+			Usage:   "!whois <username>",
+			Cd:      10,
+			Level:   roles.Moderator,
+			Handler: s.WhoisCommand,
+		},
+		// !seen <username> | optout | optin
+		"seen": &Command{
+			Enabled: true,
+			Name:    "seen",
+			Usage:   "!seen <username> | optout | optin",
+			Cd:      5,
+			Level:   MIDDLE,
+			Handler: s.SeenCommand,
+		},
 		// !disable <command>
 		"disable": &Command{
 			Enabled: true,
 			Name:    "disable",
+			Usage:   "!disable <command>",
 			Cd:      5,
 			Level:   TOP,
 			Handler: s.DisableCommand,
@@ -204,6 +415,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"enable": &Command{
 			Enabled: true,
 			Name:    "enable",
+			Usage:   "!enable <command>",
 			Cd:      5,
 			Level:   TOP,
 			Handler: s.EnableCommand,
@@ -212,6 +424,7 @@ func (s *CommandsServer) initCommands(channel string) {
 		"stats": &Command{
 			Enabled: true,
 			Name:    "stats",
+			Usage:   "!stats <optional: all>",
 			Cd:      5,
 			Level:   MIDDLE,
 			Handler: s.StatsCommand,
@@ -220,37 +433,73 @@ func (s *CommandsServer) initCommands(channel string) {
 		"cmd": &Command{
 			Enabled: true,
 			Name:    "cmd",
+			Usage:   "!cmd <command> <message>",
 			Cd:      5,
 			Level:   TOP,
 			Handler: s.CmdCommand,
 		},
+		// !perm <list|allow|deny|del|check> <params>
+		"perm": &Command{
+			Enabled: true,
+			Name:    "perm",
+			Usage:   "!perm list [command] | allow|deny <command|*> <user:name|role:name|all> [live|offline] [#channel|*] | del <id> | check <command>",
+			Cd:      3,
+			Level:   roles.Moderator,
+			Handler: s.PermCommand,
+		},
 	}}
 	s.m[channel] = c
+	go syncSongs(c.Utils.Songs)
 }
 
 type Utils struct {
-	SmartVote      SmartVote
-	RequestedSongs RequestedSongs
+	Poll     PollBox
+	SkipVote SkipVote
+	Songs    *songqueue.Queue
 }
 
-type SmartVote struct {
-	sync.Mutex
-	Options []*int32
-	Votes   map[string]int
+// modes are changed only by the server, the lock keeps read-modify-write consistent
+var modesLock sync.Mutex
+
+func getModes(channel string) (modes.Set, error) {
+	conn := pool.Get()
+	defer conn.Close()
+	status, err := redis.String(conn.Do("GET", "status:"+channel))
+	if err == redis.ErrNil {
+		return modes.Running, nil
+	}
+	if err != nil {
+		return modes.Running, err
+	}
+	return modes.Parse(status)
 }
 
-type Song struct {
-	Username string
-	SongName string
-	Uri      string
-	Duration time.Duration
-	Position int
+func setModes(channel string, set modes.Set) error {
+	modesLock.Lock()
+	defer modesLock.Unlock()
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", "status:"+channel, set.String())
+	return err
 }
 
-type RequestedSongs struct {
-	sync.RWMutex
-	TotalInPlaylist int
-	Songs           []Song
+// changeMode enables or disables the mode keeping the others
+func changeMode(channel string, mode modes.Mode, enabled bool) (modes.Set, error) {
+	modesLock.Lock()
+	defer modesLock.Unlock()
+	set, err := getModes(channel)
+	if err != nil {
+		return set, err
+	}
+	if enabled {
+		set = set.With(mode)
+	} else {
+		set = set.Without(mode)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", "status:"+channel, set.String())
+	return set, err
 }
 
 func extractCommand(msg *pb.Message) (string, string) {
@@ -261,45 +510,95 @@ func extractCommand(msg *pb.Message) (string, string) {
 	return msg.Text[1:index], msg.Text[index+1:]
 }
 
+// Get commands of the channel, initializing them on the first use
+func (s *CommandsServer) commands(channel string) *Commands {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.m[channel]; !ok {
+		s.initCommands(channel)
+	}
+	return s.m[channel]
+}
+
 func (s *CommandsServer) ParseAndExec(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	if _, ok := s.m[msg.Channel]; !ok {
-		s.initCommands(msg.Channel)
+	commands := s.commands(msg.Channel)
+	// the client can't act on behalf of users with a higher level than it is allowed to
+	if max, ok := auth.MaxLevel(stream.Context()); ok {
+		if int(msg.Level) > max {
+			msg.Level = int32(max)
+		}
+		var allowed []string
+		for _, role := range msg.Roles {
+			if roles.RoleLevel([]string{role}) <= max {
+				allowed = append(allowed, role)
+			}
+		}
+		msg.Roles = allowed
+		// only the top clients read the badges themselves
+		if max < TOP {
+			msg.SubMonths = 0
+		}
 	}
 	splitIndex := strings.Index(msg.Text, " ")
 	level := int(msg.Level)
 	if splitIndex == -1 {
 		splitIndex = len(msg.Text)
 	}
-	if cmd, ok := s.m[msg.Channel].Commands[msg.Text[1:splitIndex]]; ok {
-		if !cmd.Enabled {
-			return errors.New(cmd.Name + " command is disabled")
-		}
-		err := cmd.Cooldown(level)
-		if err != nil {
+	cmd, ok := commands.Commands[msg.Text[1:splitIndex]]
+	if !ok {
+		return errors.New("could not parse command")
+	}
+	if err := cmd.exec(msg, stream, level); err != nil {
+		result := newResult(cmd, err)
+		if result.Type == pb.ResultType_INTERNAL_ERROR {
 			fmt.Println(err)
-			return err
-		}
-		if level >= cmd.Level {
-			err := cmd.Handler(msg, stream)
-			if err != nil {
-				return err
-			}
-			return nil
 		}
-		return errors.New("!" + cmd.Name + ": Not enough rights")
+		return stream.Send(&pb.ReturnMessage{Result: result})
 	}
-	return errors.New("could not parse command")
+	return nil
 }
 
+// Command settings can be changed at runtime, so they are guarded by the mutex together with the cooldown
 type Command struct {
+	sync.Mutex
 	Enabled   bool
 	Name      string
+	Usage     string
 	LastUsage time.Time
 	Cd        int
 	Level     int
 	Handler   func(*pb.Message, pb.Commands_ParseAndExecServer) error
 }
 
+func (cmd *Command) exec(msg *pb.Message, stream pb.Commands_ParseAndExecServer, level int) error {
+	cmd.Lock()
+	enabled, commandLevel := cmd.Enabled, cmd.Level
+	cmd.Unlock()
+	if !enabled {
+		return permissionDenied("command is disabled")
+	}
+	// check rights first, so users without access don't reset the cooldown
+	decision, err := permissions.Check(permissions.Request{
+		Channel:      msg.Channel,
+		Command:      cmd.Name,
+		Username:     msg.Username,
+		Roles:        msg.Roles,
+		Level:        level,
+		CommandLevel: commandLevel,
+		Live:         func() bool { return isLive(msg.Channel) },
+	})
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return permissionDenied(decision.Reason)
+	}
+	if err := cmd.Cooldown(level); err != nil {
+		return err
+	}
+	return cmd.Handler(msg, stream)
+}
+
 func checkForUrl(url string) string {
 	if strings.HasPrefix(url, "https://") &&
 		(strings.HasSuffix(url, ".jpeg") || strings.HasSuffix(url, ".jpg") || strings.HasSuffix(url, ".png")) {
@@ -312,143 +611,265 @@ func (cmd *Command) Cooldown(level int) error {
 	if level >= TOP {
 		return nil
 	}
+	cmd.Lock()
+	defer cmd.Unlock()
 	t := time.Since(cmd.LastUsage)
-	if t >= time.Duration(cmd.Cd)*time.Second {
+	cd := time.Duration(cmd.Cd) * time.Second
+	if t >= cd {
 		cmd.LastUsage = time.Now()
 		return nil
 	} else {
-		return errors.New("Command on cooldown")
+		return onCooldown(cd - t)
 	}
 }
 
-func (s *CommandsServer) StopVoteCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	stream.Send(&pb.ReturnMessage{Text: ""})
-	return nil
+func (cmd *Command) level() int {
+	cmd.Lock()
+	defer cmd.Unlock()
+	return cmd.Level
 }
 
-// Delete all songs up to the current one
-func (req *RequestedSongs) clear() (int, error) {
-	track, err := spotify.GetCurrentTrack()
-	if err != nil {
-		fmt.Println(err)
-		return 0, err
-	}
-	req.Lock()
-	defer req.Unlock()
-	var i int
-	reqLength := len(req.Songs)
-	for i = 0; i < reqLength; i++ {
-		fmt.Println(req.Songs[i].SongName)
-		if req.Songs[i].SongName == track {
-			break
-		}
-	}
-	if i == reqLength {
-		return 0, errors.New("No songs found")
-	}
-	// How many previous songs we want to keep
-	keep := 1
-	if i >= keep {
-		req.Songs = req.Songs[i-keep:]
-	} else {
-		keep = i
-	}
-	return keep, nil
+func (cmd *Command) setEnabled(enabled bool) {
+	cmd.Lock()
+	cmd.Enabled = enabled
+	cmd.Unlock()
 }
 
 // Get all songs that the user requested
 func (s *CommandsServer) GetUserSongs(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	songs := []string{}
-	index, err := s.m[msg.Channel].Utils.RequestedSongs.clear()
+	items, err := s.commands(msg.Channel).Utils.Songs.Upcoming()
 	if err != nil {
 		return err
 	}
-	s.m[msg.Channel].Utils.RequestedSongs.RLock()
-	defer s.m[msg.Channel].Utils.RequestedSongs.RUnlock()
-	var accDuration time.Duration
-	for i := index; i < len(s.m[msg.Channel].Utils.RequestedSongs.Songs); i++ {
-		if s.m[msg.Channel].Utils.RequestedSongs.Songs[i].Username == msg.Username {
-			var t string
-			if i == index {
-				t = "playing"
-			} else {
-				t = accDuration.Round(time.Second).String()
-			}
-			songs = append(songs, s.m[msg.Channel].Utils.RequestedSongs.Songs[i].SongName+" ["+t+"]")
+	songs := []string{}
+	for _, item := range items {
+		if item.Username != msg.Username {
+			continue
+		}
+		t := "?"
+		if item.Status == songqueue.Playing {
+			t = "playing"
+		} else if item.ETA >= 0 {
+			t = item.ETA.Round(time.Second).String()
 		}
-		accDuration += s.m[msg.Channel].Utils.RequestedSongs.Songs[i].Duration
+		songs = append(songs, item.Name+" ["+t+"]")
 	}
 
 	if len(songs) == 0 {
-		return errors.New("No songs found")
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s you don't have any requested songs", msg.Username)})
+		return nil
 	}
 
-	retMsg := "Your requested songs: " + songs[0]
-	for i := 1; i < len(songs); i++ {
-		retMsg += ", " + songs[i]
-	}
+	retMsg := "Your requested songs: " + strings.Join(songs, ", ")
 	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s", msg.Username, retMsg)})
 	return nil
 }
 
 func (s *CommandsServer) RequestTrack(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
 	_, params := extractCommand(msg)
-	track, err := spotify.SearchTrack(params)
+	if params == "" {
+		return badUsage("need song name or link")
+	}
+	track, candidates, err := player.Find(params)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
-	if len(track.Tracks.Items) == 0 {
-		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s track wansn't found", msg.Username)})
-		return errors.New("Track wasn't found")
+	if track == nil && len(candidates) == 0 {
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s track wasn't found", msg.Username)})
+		return nil
+	}
+	if track == nil {
+		names := make([]string, len(candidates))
+		for i := range candidates {
+			names[i] = candidates[i].Name
+		}
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s did you mean: %s? Send the name or the link", msg.Username, strings.Join(names, " | "))})
+		return nil
 	}
 
-	err = spotify.AddToPlaylist(track.Tracks.Items[0].URI)
+	candidate := &songqueue.Candidate{
+		URI:      track.URI,
+		Name:     track.Name,
+		Artists:  track.Artists,
+		Duration: track.Duration,
+		Explicit: track.Explicit,
+	}
+	err = songqueue.Validate(msg.Channel, candidate, &songqueue.Requester{Username: msg.Username, Level: int(msg.Level), Roles: msg.Roles})
+	var rejection *songqueue.Rejection
+	if errors.As(err, &rejection) {
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s can't be requested: %s", msg.Username, track.Name, rejection.Reason)})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	provider, err := player.ForURI(track.URI)
+	if err != nil {
+		return err
+	}
+	snapshotID, err := provider.Enqueue(msg.Channel, track)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
-	trackName := track.Tracks.Items[0].Artists[0].Name + " - " + track.Tracks.Items[0].Name
-	s.m[msg.Channel].Utils.RequestedSongs.Lock()
-	defer s.m[msg.Channel].Utils.RequestedSongs.Unlock()
-	s.m[msg.Channel].Utils.RequestedSongs.TotalInPlaylist += 1
-	s.m[msg.Channel].Utils.RequestedSongs.Songs = append(s.m[msg.Channel].Utils.RequestedSongs.Songs, Song{Username: msg.Username, SongName: trackName, Uri: track.Tracks.Items[0].URI, Duration: time.Duration(track.Tracks.Items[0].DurationMs) * time.Millisecond, Position: s.m[msg.Channel].Utils.RequestedSongs.TotalInPlaylist})
-	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s was added to the playlist", msg.Username, trackName)})
+	_, err = songqueue.Add(songqueue.Entry{
+		Channel:    msg.Channel,
+		URI:        track.URI,
+		Name:       track.Name,
+		Username:   msg.Username,
+		Duration:   track.Duration,
+		SnapshotID: snapshotID,
+	})
+	if err != nil {
+		return err
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s was added to the playlist", msg.Username, track.Name)})
 	return nil
 }
 
 func (s *CommandsServer) RemoveRequestedTrack(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
 	_, params := extractCommand(msg)
-	index, err := s.m[msg.Channel].Utils.RequestedSongs.clear()
+	if params == "" {
+		return badUsage("need song name")
+	}
+	// only the top level can remove songs of other users
+	username := msg.Username
+	if msg.Level >= TOP {
+		username = ""
+	}
+	queue := s.commands(msg.Channel).Utils.Songs
+	item, err := queue.Find(username, params)
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	provider, err := player.ForURI(item.URI)
 	if err != nil {
 		return err
 	}
-	s.m[msg.Channel].Utils.RequestedSongs.Lock()
-	defer s.m[msg.Channel].Utils.RequestedSongs.Unlock()
-	length := len(s.m[msg.Channel].Utils.RequestedSongs.Songs)
-	i := length
-	for ; i >= 0; i-- {
-		if params == s.m[msg.Channel].Utils.RequestedSongs.Songs[i].SongName {
-			break
-		}
+	if provider.Name() == "spotify" && item.Position == -1 {
+		return badUsage("track wasn't found in the playlist")
 	}
-	if i == -1 {
-		return errors.New("No track found")
+	if err := provider.Remove(msg.Channel, item.URI, item.Position); err != nil {
+		return err
 	}
-	for idx := i + 1; idx < length; idx++ {
-		s.m[msg.Channel].Utils.RequestedSongs.Songs[idx].Position -= 1
+	if err := songqueue.SetStatus(item.ID, songqueue.Removed); err != nil {
+		return err
 	}
-	s.m[msg.Channel].Utils.RequestedSongs.Songs = append(s.m[msg.Channel].Utils.RequestedSongs.Songs[:i], s.m[msg.Channel].Utils.RequestedSongs.Songs[i+1:]...)
-	song := s.m[msg.Channel].Utils.RequestedSongs.Songs[index]
-	err = spotify.RemoveTrack(song.Uri, song.Position)
-	if err != nil {
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s was removed from the queue", msg.Username, item.Name)})
+	return nil
+}
+
+func (s *CommandsServer) SongRulesCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	if len(params) == 0 {
+		return badUsage("need subcommand")
+	}
+	switch params[0] {
+	case "rules":
+		rules, err := songqueue.LoadRules(msg.Channel)
+		if err != nil {
+			return err
+		}
+		stream.Send(&pb.ReturnMessage{Text: "Song requests: " + rules.String()})
+		return nil
+	case "set":
+		if len(params) != 3 {
+			return badUsage("need rule and value")
+		}
+		rules, err := songqueue.SetRule(msg.Channel, params[1], params[2])
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		stream.Send(&pb.ReturnMessage{Text: "Song requests: " + rules.String()})
+		return nil
+	case "ban", "unban":
+		if len(params) < 3 {
+			return badUsage("need kind and value")
+		}
+		value := strings.Join(params[2:], " ")
+		var err error
+		if params[0] == "ban" {
+			err = songqueue.AddBan(msg.Channel, params[1], value)
+		} else {
+			err = songqueue.RemoveBan(msg.Channel, params[1], value)
+		}
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s %s was %sned", msg.Username, params[1], value, params[0])})
+		return nil
+	case "bans":
+		bans, err := songqueue.Bans(msg.Channel)
+		if err != nil {
+			return err
+		}
+		if len(bans) == 0 {
+			stream.Send(&pb.ReturnMessage{Text: "No bans"})
+			return nil
+		}
+		str := make([]string, len(bans))
+		for i, ban := range bans {
+			str[i] = ban.Kind + ": " + ban.Value
+		}
+		stream.Send(&pb.ReturnMessage{Text: strings.Join(str, ", ")})
 		return nil
 	}
-	return nil
+	return badUsage("unknown subcommand " + params[0])
+}
+
+// How often the queue is reconciled with spotify
+const songsSyncInterval = 15 * time.Second
+
+func syncSongs(queue *songqueue.Queue) {
+	ticker := time.NewTicker(songsSyncInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		state, err := fetchPlayback(queue.Channel)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if err := queue.Update(*state); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func fetchPlayback(channel string) (*songqueue.State, error) {
+	client := spotify.ForChannel(channel)
+	playlist, err := client.Playlist()
+	if err != nil {
+		return nil, err
+	}
+	playback, err := client.Playback()
+	if err != nil {
+		return nil, err
+	}
+	state := &songqueue.State{
+		SnapshotID:      playlist.SnapshotID,
+		CurrentURI:      playback.Item.URI,
+		CurrentName:     playback.Item.Name,
+		CurrentDuration: time.Duration(playback.Item.DurationMs) * time.Millisecond,
+		Progress:        time.Duration(playback.ProgressMs) * time.Millisecond,
+		Playing:         playback.IsPlaying,
+		Checked:         time.Now(),
+	}
+	if len(playback.Item.Artists) > 0 {
+		state.CurrentName = playback.Item.Artists[0].Name + " - " + playback.Item.Name
+	}
+	for _, item := range playlist.Tracks.Items {
+		state.Tracks = append(state.Tracks, songqueue.Track{
+			URI:      item.Track.URI,
+			Duration: time.Duration(item.Track.DurationMs) * time.Millisecond,
+		})
+	}
+	return state, nil
 }
 
 func (s *CommandsServer) CurrentTrack(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	track, err := spotify.GetCurrentTrack()
+	track, err := spotify.ForChannel(msg.Channel).CurrentTrack()
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -464,7 +885,7 @@ func (s *CommandsServer) LogsCommand(msg *pb.Message, stream pb.Commands_ParseAn
 	// username, timeStart, timeEnd (utt)
 	utt := strings.Split(params, ",")
 	if len(utt) < 3 {
-		return errors.New("!logs: wrong amount of params")
+		return badUsage("wrong amount of params")
 	}
 	username := utt[0]
 	timeStart, timeEnd, err := logsparser.ParseTime(utt[1], utt[2])
@@ -513,101 +934,6 @@ func (s *CommandsServer) LogsCommand(msg *pb.Message, stream pb.Commands_ParseAn
 	return nil
 }
 
-func (s *CommandsServer) SmartVoteCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	_, params := extractCommand(msg)
-	split := strings.Split(params, "-")
-	if len(split) < 2 {
-		return errors.New("!smartvote: not enough args")
-	}
-
-	conn := pool.Get()
-	defer conn.Close()
-	_, err := conn.Do("SET", "status:"+msg.Channel, "Smartvote")
-	if err != nil {
-		return err
-	}
-
-	str := "GOLOSOVANIE"
-	lowerBound, err := strconv.Atoi(split[0])
-	if err != nil {
-		return err
-	}
-	upperBound, err := strconv.Atoi(split[1])
-	if err != nil {
-		return err
-	}
-	s.m[msg.Channel].Utils.SmartVote.Options = make([]*int32, upperBound+1)
-	s.m[msg.Channel].Utils.SmartVote.Votes = make(map[string]int)
-	for i := lowerBound; i <= upperBound; i++ {
-		var value int32
-		s.m[msg.Channel].Utils.SmartVote.Options[i] = &value
-	}
-	stream.Send(&pb.ReturnMessage{Text: str})
-	return nil
-}
-
-func (s *CommandsServer) VoteOptionsCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	conn := pool.Get()
-	defer conn.Close()
-	status, err := redis.String(conn.Do("GET", "status:"+msg.Channel))
-	if err != nil {
-		return err
-	}
-	if status != "Smartvote" {
-		return errors.New("There is not any vote")
-	}
-	keys := []int{}
-	for i, v := range s.m[msg.Channel].Utils.SmartVote.Options {
-		if v == nil {
-			continue
-		}
-		keys = append(keys, i)
-	}
-	var str string
-	total := len(s.m[msg.Channel].Utils.SmartVote.Votes)
-	str = fmt.Sprintf("Total votes %d: ", total)
-	value := atomic.LoadInt32(s.m[msg.Channel].Utils.SmartVote.Options[keys[0]])
-	percent := float32(value) / float32(total) * 100
-	str += fmt.Sprintf("%d: %.1f%%(%d)", keys[0], percent, value)
-	for i := 1; i < len(keys); i++ {
-		value = atomic.LoadInt32(s.m[msg.Channel].Utils.SmartVote.Options[keys[i]])
-		percent = float32(value) / float32(total) * 100
-		str += fmt.Sprintf(", %d: %.1f%%(%d)", keys[i], percent, value)
-	}
-	stream.Send(&pb.ReturnMessage{Text: str})
-	return nil
-}
-
-func (s *CommandsServer) VoteCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	conn := pool.Get()
-	defer conn.Close()
-	status, err := redis.String(conn.Do("GET", "status:"+msg.Channel))
-	if err != nil {
-		return err
-	}
-	if status != "Smartvote" {
-		return errors.New("Wrong status")
-	}
-	_, body := extractCommand(msg)
-	vote, err := strconv.Atoi(body)
-	if err != nil {
-		return err
-	}
-	if vote < 0 || vote >= len(s.m[msg.Channel].Utils.SmartVote.Options) ||
-		s.m[msg.Channel].Utils.SmartVote.Options[vote] == nil {
-		return errors.New("!vote: out of bounds")
-	}
-	// consider only one vote
-	atomic.AddInt32(s.m[msg.Channel].Utils.SmartVote.Options[vote], 1)
-	s.m[msg.Channel].Utils.SmartVote.Lock()
-	if v, ok := s.m[msg.Channel].Utils.SmartVote.Votes[msg.Username]; ok {
-		atomic.AddInt32(s.m[msg.Channel].Utils.SmartVote.Options[v], -1)
-	}
-	s.m[msg.Channel].Utils.SmartVote.Votes[msg.Username] = vote
-	s.m[msg.Channel].Utils.SmartVote.Unlock()
-	return nil
-}
-
 // check if there is an emote in the database
 func FfzBttv(emote string) (string, error) {
 	db := database.Connect()
@@ -684,16 +1010,16 @@ func (s *CommandsServer) Asciify(msg *pb.Message, stream pb.Commands_ParseAndExe
 	var err error
 	if len(params) > 1 {
 		if level < TOP {
-			return errors.New("!asciify: Not enough rights to change settings")
+			return permissionDenied("Not enough rights to change settings")
 		}
 		width, err = strconv.Atoi(params[1])
 		if err != nil {
-			return err
+			return badUsage("width must be a number")
 		}
 		if len(params) == 3 {
 			thMultTemp, err := strconv.ParseFloat(params[2], 32)
 			if err != nil {
-				return err
+				return badUsage("threshold multiplier must be a number")
 			}
 			thMult = float32(thMultTemp)
 		}
@@ -701,8 +1027,7 @@ func (s *CommandsServer) Asciify(msg *pb.Message, stream pb.Commands_ParseAndExe
 	}
 
 	if len(params[0]) == 0 {
-		err := errors.New("!asciify: need emote")
-		return err
+		return badUsage("need emote")
 	}
 	emote := params[0] //msg.Emotes[:strigns.Index(msg.Emotes, ":")]
 	url, err := FfzBttv(emote)
@@ -758,118 +1083,16 @@ func (s *CommandsServer) GetCommands(msg *pb.Message, stream pb.Commands_ParseAn
 }
 
 func (s *CommandsServer) GetLevel(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	var message string
-	switch msg.Level {
-	case 0:
-		message = "low"
-	case 1:
-		message = "middle"
-	case 2:
-		message = "top"
-	}
+	message := roles.Name(int(msg.Level))
 	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s Your level is: %s", msg.Username, message)})
 	return nil
 }
 
-func (s *CommandsServer) RemindCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	_, body := extractCommand(msg)
-	params := strings.Split(body, " ")
-	if len(params[0]) == 0 {
-		return errors.New("!remind: not enough params")
-	}
-	t, err := time.ParseDuration(params[0])
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	var remindMessage string
-	if len(params) < 2 {
-		remindMessage = ""
-	} else {
-		remindMessage = params[1]
-	}
-	retMsg := "@" + msg.Username + " " + remindMessage
-	time.AfterFunc(t, func() {
-		conn := pool.Get()
-		defer conn.Close()
-		conn.Send("PUBLISH", "reminders:"+msg.Channel, retMsg)
-		conn.Flush()
-	})
-	return nil
-}
-
-func (s *CommandsServer) AfkCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	_, body := extractCommand(msg)
-	conn := pool.Get()
-	defer conn.Close()
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	err := enc.Encode(struct {
-		Message string
-		Time    time.Time
-	}{body, time.Now()})
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	_, err = conn.Do("HSET", msg.Channel, "afk:"+msg.Username, b.Bytes())
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (s *CommandsServer) StalkCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
-	_, body := extractCommand(msg)
-	timeNow := time.Now()
-	timeStart := timeNow.Add(-24 * time.Hour)
-	timeEnd := timeNow
-
-	rootPath := "../logsparser/" + msg.Channel[1:] + "/"
-	files, err := ioutil.ReadDir(rootPath)
-	if err != nil {
-		return err
-	}
-	retMessage := "Found nothing, sorry! :)"
-	for _, f := range files {
-		if f.IsDir() {
-			continue
-		}
-		fileName := f.Name()
-		logfile, err := os.Open(rootPath + fileName)
-		if err != nil {
-			return err
-		}
-		if _, err := logfile.Seek(0, io.SeekStart); err != nil {
-			panic(err)
-		}
-		r := bufio.NewScanner(logfile)
-		for r.Scan() {
-			str := r.Text()
-			parsedStr, err := logsparser.Parse(str, "", body, timeStart, timeEnd)
-			if err != nil {
-				continue
-			}
-			t, err := time.Parse(logsparser.Layout, parsedStr[1])
-			if err != nil {
-				continue
-			}
-			retMessage = fmt.Sprintf("%s was seen %s ago, last message: %s", body, time.Since(t).Truncate(time.Second), parsedStr[3])
-		}
-		if err := r.Err(); err != nil {
-			return err
-		}
-		logfile.Close()
-	}
-	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s", msg.Username, retMessage)})
-	return nil
-}
-
 func (s *CommandsServer) DisableCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
 	_, body := extractCommand(msg)
 	retMessage := "Command wasn't found"
 	if _, ok := s.m[msg.Channel].Commands[body]; ok {
-		s.m[msg.Channel].Commands[body].Enabled = false
+		s.m[msg.Channel].Commands[body].setEnabled(false)
 		retMessage = fmt.Sprintf("!%s command has been disabled", body)
 	}
 	stream.Send(&pb.ReturnMessage{Text: retMessage})
@@ -880,7 +1103,7 @@ func (s *CommandsServer) EnableCommand(msg *pb.Message, stream pb.Commands_Parse
 	_, body := extractCommand(msg)
 	retMessage := "Command wasn't found"
 	if _, ok := s.m[msg.Channel].Commands[body]; ok {
-		s.m[msg.Channel].Commands[body].Enabled = true
+		s.m[msg.Channel].Commands[body].setEnabled(true)
 		retMessage = fmt.Sprintf("!%s command has been enabled", body)
 	}
 	stream.Send(&pb.ReturnMessage{Text: retMessage})
@@ -901,7 +1124,8 @@ func (s *CommandsServer) StatsCommand(msg *pb.Message, stream pb.Commands_ParseA
 	dec.Decode(&m)
 	stats, ok := m[msg.Username]
 	if !ok {
-		return errors.New("!stats: username not found")
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s there are no stats for you yet", msg.Username)})
+		return nil
 	}
 	stats.WatchTime += time.Since(stats.LastCheck)
 	stats.LastCheck = time.Now()
@@ -920,21 +1144,11 @@ func (s *CommandsServer) StatsCommand(msg *pb.Message, stream pb.Commands_ParseA
 
 	var retMessage string
 	if body == "all" {
-		db := database.Connect()
-		defer db.Close()
-		tx, err := db.Begin()
+		msgCount, watchTime, err := allTimeStats(msg.Channel, msg.Username)
 		if err != nil {
-			fmt.Println(err)
-		}
-		defer tx.Rollback()
-
-		var msgCount int
-		var watchTime int
-		if err := tx.QueryRow("SELECT MsgCount, WatchTime FROM Stats WHERE Channel=$1 AND Username=$2;", msg.Channel[1:], msg.Username).Scan(&msgCount, &watchTime); err != nil {
 			return err
 		}
-		retMessage = fmt.Sprintf("@%s your all time stats: messages: %d, watch time: %s", msg.Username, msgCount, time.Duration(watchTime).Truncate(time.Second))
-		tx.Commit()
+		retMessage = fmt.Sprintf("@%s your all time stats: messages: %d, watch time: %s", msg.Username, msgCount, watchTime.Truncate(time.Second))
 	} else {
 		retMessage = fmt.Sprintf("@%s your stats for today: messages: %d, watch time: %s", msg.Username, stats.MsgCount, stats.WatchTime.Truncate(time.Second))
 	}
@@ -946,7 +1160,7 @@ func (s *CommandsServer) CmdCommand(msg *pb.Message, stream pb.Commands_ParseAnd
 	_, body := extractCommand(msg)
 	args := strings.Split(body, " ")
 	if len(args) < 2 {
-		return errors.New("!cmd: not enough args")
+		return badUsage("not enough args")
 	}
 	s.m[msg.Channel].Commands[args[0]] = &Command{
 		Enabled: true,
@@ -967,14 +1181,29 @@ func newServer() *CommandsServer {
 }
 
 func main() {
-	lis, err := net.Listen("tcp", "localhost:3434")
+	config, err := auth.LoadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	lis, err := net.Listen("tcp", config.Listen)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	var opts []grpc.ServerOption
+	opts, err := config.ServerOptions()
+	if err != nil {
+		log.Fatalf("failed to set up credentials: %v", err)
+	}
 	grpcServer := grpc.NewServer(opts...)
-	pb.RegisterCommandsServer(grpcServer, newServer())
+	server := newServer()
+	pb.RegisterCommandsServer(grpcServer, server)
 	pool = cache.GetPool()
+	go sendReminders()
+	if config.Overlay != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(config.Overlay, server.overlayHandler(config.PlayerToken)))
+		}()
+		fmt.Println("Overlay started on http://" + config.Overlay)
+	}
 	fmt.Println("Grpc server started")
 	grpcServer.Serve(lis)
 }
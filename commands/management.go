@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+	"sync/atomic"
+	"time"
+	"twitchStats/commands/auth"
+	pb "twitchStats/commands/pb"
+	"twitchStats/database"
+	"twitchStats/modes"
+	"twitchStats/polls"
+	"twitchStats/statistics"
+
+	"github.com/gomodule/redigo/redis"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func commandInfo(cmd *Command) *pb.CommandInfo {
+	cmd.Lock()
+	defer cmd.Unlock()
+	return &pb.CommandInfo{
+		Name:     cmd.Name,
+		Usage:    cmd.Usage,
+		Enabled:  cmd.Enabled,
+		Cooldown: int32(cmd.Cd),
+		Level:    int32(cmd.Level),
+	}
+}
+
+func (s *CommandsServer) ListCommands(ctx context.Context, req *pb.ChannelRequest) (*pb.CommandList, error) {
+	commands := s.commands(req.Channel)
+	list := &pb.CommandList{}
+	for _, cmd := range commands.Commands {
+		list.Commands = append(list.Commands, commandInfo(cmd))
+	}
+	sort.Slice(list.Commands, func(i, j int) bool {
+		return list.Commands[i].Name < list.Commands[j].Name
+	})
+	return list, nil
+}
+
+func (s *CommandsServer) UpdateCommand(ctx context.Context, req *pb.UpdateCommandRequest) (*pb.CommandInfo, error) {
+	if err := auth.RequireLevel(ctx, TOP); err != nil {
+		return nil, err
+	}
+	if req.Command == nil {
+		return nil, status.Error(codes.InvalidArgument, "command is required")
+	}
+	cmd, ok := s.commands(req.Channel).Commands[req.Command.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "command %s wasn't found", req.Command.Name)
+	}
+	// validate the whole mask first, so the command isn't updated partially
+	for _, field := range req.UpdateMask {
+		switch field {
+		case "enabled", "level":
+		case "cooldown":
+			if req.Command.Cooldown < 0 {
+				return nil, status.Error(codes.InvalidArgument, "cooldown can't be negative")
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown field %s", field)
+		}
+	}
+	cmd.Lock()
+	for _, field := range req.UpdateMask {
+		switch field {
+		case "enabled":
+			cmd.Enabled = req.Command.Enabled
+		case "cooldown":
+			cmd.Cd = int(req.Command.Cooldown)
+		case "level":
+			cmd.Level = int(req.Command.Level)
+		}
+	}
+	cmd.Unlock()
+	return commandInfo(cmd), nil
+}
+
+func (s *CommandsServer) StartPoll(ctx context.Context, req *pb.StartPollRequest) (*pb.PollResults, error) {
+	if err := auth.RequireLevel(ctx, TOP); err != nil {
+		return nil, err
+	}
+	if req.Duration < 0 {
+		return nil, status.Error(codes.InvalidArgument, "duration can't be negative")
+	}
+	poll, err := polls.New(req.Channel, req.Title, req.Options, req.Weighted, time.Duration(req.Duration)*time.Second)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.startPoll(req.Channel, poll); err != nil {
+		return nil, err
+	}
+	results := poll.Results()
+	return pollResults(&results), nil
+}
+
+func (s *CommandsServer) StopPoll(ctx context.Context, req *pb.ChannelRequest) (*pb.PollResults, error) {
+	if err := auth.RequireLevel(ctx, TOP); err != nil {
+		return nil, err
+	}
+	poll := s.commands(req.Channel).Utils.Poll.Get()
+	if poll == nil {
+		return nil, status.Error(codes.NotFound, "there is no poll")
+	}
+	return s.closePoll(req.Channel, poll)
+}
+
+func (s *CommandsServer) GetPollResults(ctx context.Context, req *pb.ChannelRequest) (*pb.PollResults, error) {
+	poll := s.commands(req.Channel).Utils.Poll.Get()
+	if poll == nil {
+		return &pb.PollResults{Channel: req.Channel}, nil
+	}
+	results := poll.Results()
+	return pollResults(&results), nil
+}
+
+func (s *CommandsServer) GetPollHistory(ctx context.Context, req *pb.ChannelRequest) (*pb.PollHistory, error) {
+	history, err := polls.History(req.Channel, 20)
+	if err != nil {
+		return nil, err
+	}
+	converted := &pb.PollHistory{}
+	for i := range history {
+		converted.Polls = append(converted.Polls, pollResults(&history[i]))
+	}
+	return converted, nil
+}
+
+// Send poll results every time they change until the poll is stopped
+func (s *CommandsServer) WatchPollResults(req *pb.ChannelRequest, stream pb.Commands_WatchPollResultsServer) error {
+	box := &s.commands(req.Channel).Utils.Poll
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	version := int32(-1)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+			current := atomic.LoadInt32(&box.Version)
+			if current == version {
+				continue
+			}
+			version = current
+			results, err := s.GetPollResults(stream.Context(), req)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(results); err != nil {
+				return err
+			}
+			if !results.Active {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *CommandsServer) GetSongQueue(ctx context.Context, req *pb.ChannelRequest) (*pb.SongQueue, error) {
+	items, err := s.commands(req.Channel).Utils.Songs.Upcoming()
+	if err != nil {
+		return nil, err
+	}
+	queue := &pb.SongQueue{}
+	for _, item := range items {
+		queue.Songs = append(queue.Songs, &pb.SongInfo{
+			Name:     item.Name,
+			Uri:      item.URI,
+			Username: item.Username,
+			Duration: int32(item.Duration / time.Second),
+			Position: int32(item.Position),
+		})
+	}
+	return queue, nil
+}
+
+func (s *CommandsServer) GetUserStats(ctx context.Context, req *pb.UserRequest) (*pb.UserStats, error) {
+	userStats := &pb.UserStats{Channel: req.Channel, Username: req.Username}
+	m, err := todayStats(req.Channel)
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	if stats, ok := m[req.Username]; ok {
+		userStats.MsgCount = int32(stats.MsgCount)
+		userStats.WatchTime = int64((stats.WatchTime + time.Since(stats.LastCheck)) / time.Second)
+	}
+	msgCount, watchTime, err := allTimeStats(req.Channel, req.Username)
+	if err == nil {
+		userStats.TotalMsgCount = int32(msgCount)
+		userStats.TotalWatchTime = int64(watchTime / time.Second)
+	}
+	return userStats, nil
+}
+
+func (s *CommandsServer) SetChannelStatus(ctx context.Context, req *pb.ChannelStatus) (*pb.ChannelStatus, error) {
+	if err := auth.RequireLevel(ctx, TOP); err != nil {
+		return nil, err
+	}
+	set, err := modes.Parse(req.Status)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// leaving the vote closes the poll, so its results aren't lost
+	if poll := s.commands(req.Channel).Utils.Poll.Get(); poll != nil && !set.Has(modes.Vote) {
+		if _, err := s.closePoll(req.Channel, poll); err != nil {
+			return nil, err
+		}
+	}
+	if err := setModes(req.Channel, set); err != nil {
+		return nil, err
+	}
+	return &pb.ChannelStatus{Channel: req.Channel, Status: set.String()}, nil
+}
+
+func (s *CommandsServer) SetChannelMode(ctx context.Context, req *pb.ChannelMode) (*pb.ChannelStatus, error) {
+	if err := auth.RequireLevel(ctx, TOP); err != nil {
+		return nil, err
+	}
+	mode, err := modes.ParseMode(req.Mode)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if mode == modes.Vote && !req.Enabled {
+		if poll := s.commands(req.Channel).Utils.Poll.Get(); poll != nil {
+			if _, err := s.closePoll(req.Channel, poll); err != nil {
+				return nil, err
+			}
+		}
+	}
+	set, err := changeMode(req.Channel, mode, req.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ChannelStatus{Channel: req.Channel, Status: set.String()}, nil
+}
+
+// Stats for today which are written by the bot into the channel hash
+func todayStats(channel string) (map[string]statistics.Stats, error) {
+	conn := pool.Get()
+	defer conn.Close()
+	data, err := redis.Bytes(conn.Do("HGET", channel, "stats"))
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]statistics.Stats
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func allTimeStats(channel, username string) (int, time.Duration, error) {
+	db := database.Connect()
+	defer db.Close()
+	var msgCount int
+	var watchTime int64
+	err := db.QueryRow("SELECT MsgCount, WatchTime FROM Stats WHERE Channel=$1 AND Username=$2;", channel[1:], username).Scan(&msgCount, &watchTime)
+	if err != nil {
+		return 0, 0, err
+	}
+	return msgCount, time.Duration(watchTime), nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"twitchStats/songqueue"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// How often the overlay checks the queue for changes
+const overlayInterval = 2 * time.Second
+
+type overlayTrack struct {
+	Name      string `json:"name"`
+	URI       string `json:"uri"`
+	Requester string `json:"requester,omitempty"`
+	// seconds
+	Duration int `json:"duration"`
+	ETA      int `json:"eta,omitempty"`
+}
+
+type overlayState struct {
+	Channel string        `json:"channel"`
+	Current *overlayTrack `json:"current"`
+	Playing bool          `json:"playing"`
+	// seconds at the moment of the update, the page moves it on by itself
+	Progress int            `json:"progress"`
+	Updated  int64          `json:"updated"`
+	Queue    []overlayTrack `json:"queue"`
+}
+
+// Get commands of the channel without initializing them
+func (s *CommandsServer) initialized(channel string) (*Commands, bool) {
+	s.Lock()
+	defer s.Unlock()
+	commands, ok := s.m[channel]
+	return commands, ok
+}
+
+// overlayCommands starts the channel only if its bot is running, so the overlay can't start arbitrary channels,
+// but works before anyone used a command
+func (s *CommandsServer) overlayCommands(channel string) (*Commands, bool) {
+	if commands, ok := s.initialized(channel); ok {
+		return commands, true
+	}
+	if channel == "#" {
+		return nil, false
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	// the bot subscribes to the announcements of its channel
+	values, err := redis.Values(conn.Do("PUBSUB", "NUMSUB", "reminders:"+channel))
+	if err != nil || len(values) != 2 {
+		return nil, false
+	}
+	if n, _ := redis.Int(values[1], nil); n == 0 {
+		return nil, false
+	}
+	return s.commands(channel), true
+}
+
+func overlayStateOf(channel string, queue *songqueue.Queue) (*overlayState, error) {
+	items, err := queue.Upcoming()
+	if err != nil {
+		return nil, err
+	}
+	state := queue.State()
+	overlay := &overlayState{
+		Channel:  channel,
+		Playing:  state.Playing,
+		Progress: int(state.Progress / time.Second),
+		Updated:  state.Checked.UnixNano() / int64(time.Millisecond),
+		Queue:    []overlayTrack{},
+	}
+	if state.CurrentURI != "" {
+		overlay.Current = &overlayTrack{Name: state.CurrentName, URI: state.CurrentURI, Duration: int(state.CurrentDuration / time.Second)}
+	}
+	for _, item := range items {
+		if item.Status == songqueue.Playing {
+			if overlay.Current != nil && item.URI == overlay.Current.URI {
+				overlay.Current.Requester = item.Username
+			}
+			continue
+		}
+		track := overlayTrack{Name: item.Name, URI: item.URI, Requester: item.Username, Duration: int(item.Duration / time.Second)}
+		if item.ETA > 0 {
+			track.ETA = int(item.ETA / time.Second)
+		}
+		overlay.Queue = append(overlay.Queue, track)
+	}
+	return overlay, nil
+}
+
+// overlayHandler serves the page for OBS browser source and the read-only api:
+// / and /queue pages, /api/nowplaying, /api/queue and /events (SSE), all with ?channel=<name>.
+// The external player reports songs to /api/player with the token
+func (s *CommandsServer) overlayHandler(playerToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/queue" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(overlayPage))
+	})
+	mux.HandleFunc("/api/nowplaying", s.overlayAPI(func(state *overlayState) interface{} {
+		return struct {
+			Current  *overlayTrack `json:"current"`
+			Playing  bool          `json:"playing"`
+			Progress int           `json:"progress"`
+			Updated  int64         `json:"updated"`
+		}{state.Current, state.Playing, state.Progress, state.Updated}
+	}))
+	mux.HandleFunc("/api/queue", s.overlayAPI(func(state *overlayState) interface{} {
+		return state.Queue
+	}))
+	mux.HandleFunc("/events", s.overlayEvents)
+	if playerToken != "" {
+		mux.HandleFunc("/api/player", playerReport(playerToken))
+	}
+	return mux
+}
+
+// playerReport handles POST /api/player?channel=<name> with uri and status (playing|played|skipped),
+// the player authenticates with Authorization: Bearer <token>
+func playerReport(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		channel := "#" + r.URL.Query().Get("channel")
+		if err := songqueue.Report(channel, r.FormValue("uri"), r.FormValue("status")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *CommandsServer) overlayQueue(w http.ResponseWriter, r *http.Request) (string, *songqueue.Queue, bool) {
+	channel := "#" + r.URL.Query().Get("channel")
+	commands, ok := s.overlayCommands(channel)
+	if !ok {
+		http.Error(w, "unknown channel", http.StatusNotFound)
+		return "", nil, false
+	}
+	return channel, commands.Utils.Songs, true
+}
+
+func (s *CommandsServer) overlayAPI(view func(*overlayState) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		channel, queue, ok := s.overlayQueue(w, r)
+		if !ok {
+			return
+		}
+		state, err := overlayStateOf(channel, queue)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(view(state))
+	}
+}
+
+// overlayEvents sends the state every time it changes. The stream of the channel which isn't running yet
+// waits for its bot, because EventSource doesn't reconnect after an error status
+func (s *CommandsServer) overlayEvents(w http.ResponseWriter, r *http.Request) {
+	channel := "#" + r.URL.Query().Get("channel")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	ticker := time.NewTicker(overlayInterval)
+	defer ticker.Stop()
+	flusher.Flush()
+	var queue *songqueue.Queue
+	var last []byte
+	// errors are printed once until they change, not every interval
+	lastErr := ""
+	for {
+		if queue == nil {
+			if commands, ok := s.overlayCommands(channel); ok {
+				queue = commands.Utils.Songs
+			}
+		}
+		if queue != nil {
+			state, err := overlayStateOf(channel, queue)
+			if err != nil {
+				if err.Error() != lastErr {
+					lastErr = err.Error()
+					fmt.Println(err)
+				}
+			} else {
+				lastErr = ""
+				if data, err := json.Marshal(state); err == nil && !bytes.Equal(data, last) {
+					last = data
+					fmt.Fprintf(w, "data: %s\n\n", data)
+					flusher.Flush()
+				}
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const overlayPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Now playing</title>
+<style>
+body { margin: 0; font-family: sans-serif; color: #fff; background: transparent; text-shadow: 0 0 4px #000; }
+#current { padding: 8px 12px; background: rgba(0, 0, 0, 0.6); border-radius: 6px; display: inline-block; min-width: 300px; }
+#name { font-size: 22px; font-weight: bold; }
+#requester, #queue li span { font-size: 14px; opacity: 0.8; }
+#bar { height: 4px; background: rgba(255, 255, 255, 0.3); margin-top: 6px; }
+#progress { height: 100%; width: 0; background: #1db954; }
+#queue { margin: 8px 0 0; padding: 8px 12px 8px 32px; background: rgba(0, 0, 0, 0.4); border-radius: 6px; display: inline-block; }
+.hidden { display: none !important; }
+</style>
+</head>
+<body>
+<div id="current" class="hidden">
+	<div id="name"></div>
+	<div id="requester"></div>
+	<div id="bar"><div id="progress"></div></div>
+</div>
+<br>
+<ol id="queue" class="hidden"></ol>
+<script>
+const params = new URLSearchParams(location.search);
+const channel = params.get("channel") || "";
+// /queue shows the whole queue, the overlay only the next few songs
+const limit = location.pathname === "/queue" ? 1000 : Number(params.get("limit") || 3);
+let state = null;
+
+function time(seconds) {
+	const m = Math.floor(seconds / 60), s = Math.floor(seconds % 60);
+	return m + ":" + (s < 10 ? "0" : "") + s;
+}
+
+function render() {
+	const current = document.getElementById("current");
+	current.classList.toggle("hidden", !state || !state.current);
+	if (state && state.current) {
+		document.getElementById("name").textContent = state.current.name;
+		document.getElementById("requester").textContent = state.current.requester ? "requested by " + state.current.requester : "";
+		let progress = state.progress;
+		if (state.playing) {
+			progress += (Date.now() - state.updated) / 1000;
+		}
+		progress = Math.min(progress, state.current.duration);
+		document.getElementById("progress").style.width = (state.current.duration ? 100 * progress / state.current.duration : 0) + "%";
+	}
+	const queue = document.getElementById("queue");
+	queue.textContent = "";
+	const songs = state ? state.queue.slice(0, limit) : [];
+	queue.classList.toggle("hidden", songs.length === 0);
+	for (const song of songs) {
+		const li = document.createElement("li");
+		li.textContent = song.name + " ";
+		const info = document.createElement("span");
+		info.textContent = "(" + song.requester + (song.eta ? ", in " + time(song.eta) : "") + ")";
+		li.appendChild(info);
+		queue.appendChild(li);
+	}
+}
+
+const events = new EventSource("/events?channel=" + encodeURIComponent(channel));
+events.onmessage = e => { state = JSON.parse(e.data); render(); };
+setInterval(render, 1000);
+</script>
+</body>
+</html>
+`
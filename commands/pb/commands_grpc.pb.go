@@ -18,6 +18,18 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type CommandsClient interface {
 	ParseAndExec(ctx context.Context, in *Message, opts ...grpc.CallOption) (Commands_ParseAndExecClient, error)
+	ListCommands(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*CommandList, error)
+	UpdateCommand(ctx context.Context, in *UpdateCommandRequest, opts ...grpc.CallOption) (*CommandInfo, error)
+	StartPoll(ctx context.Context, in *StartPollRequest, opts ...grpc.CallOption) (*PollResults, error)
+	StopPoll(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*PollResults, error)
+	GetPollResults(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*PollResults, error)
+	WatchPollResults(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (Commands_WatchPollResultsClient, error)
+	GetPollHistory(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*PollHistory, error)
+	GetSongQueue(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*SongQueue, error)
+	GetUserStats(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*UserStats, error)
+	SetChannelStatus(ctx context.Context, in *ChannelStatus, opts ...grpc.CallOption) (*ChannelStatus, error)
+	// enable or disable one mode keeping the others
+	SetChannelMode(ctx context.Context, in *ChannelMode, opts ...grpc.CallOption) (*ChannelStatus, error)
 }
 
 type commandsClient struct {
@@ -60,11 +72,145 @@ func (x *commandsParseAndExecClient) Recv() (*ReturnMessage, error) {
 	return m, nil
 }
 
+func (c *commandsClient) ListCommands(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*CommandList, error) {
+	out := new(CommandList)
+	err := c.cc.Invoke(ctx, "/commands.Commands/listCommands", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) UpdateCommand(ctx context.Context, in *UpdateCommandRequest, opts ...grpc.CallOption) (*CommandInfo, error) {
+	out := new(CommandInfo)
+	err := c.cc.Invoke(ctx, "/commands.Commands/updateCommand", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) StartPoll(ctx context.Context, in *StartPollRequest, opts ...grpc.CallOption) (*PollResults, error) {
+	out := new(PollResults)
+	err := c.cc.Invoke(ctx, "/commands.Commands/startPoll", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) StopPoll(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*PollResults, error) {
+	out := new(PollResults)
+	err := c.cc.Invoke(ctx, "/commands.Commands/stopPoll", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) GetPollResults(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*PollResults, error) {
+	out := new(PollResults)
+	err := c.cc.Invoke(ctx, "/commands.Commands/getPollResults", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) WatchPollResults(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (Commands_WatchPollResultsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Commands_serviceDesc.Streams[1], "/commands.Commands/watchPollResults", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &commandsWatchPollResultsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Commands_WatchPollResultsClient interface {
+	Recv() (*PollResults, error)
+	grpc.ClientStream
+}
+
+type commandsWatchPollResultsClient struct {
+	grpc.ClientStream
+}
+
+func (x *commandsWatchPollResultsClient) Recv() (*PollResults, error) {
+	m := new(PollResults)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *commandsClient) GetPollHistory(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*PollHistory, error) {
+	out := new(PollHistory)
+	err := c.cc.Invoke(ctx, "/commands.Commands/getPollHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) GetSongQueue(ctx context.Context, in *ChannelRequest, opts ...grpc.CallOption) (*SongQueue, error) {
+	out := new(SongQueue)
+	err := c.cc.Invoke(ctx, "/commands.Commands/getSongQueue", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) GetUserStats(ctx context.Context, in *UserRequest, opts ...grpc.CallOption) (*UserStats, error) {
+	out := new(UserStats)
+	err := c.cc.Invoke(ctx, "/commands.Commands/getUserStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) SetChannelStatus(ctx context.Context, in *ChannelStatus, opts ...grpc.CallOption) (*ChannelStatus, error) {
+	out := new(ChannelStatus)
+	err := c.cc.Invoke(ctx, "/commands.Commands/setChannelStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *commandsClient) SetChannelMode(ctx context.Context, in *ChannelMode, opts ...grpc.CallOption) (*ChannelStatus, error) {
+	out := new(ChannelStatus)
+	err := c.cc.Invoke(ctx, "/commands.Commands/setChannelMode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CommandsServer is the server API for Commands service.
 // All implementations must embed UnimplementedCommandsServer
 // for forward compatibility
 type CommandsServer interface {
 	ParseAndExec(*Message, Commands_ParseAndExecServer) error
+	ListCommands(context.Context, *ChannelRequest) (*CommandList, error)
+	UpdateCommand(context.Context, *UpdateCommandRequest) (*CommandInfo, error)
+	StartPoll(context.Context, *StartPollRequest) (*PollResults, error)
+	StopPoll(context.Context, *ChannelRequest) (*PollResults, error)
+	GetPollResults(context.Context, *ChannelRequest) (*PollResults, error)
+	WatchPollResults(*ChannelRequest, Commands_WatchPollResultsServer) error
+	GetPollHistory(context.Context, *ChannelRequest) (*PollHistory, error)
+	GetSongQueue(context.Context, *ChannelRequest) (*SongQueue, error)
+	GetUserStats(context.Context, *UserRequest) (*UserStats, error)
+	SetChannelStatus(context.Context, *ChannelStatus) (*ChannelStatus, error)
+	// enable or disable one mode keeping the others
+	SetChannelMode(context.Context, *ChannelMode) (*ChannelStatus, error)
 	mustEmbedUnimplementedCommandsServer()
 }
 
@@ -75,6 +221,39 @@ type UnimplementedCommandsServer struct {
 func (UnimplementedCommandsServer) ParseAndExec(*Message, Commands_ParseAndExecServer) error {
 	return status.Errorf(codes.Unimplemented, "method ParseAndExec not implemented")
 }
+func (UnimplementedCommandsServer) ListCommands(context.Context, *ChannelRequest) (*CommandList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCommands not implemented")
+}
+func (UnimplementedCommandsServer) UpdateCommand(context.Context, *UpdateCommandRequest) (*CommandInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateCommand not implemented")
+}
+func (UnimplementedCommandsServer) StartPoll(context.Context, *StartPollRequest) (*PollResults, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartPoll not implemented")
+}
+func (UnimplementedCommandsServer) StopPoll(context.Context, *ChannelRequest) (*PollResults, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopPoll not implemented")
+}
+func (UnimplementedCommandsServer) GetPollResults(context.Context, *ChannelRequest) (*PollResults, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPollResults not implemented")
+}
+func (UnimplementedCommandsServer) WatchPollResults(*ChannelRequest, Commands_WatchPollResultsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPollResults not implemented")
+}
+func (UnimplementedCommandsServer) GetPollHistory(context.Context, *ChannelRequest) (*PollHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPollHistory not implemented")
+}
+func (UnimplementedCommandsServer) GetSongQueue(context.Context, *ChannelRequest) (*SongQueue, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSongQueue not implemented")
+}
+func (UnimplementedCommandsServer) GetUserStats(context.Context, *UserRequest) (*UserStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserStats not implemented")
+}
+func (UnimplementedCommandsServer) SetChannelStatus(context.Context, *ChannelStatus) (*ChannelStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetChannelStatus not implemented")
+}
+func (UnimplementedCommandsServer) SetChannelMode(context.Context, *ChannelMode) (*ChannelStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetChannelMode not implemented")
+}
 func (UnimplementedCommandsServer) mustEmbedUnimplementedCommandsServer() {}
 
 // UnsafeCommandsServer may be embedded to opt out of forward compatibility for this service.
@@ -109,16 +288,263 @@ func (x *commandsParseAndExecServer) Send(m *ReturnMessage) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Commands_ListCommands_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).ListCommands(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/listCommands",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).ListCommands(ctx, req.(*ChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_UpdateCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateCommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).UpdateCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/updateCommand",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).UpdateCommand(ctx, req.(*UpdateCommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_StartPoll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartPollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).StartPoll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/startPoll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).StartPoll(ctx, req.(*StartPollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_StopPoll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).StopPoll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/stopPoll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).StopPoll(ctx, req.(*ChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_GetPollResults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).GetPollResults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/getPollResults",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).GetPollResults(ctx, req.(*ChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_WatchPollResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChannelRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CommandsServer).WatchPollResults(m, &commandsWatchPollResultsServer{stream})
+}
+
+type Commands_WatchPollResultsServer interface {
+	Send(*PollResults) error
+	grpc.ServerStream
+}
+
+type commandsWatchPollResultsServer struct {
+	grpc.ServerStream
+}
+
+func (x *commandsWatchPollResultsServer) Send(m *PollResults) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Commands_GetPollHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).GetPollHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/getPollHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).GetPollHistory(ctx, req.(*ChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_GetSongQueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).GetSongQueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/getSongQueue",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).GetSongQueue(ctx, req.(*ChannelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_GetUserStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).GetUserStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/getUserStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).GetUserStats(ctx, req.(*UserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_SetChannelStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelStatus)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).SetChannelStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/setChannelStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).SetChannelStatus(ctx, req.(*ChannelStatus))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Commands_SetChannelMode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelMode)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CommandsServer).SetChannelMode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/commands.Commands/setChannelMode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CommandsServer).SetChannelMode(ctx, req.(*ChannelMode))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Commands_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "commands.Commands",
 	HandlerType: (*CommandsServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "listCommands",
+			Handler:    _Commands_ListCommands_Handler,
+		},
+		{
+			MethodName: "updateCommand",
+			Handler:    _Commands_UpdateCommand_Handler,
+		},
+		{
+			MethodName: "startPoll",
+			Handler:    _Commands_StartPoll_Handler,
+		},
+		{
+			MethodName: "stopPoll",
+			Handler:    _Commands_StopPoll_Handler,
+		},
+		{
+			MethodName: "getPollResults",
+			Handler:    _Commands_GetPollResults_Handler,
+		},
+		{
+			MethodName: "getPollHistory",
+			Handler:    _Commands_GetPollHistory_Handler,
+		},
+		{
+			MethodName: "getSongQueue",
+			Handler:    _Commands_GetSongQueue_Handler,
+		},
+		{
+			MethodName: "getUserStats",
+			Handler:    _Commands_GetUserStats_Handler,
+		},
+		{
+			MethodName: "setChannelStatus",
+			Handler:    _Commands_SetChannelStatus_Handler,
+		},
+		{
+			MethodName: "setChannelMode",
+			Handler:    _Commands_SetChannelMode_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "parseAndExec",
 			Handler:       _Commands_ParseAndExec_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "watchPollResults",
+			Handler:       _Commands_WatchPollResults_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "commands.proto",
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	pb "twitchStats/commands/pb"
+	"twitchStats/permissions"
+	"twitchStats/roles"
+	"twitchStats/terminal"
+)
+
+type liveState struct {
+	live    bool
+	checked time.Time
+}
+
+// stream state is cached, so rules depending on it don't call helix on every command
+var liveCache = struct {
+	sync.Mutex
+	m map[string]liveState
+}{m: make(map[string]liveState)}
+
+const liveCacheTTL = time.Minute
+
+func isLive(channel string) bool {
+	liveCache.Lock()
+	defer liveCache.Unlock()
+	if state, ok := liveCache.m[channel]; ok && time.Since(state.checked) < liveCacheTTL {
+		return state.live
+	}
+	live, err := terminal.IsLive(channel)
+	if err != nil {
+		// keep the previous state if helix is unavailable
+		fmt.Println(err)
+		live = liveCache.m[channel].live
+	}
+	liveCache.m[channel] = liveState{live: live, checked: time.Now()}
+	return live
+}
+
+// subjectLevel is the highest level the subject of the rule may have, all includes everyone.
+// Badges are known only in chat, so users are resolved by the overrides and the broadcaster
+func subjectLevel(channel string, rule *permissions.Rule) (int, error) {
+	switch rule.Subject {
+	case permissions.SubjectAll:
+		return TOP, nil
+	case permissions.SubjectRole:
+		return roles.RoleLevel([]string{rule.Value}), nil
+	}
+	resolver, err := roles.NewResolver(channel)
+	if err != nil {
+		return 0, err
+	}
+	var userRoles []string
+	if rule.Value == strings.TrimPrefix(channel, "#") {
+		userRoles = append(userRoles, roles.RoleBroadcaster)
+	}
+	return resolver.Level(rule.Value, userRoles), nil
+}
+
+// !perm list [command] | allow|deny <command> <subject> [live|offline] [#channel|*] | del <id> [#channel|*] | check <command>
+func (s *CommandsServer) PermCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	if len(params) == 0 {
+		return badUsage("need subcommand")
+	}
+	switch params[0] {
+	case "list":
+		command := ""
+		if len(params) > 1 {
+			command = strings.TrimPrefix(params[1], "!")
+		}
+		rules, err := permissions.List(msg.Channel, command)
+		if err != nil {
+			return err
+		}
+		if len(rules) == 0 {
+			return stream.Send(&pb.ReturnMessage{Text: "No rules, levels of the commands are used"})
+		}
+		str := make([]string, len(rules))
+		for i := range rules {
+			str[i] = rules[i].String()
+		}
+		return stream.Send(&pb.ReturnMessage{Text: strings.Join(str, ", ")})
+	case "allow", "deny":
+		rule, err := permissions.ParseRule(msg.Channel, params)
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		// rules for all channels can only be added by the ones who can act everywhere
+		if rule.Channel != msg.Channel && msg.Level < TOP {
+			return permissionDenied("Not enough rights to add rules for other channels")
+		}
+		// nobody can allow commands which they can't use themselves
+		if rule.Allow && msg.Level < TOP {
+			if rule.Command == permissions.Any {
+				return permissionDenied("Not enough rights to allow all commands")
+			}
+			cmd, ok := s.commands(msg.Channel).Commands[rule.Command]
+			if !ok {
+				return badUsage("unknown command " + rule.Command)
+			}
+			if cmd.level() > int(msg.Level) {
+				return permissionDenied("Not enough rights to allow !" + cmd.Name)
+			}
+		}
+		// nobody can deny commands to the ones who are at least at their level
+		if !rule.Allow && msg.Level < TOP {
+			level, err := subjectLevel(msg.Channel, &rule)
+			if err != nil {
+				return err
+			}
+			if level >= int(msg.Level) {
+				return permissionDenied("Not enough rights to deny commands to " + rule.Subject + ":" + rule.Value)
+			}
+		}
+		if rule.ID, err = permissions.Add(rule); err != nil {
+			return err
+		}
+		return stream.Send(&pb.ReturnMessage{Text: "Added rule " + rule.String()})
+	case "del":
+		if len(params) != 2 && len(params) != 3 {
+			return badUsage("need id of the rule")
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(params[1], "#"), 10, 64)
+		if err != nil {
+			return badUsage("id must be a number")
+		}
+		channel := msg.Channel
+		if len(params) == 3 {
+			if msg.Level < TOP {
+				return permissionDenied("Not enough rights to remove rules of other channels")
+			}
+			channel = params[2]
+		}
+		if err := permissions.Remove(channel, id); err != nil {
+			return badUsage(err.Error())
+		}
+		return stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("Removed rule #%d", id)})
+	case "check":
+		if len(params) != 2 {
+			return badUsage("need command")
+		}
+		cmd, ok := s.commands(msg.Channel).Commands[strings.TrimPrefix(params[1], "!")]
+		if !ok {
+			return badUsage("unknown command " + params[1])
+		}
+		decision, err := permissions.Check(permissions.Request{
+			Channel:      msg.Channel,
+			Command:      cmd.Name,
+			Username:     msg.Username,
+			Roles:        msg.Roles,
+			Level:        int(msg.Level),
+			CommandLevel: cmd.level(),
+			Live:         func() bool { return isLive(msg.Channel) },
+		})
+		if err != nil {
+			return err
+		}
+		return stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s !%s: %s", msg.Username, cmd.Name, decision.Reason)})
+	}
+	return badUsage("unknown subcommand " + params[0])
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	pb "twitchStats/commands/pb"
+	"twitchStats/songqueue"
+	"twitchStats/spotify"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Voteskip threshold, the percent of active chatters but not less than the minimum of votes
+const (
+	defaultVoteSkipPercent = 50
+	voteSkipMinVotes       = 2
+)
+
+type SkipVote struct {
+	sync.Mutex
+	// track which is voted to skip, votes are reset when it changes
+	URI    string
+	Voters map[string]struct{}
+}
+
+// active chatters are the ones who wrote since the last stats check
+func activeChatters(channel string) int {
+	m, err := todayStats(channel)
+	if err != nil {
+		return 0
+	}
+	active := 0
+	for _, stats := range m {
+		if stats.MsgCount > stats.MsgCountPrev {
+			active++
+		}
+	}
+	return active
+}
+
+func voteSkipPercent(channel string) int {
+	conn := pool.Get()
+	defer conn.Close()
+	percent, err := redis.Int(conn.Do("HGET", channel, "voteskip"))
+	if err != nil {
+		return defaultVoteSkipPercent
+	}
+	return percent
+}
+
+func skipTrack(channel string, queue *songqueue.Queue, uri string) error {
+	if err := spotify.ForChannel(channel).Next(); err != nil {
+		return err
+	}
+	return queue.Skipped(uri)
+}
+
+// !voteskip, !voteskip <percent> changes the threshold on the top level
+func (s *CommandsServer) VoteSkipCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, params := extractCommand(msg)
+	if params != "" {
+		if msg.Level < TOP {
+			return permissionDenied("Not enough rights to change settings")
+		}
+		percent, err := strconv.Atoi(strings.TrimSuffix(params, "%"))
+		if err != nil || percent < 1 || percent > 100 {
+			return badUsage("percent must be from 1 to 100")
+		}
+		conn := pool.Get()
+		defer conn.Close()
+		if _, err := conn.Do("HSET", msg.Channel, "voteskip", percent); err != nil {
+			return err
+		}
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("Voteskip needs %d%% of active chatters", percent)})
+		return nil
+	}
+	commands := s.commands(msg.Channel)
+	playback, err := spotify.ForChannel(msg.Channel).Playback()
+	if err != nil {
+		return err
+	}
+	if playback.Item.URI == "" {
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s nothing is playing", msg.Username)})
+		return nil
+	}
+	vote := &commands.Utils.SkipVote
+	vote.Lock()
+	defer vote.Unlock()
+	if vote.URI != playback.Item.URI {
+		vote.URI = playback.Item.URI
+		vote.Voters = make(map[string]struct{})
+	}
+	vote.Voters[msg.Username] = struct{}{}
+	needed := (activeChatters(msg.Channel)*voteSkipPercent(msg.Channel) + 99) / 100
+	if needed < voteSkipMinVotes {
+		needed = voteSkipMinVotes
+	}
+	if len(vote.Voters) < needed {
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("Voteskip %d/%d", len(vote.Voters), needed)})
+		return nil
+	}
+	if err := skipTrack(msg.Channel, commands.Utils.Songs, vote.URI); err != nil {
+		return err
+	}
+	vote.Voters = make(map[string]struct{})
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("%s was skipped by the vote", playback.Item.Name)})
+	return nil
+}
+
+func (s *CommandsServer) SkipCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	playback, err := spotify.ForChannel(msg.Channel).Playback()
+	if err != nil {
+		return err
+	}
+	return skipTrack(msg.Channel, s.commands(msg.Channel).Utils.Songs, playback.Item.URI)
+}
+
+func (s *CommandsServer) PauseCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	return spotify.ForChannel(msg.Channel).Pause()
+}
+
+func (s *CommandsServer) ResumeCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	return spotify.ForChannel(msg.Channel).Resume()
+}
+
+func (s *CommandsServer) VolumeCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, params := extractCommand(msg)
+	volume, err := strconv.Atoi(strings.TrimSuffix(params, "%"))
+	if err != nil || volume < 0 || volume > 100 {
+		return badUsage("volume must be from 0 to 100")
+	}
+	if err := spotify.ForChannel(msg.Channel).SetVolume(volume); err != nil {
+		return err
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("Volume: %d%%", volume)})
+	return nil
+}
+
+// !lastsongs <optional: count>
+func (s *CommandsServer) LastSongsCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, params := extractCommand(msg)
+	count := 5
+	if params != "" {
+		n, err := strconv.Atoi(params)
+		if err != nil || n < 1 || n > 10 {
+			return badUsage("count must be from 1 to 10")
+		}
+		count = n
+	}
+	history, err := songqueue.History(msg.Channel, count)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s no songs were played yet", msg.Username)})
+		return nil
+	}
+	songs := make([]string, len(history))
+	for i, played := range history {
+		songs[i] = played.Name + " [" + time.Since(played.PlayedAt).Round(time.Minute).String() + " ago"
+		if played.Username != "" {
+			songs[i] += ", requested by " + played.Username
+		}
+		songs[i] += "]"
+	}
+	stream.Send(&pb.ReturnMessage{Text: "Last songs: " + strings.Join(songs, ", ")})
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	pb "twitchStats/commands/pb"
+	"twitchStats/points"
+)
+
+// user from the argument of the command, e.g. @Name
+func argUser(arg string) string {
+	return strings.ToLower(strings.TrimPrefix(arg, "@"))
+}
+
+// !points [user] | history [user]
+func (s *CommandsServer) PointsCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	channel := msg.Channel[1:]
+	username := msg.Username
+	if len(params) > 0 && params[0] == "history" {
+		if len(params) > 1 {
+			username = argUser(params[1])
+		}
+		entries, err := points.History(channel, username, 5)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("%s has no points history", username)})
+			return nil
+		}
+		str := username + ": "
+		for i, entry := range entries {
+			if i > 0 {
+				str += ", "
+			}
+			str += fmt.Sprintf("%+d %s (%d) %s ago", entry.Amount, entry.Reason, entry.Balance, time.Since(entry.CreatedAt).Round(time.Minute))
+		}
+		stream.Send(&pb.ReturnMessage{Text: str})
+		return nil
+	}
+	if len(params) > 0 {
+		username = argUser(params[0])
+	}
+	balance, err := points.Balance(channel, username)
+	if err != nil {
+		return err
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("%s has %d points", username, balance)})
+	return nil
+}
+
+// !give <user> <amount>
+func (s *CommandsServer) GiveCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	if len(params) < 2 {
+		return badUsage("need user and amount")
+	}
+	amount, err := strconv.Atoi(params[1])
+	if err != nil || amount <= 0 {
+		return badUsage("amount must be a positive number")
+	}
+	to := argUser(params[0])
+	err = points.Transfer(msg.Channel[1:], msg.Username, to, amount)
+	if err == points.ErrInsufficient {
+		return badUsage(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s gave %d points to %s", msg.Username, amount, to)})
+	return nil
+}
+
+// !leaderboard
+func (s *CommandsServer) LeaderboardCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	top, err := points.Top(msg.Channel[1:], 5)
+	if err != nil {
+		return err
+	}
+	if len(top) == 0 {
+		stream.Send(&pb.ReturnMessage{Text: "nobody has points yet"})
+		return nil
+	}
+	str := ""
+	for i, entry := range top {
+		if i > 0 {
+			str += ", "
+		}
+		str += fmt.Sprintf("%d. %s: %d", i+1, entry.Username, entry.Balance)
+	}
+	stream.Send(&pb.ReturnMessage{Text: str})
+	return nil
+}
+
+// !addpoints <user> <amount>, negative amount takes points
+func (s *CommandsServer) AddPointsCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	if len(params) < 2 {
+		return badUsage("need user and amount")
+	}
+	amount, err := strconv.Atoi(params[1])
+	if err != nil || amount == 0 {
+		return badUsage("amount must be a number")
+	}
+	username := argUser(params[0])
+	balance, err := points.Adjust(msg.Channel[1:], username, amount)
+	if err == points.ErrInsufficient {
+		return badUsage(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("%s now has %d points", username, balance)})
+	return nil
+}
+
+// !pointrates [<minute|message|messages|sub|raid> <value>]
+func (s *CommandsServer) PointRatesCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	channel := msg.Channel[1:]
+	if len(params) == 0 {
+		rates, err := points.GetRates(channel)
+		if err != nil {
+			return err
+		}
+		stream.Send(&pb.ReturnMessage{Text: rates.String()})
+		return nil
+	}
+	if len(params) < 2 {
+		return badUsage("need rate and value, rates: " + strings.Join(points.RateNames(), ", "))
+	}
+	value, err := strconv.Atoi(params[1])
+	if err != nil {
+		return badUsage("value must be a number")
+	}
+	rates, err := points.SetRate(channel, params[0], value)
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	stream.Send(&pb.ReturnMessage{Text: rates.String()})
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	pb "twitchStats/commands/pb"
+	"twitchStats/modes"
+	"twitchStats/polls"
+	"twitchStats/roles"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// PollBox holds the current poll of the channel
+type PollBox struct {
+	mu   sync.Mutex
+	poll *polls.Poll
+	// incremented on every change, so watchers know when to send new results
+	Version int32
+}
+
+func (box *PollBox) Get() *polls.Poll {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	return box.poll
+}
+
+func (box *PollBox) set(poll *polls.Poll) {
+	box.mu.Lock()
+	box.poll = poll
+	box.mu.Unlock()
+	box.changed()
+}
+
+func (box *PollBox) changed() {
+	atomic.AddInt32(&box.Version, 1)
+}
+
+// announce sends the message to the chat through the bot,
+// publishing is fire-and-forget, so it fails when the bot of the channel isn't subscribed
+func announce(channel, text string) error {
+	conn := pool.Get()
+	defer conn.Close()
+	receivers, err := redis.Int(conn.Do("PUBLISH", "reminders:"+channel, text))
+	if err != nil {
+		return err
+	}
+	if receivers == 0 {
+		return errors.New("bot of " + channel + " isn't listening, message wasn't sent")
+	}
+	return nil
+}
+
+// startPoll replaces the current poll, it's closed by the timer if it has the end
+func (s *CommandsServer) startPoll(channel string, poll *polls.Poll) error {
+	box := &s.commands(channel).Utils.Poll
+	if current := box.Get(); current != nil {
+		if _, err := s.closePoll(channel, current); err != nil {
+			return err
+		}
+	}
+	box.set(poll)
+	if _, err := changeMode(channel, modes.Vote, true); err != nil {
+		return err
+	}
+	if results := poll.Results(); !results.Ends.IsZero() {
+		time.AfterFunc(time.Until(results.Ends), func() {
+			if _, err := s.closePoll(channel, poll); err != nil {
+				fmt.Println(err)
+			}
+		})
+	}
+	return nil
+}
+
+// closePoll saves the poll into the history and announces the results.
+// Nothing happens if the poll was already closed
+func (s *CommandsServer) closePoll(channel string, poll *polls.Poll) (*pb.PollResults, error) {
+	results, closed := poll.Close()
+	if !closed {
+		return pollResults(&results), nil
+	}
+	s.commands(channel).Utils.Poll.changed()
+	if _, err := changeMode(channel, modes.Vote, false); err != nil {
+		return nil, err
+	}
+	if err := polls.Save(&results); err != nil {
+		return nil, err
+	}
+	final := pollResults(&results)
+	return final, announce(channel, formatResults(final))
+}
+
+func pollResults(results *polls.Results) *pb.PollResults {
+	converted := &pb.PollResults{
+		Channel:  results.Channel,
+		Active:   results.Active,
+		Total:    int32(results.Total),
+		Title:    results.Title,
+		Weighted: results.Weighted,
+	}
+	if results.Active && !results.Ends.IsZero() {
+		converted.Remaining = int32(time.Until(results.Ends).Round(time.Second) / time.Second)
+	}
+	for _, option := range results.Options {
+		converted.Options = append(converted.Options, &pb.PollOption{
+			Number: int32(option.Number),
+			Name:   option.Name,
+			Votes:  int32(option.Votes),
+			Voters: int32(option.Voters),
+		})
+	}
+	return converted
+}
+
+func formatResults(results *pb.PollResults) string {
+	str := fmt.Sprintf("Total votes %d: ", results.Total)
+	if results.Title != "" {
+		str = results.Title + ". " + str
+	}
+	var total int32
+	for _, option := range results.Options {
+		total += option.Votes
+	}
+	for i, option := range results.Options {
+		if i > 0 {
+			str += ", "
+		}
+		var percent float32
+		if total > 0 {
+			percent = float32(option.Votes) / float32(total) * 100
+		}
+		name := strconv.Itoa(int(option.Number))
+		if option.Name != "" {
+			name += ". " + option.Name
+		}
+		str += fmt.Sprintf("%s: %.1f%%(%d)", name, percent, option.Votes)
+	}
+	return str
+}
+
+// parse optional time and weighted flag in front of the params
+func parsePollFlags(params []string) (time.Duration, bool, []string, error) {
+	var duration time.Duration
+	weighted := false
+	for len(params) > 0 {
+		if params[0] == "weighted" {
+			weighted = true
+		} else if d, err := time.ParseDuration(params[0]); err == nil {
+			if d <= 0 {
+				return 0, false, nil, badUsage("time must be positive")
+			}
+			duration = d
+		} else {
+			break
+		}
+		params = params[1:]
+	}
+	return duration, weighted, params, nil
+}
+
+// !smartvote <lowerBound>-<upperBound> [time] [weighted]
+func (s *CommandsServer) SmartVoteCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, params := extractCommand(msg)
+	fields := strings.Fields(params)
+	if len(fields) == 0 {
+		return badUsage("not enough args")
+	}
+	split := strings.Split(fields[0], "-")
+	if len(split) < 2 {
+		return badUsage("not enough args")
+	}
+	lowerBound, err := strconv.Atoi(split[0])
+	if err != nil {
+		return badUsage("bounds must be numbers")
+	}
+	upperBound, err := strconv.Atoi(split[1])
+	if err != nil {
+		return badUsage("bounds must be numbers")
+	}
+	duration, weighted, rest, err := parsePollFlags(fields[1:])
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return badUsage("unknown option " + rest[0])
+	}
+	poll, err := polls.NewRange(msg.Channel, "", lowerBound, upperBound, weighted, duration)
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	if err := s.startPoll(msg.Channel, poll); err != nil {
+		return err
+	}
+
+	str := "GOLOSOVANIE"
+	stream.Send(&pb.ReturnMessage{Text: str})
+	return nil
+}
+
+// !poll [time] [weighted] <title> | <option> | <option>...
+func (s *CommandsServer) PollCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, params := extractCommand(msg)
+	parts := strings.Split(params, "|")
+	if len(parts) < 3 {
+		return badUsage("poll needs title and at least two options")
+	}
+	duration, weighted, title, err := parsePollFlags(strings.Fields(parts[0]))
+	if err != nil {
+		return err
+	}
+	if len(title) == 0 {
+		return badUsage("poll needs title")
+	}
+	poll, err := polls.New(msg.Channel, strings.Join(title, " "), parts[1:], weighted, duration)
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	if err := s.startPoll(msg.Channel, poll); err != nil {
+		return err
+	}
+	results := poll.Results()
+	options := make([]string, len(results.Options))
+	for i, option := range results.Options {
+		options[i] = fmt.Sprintf("%d. %s", option.Number, option.Name)
+	}
+	str := fmt.Sprintf("Poll: %s %s. Vote with !vote <number or name>", results.Title, strings.Join(options, ", "))
+	if duration > 0 {
+		str += fmt.Sprintf(", closes in %s", duration)
+	}
+	stream.Send(&pb.ReturnMessage{Text: str})
+	return nil
+}
+
+// final results are announced by closePoll
+func (s *CommandsServer) StopVoteCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	poll := s.commands(msg.Channel).Utils.Poll.Get()
+	if poll == nil || !poll.Results().Active {
+		return badUsage("there is no active vote")
+	}
+	_, err := s.closePoll(msg.Channel, poll)
+	return err
+}
+
+func (s *CommandsServer) VoteOptionsCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	poll := s.commands(msg.Channel).Utils.Poll.Get()
+	if poll == nil || !poll.Results().Active {
+		return badUsage("there is no active vote")
+	}
+	results := poll.Results()
+	stream.Send(&pb.ReturnMessage{Text: formatResults(pollResults(&results))})
+	return nil
+}
+
+func isSubscriber(msg *pb.Message) bool {
+	if msg.SubMonths > 0 {
+		return true
+	}
+	for _, role := range msg.Roles {
+		if role == roles.RoleSubscriber {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CommandsServer) VoteCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	box := &s.commands(msg.Channel).Utils.Poll
+	poll := box.Get()
+	if poll == nil || !poll.Results().Active {
+		return badUsage("there is no active vote")
+	}
+	_, body := extractCommand(msg)
+	if err := poll.Vote(msg.Username, body, isSubscriber(msg)); err != nil {
+		return badUsage(err.Error())
+	}
+	box.changed()
+	return nil
+}
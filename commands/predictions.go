@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	pb "twitchStats/commands/pb"
+	"twitchStats/points"
+	"twitchStats/predictions"
+)
+
+func formatPrediction(p *predictions.Prediction) string {
+	total := p.Pool()
+	str := fmt.Sprintf("%s (%s, %d points): ", p.Title, p.Status, total)
+	for i, outcome := range p.Outcomes {
+		if i > 0 {
+			str += ", "
+		}
+		var percent float32
+		if total > 0 {
+			percent = float32(outcome.Pool) / float32(total) * 100
+		}
+		str += fmt.Sprintf("%d. %s: %d(%.1f%%, %d bets)", outcome.Number, outcome.Name, outcome.Pool, percent, outcome.Bettors)
+	}
+	return str
+}
+
+// !prediction [open <title> | <outcome> | <outcome>... | lock | resolve <outcome> | cancel]
+func (s *CommandsServer) PredictionCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	channel := msg.Channel[1:]
+	sub, rest := body, ""
+	if i := strings.Index(body, " "); i != -1 {
+		sub, rest = body[:i], strings.TrimSpace(body[i+1:])
+	}
+	switch sub {
+	case "":
+		p, err := predictions.Current(channel)
+		if err == predictions.ErrNoPrediction {
+			return badUsage(err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		stream.Send(&pb.ReturnMessage{Text: formatPrediction(p)})
+	case "open":
+		parts := strings.Split(rest, "|")
+		if len(parts) < 3 {
+			return badUsage("need title and at least two outcomes")
+		}
+		title := strings.TrimSpace(parts[0])
+		if title == "" {
+			return badUsage("title can't be empty")
+		}
+		p, err := predictions.Start(channel, title, parts[1:])
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		return announceState(msg, stream, "Prediction started! "+formatPrediction(p)+". Bet with !bet <outcome> <amount>")
+	case "lock":
+		p, err := predictions.Lock(channel)
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		return announceState(msg, stream, "Bets are closed. "+formatPrediction(p))
+	case "resolve":
+		if rest == "" {
+			return badUsage("need outcome")
+		}
+		p, payouts, err := predictions.Resolve(channel, rest)
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		winner := p.Outcomes[p.Winner-1]
+		if winner.Pool == 0 {
+			return announceState(msg, stream, fmt.Sprintf("%s: %s won, nobody bet on it, %d bets were returned", p.Title, winner.Name, len(payouts)))
+		}
+		str := fmt.Sprintf("%s: %s won, %d points go to %d winners", p.Title, winner.Name, p.Pool(), len(payouts))
+		if top := biggestPayout(payouts); top != nil {
+			str += fmt.Sprintf(", @%s got %d", top.Username, top.Amount)
+		}
+		return announceState(msg, stream, str)
+	case "cancel":
+		p, payouts, err := predictions.Cancel(channel)
+		if err != nil {
+			return badUsage(err.Error())
+		}
+		return announceState(msg, stream, fmt.Sprintf("%s was cancelled, %d bets were returned", p.Title, len(payouts)))
+	default:
+		return badUsage("unknown subcommand " + sub)
+	}
+	return nil
+}
+
+// announceState announces the change which is already saved, if it fails the caller still gets the message
+func announceState(msg *pb.Message, stream pb.Commands_ParseAndExecServer, text string) error {
+	if err := announce(msg.Channel, text); err != nil {
+		fmt.Println(err)
+		stream.Send(&pb.ReturnMessage{Text: text})
+	}
+	return nil
+}
+
+func biggestPayout(payouts []predictions.Payout) *predictions.Payout {
+	var top *predictions.Payout
+	for i := range payouts {
+		if top == nil || payouts[i].Amount > top.Amount {
+			top = &payouts[i]
+		}
+	}
+	return top
+}
+
+// !bet <outcome> <amount|all>
+func (s *CommandsServer) BetCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	if len(params) < 2 {
+		return badUsage("need outcome and amount")
+	}
+	channel := msg.Channel[1:]
+	choice := strings.Join(params[:len(params)-1], " ")
+	last := params[len(params)-1]
+	var amount int
+	if last == "all" {
+		balance, err := points.Balance(channel, msg.Username)
+		if err != nil {
+			return err
+		}
+		amount = balance
+	} else {
+		var err error
+		if amount, err = strconv.Atoi(last); err != nil {
+			return badUsage("amount must be a number")
+		}
+	}
+	if amount <= 0 {
+		return badUsage("amount must be positive")
+	}
+	outcome, total, err := predictions.Bet(channel, msg.Username, choice, amount)
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s bet %d on %s (total %d)", msg.Username, amount, outcome.Name, total)})
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	pb "twitchStats/commands/pb"
+	"twitchStats/profile"
+)
+
+// !whois <username>
+func (s *CommandsServer) WhoisCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	username := argUser(strings.TrimSpace(body))
+	if username == "" {
+		return badUsage("need username")
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	p, err := profile.Lookup(conn, username)
+	if err != nil {
+		return err
+	}
+	stream.Send(&pb.ReturnMessage{Text: p.Summary()})
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	pb "twitchStats/commands/pb"
+	"twitchStats/reminders"
+)
+
+// sendReminders runs the scheduler, it's restarted after errors, so reminders aren't lost.
+// Reminders which couldn't be announced are retried later, the others are sent meanwhile
+func sendReminders() {
+	for {
+		err := reminders.Run(func(r *reminders.Reminder) error {
+			err := announce("#"+r.Channel, r.Text())
+			if err != nil {
+				fmt.Println("reminder", r.ID, err)
+			}
+			return err
+		})
+		fmt.Println(err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// !remind [@user] <time|at 21:00> [every <period>] <message>
+func (s *CommandsServer) RemindCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	target := msg.Username
+	if len(params) > 0 && strings.HasPrefix(params[0], "@") {
+		target = argUser(params[0])
+		params = params[1:]
+	}
+	if len(params) == 0 {
+		return badUsage("not enough params")
+	}
+	due, every, rest, err := reminders.Parse(params, time.Now())
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	r := &reminders.Reminder{
+		Channel: msg.Channel[1:],
+		Author:  msg.Username,
+		Target:  target,
+		Message: strings.Join(rest, " "),
+		Due:     due,
+		Every:   every,
+	}
+	if err := reminders.Add(r); err != nil {
+		return badUsage(err.Error())
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s reminder %d %s", msg.Username, r.ID, formatReminderTime(r))})
+	return nil
+}
+
+func formatReminderTime(r *reminders.Reminder) string {
+	str := "in " + time.Until(r.Due).Round(time.Second).String()
+	if r.Every > 0 {
+		str += ", every " + r.Every.String()
+	}
+	return str
+}
+
+// !reminders [list] | cancel <id>
+func (s *CommandsServer) RemindersCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	channel := msg.Channel[1:]
+	if len(params) == 0 || params[0] == "list" {
+		list, err := reminders.List(channel, msg.Username)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s you don't have reminders", msg.Username)})
+			return nil
+		}
+		items := make([]string, len(list))
+		for i := range list {
+			r := &list[i]
+			items[i] = fmt.Sprintf("%d. %s", r.ID, formatReminderTime(r))
+			if r.Target != msg.Username {
+				items[i] += " for " + r.Target
+			} else if r.Author != msg.Username {
+				items[i] += " from " + r.Author
+			}
+			if r.Message != "" {
+				items[i] += ": " + r.Message
+			}
+		}
+		stream.Send(&pb.ReturnMessage{Text: "@" + msg.Username + " " + strings.Join(items, " | ")})
+		return nil
+	}
+	if params[0] != "cancel" {
+		return badUsage("unknown subcommand " + params[0])
+	}
+	if len(params) < 2 {
+		return badUsage("need id")
+	}
+	id, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil {
+		return badUsage("id must be a number")
+	}
+	if err := reminders.Cancel(channel, msg.Username, id); err != nil {
+		return badUsage(err.Error())
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s reminder %d was cancelled", msg.Username, id)})
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	pb "twitchStats/commands/pb"
+)
+
+// CommandError describes why a command was not executed.
+// It is sent back to the bot as a typed pb.Result instead of a grpc error
+type CommandError struct {
+	Type     pb.ResultType
+	Text     string
+	Cooldown time.Duration
+	Help     string
+}
+
+func (e *CommandError) Error() string {
+	return e.Text
+}
+
+func permissionDenied(text string) error {
+	return &CommandError{Type: pb.ResultType_PERMISSION_DENIED, Text: text}
+}
+
+func onCooldown(left time.Duration) error {
+	return &CommandError{
+		Type:     pb.ResultType_COOLDOWN,
+		Text:     fmt.Sprintf("on cooldown for %s", left.Round(time.Second)),
+		Cooldown: left,
+	}
+}
+
+// badUsage is returned on wrong params, help is filled with Command.Usage
+func badUsage(text string) error {
+	return &CommandError{Type: pb.ResultType_BAD_USAGE, Text: text}
+}
+
+// Convert handler error to the result which is sent to the bot
+func newResult(cmd *Command, err error) *pb.Result {
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		cmdErr = &CommandError{Type: pb.ResultType_INTERNAL_ERROR, Text: err.Error()}
+	}
+	result := &pb.Result{
+		Type:    cmdErr.Type,
+		Command: cmd.Name,
+		Text:    cmdErr.Text,
+		Help:    cmdErr.Help,
+	}
+	if cmdErr.Cooldown > 0 {
+		// round up so the user won't retry a second too early
+		result.Cooldown = int32((cmdErr.Cooldown + time.Second - 1) / time.Second)
+	}
+	if result.Type == pb.ResultType_BAD_USAGE && result.Help == "" {
+		result.Help = cmd.Usage
+	}
+	return result
+}
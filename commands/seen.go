@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	pb "twitchStats/commands/pb"
+	"twitchStats/seen"
+)
+
+// !stalk <username>
+func (s *CommandsServer) StalkCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	username := argUser(strings.TrimSpace(body))
+	if username == "" {
+		return badUsage("need username")
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	record, present, err := seen.Lookup(conn, username)
+	if err != nil {
+		return err
+	}
+	retMessage := "Found nothing, sorry! :)"
+	if record != nil {
+		retMessage = fmt.Sprintf("%s was seen %s ago in %s, last message: %s", username, time.Since(record.Time).Truncate(time.Second), record.Channel, record.Message)
+	}
+	if len(present) > 0 {
+		retMessage += ". Now in chat: " + strings.Join(present, ", ")
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s %s", msg.Username, retMessage)})
+	return nil
+}
+
+// !seen <username> | optout | optin
+func (s *CommandsServer) SeenCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	switch strings.TrimSpace(body) {
+	case "optout":
+		conn := pool.Get()
+		defer conn.Close()
+		if err := seen.OptOut(conn, msg.Username); err != nil {
+			return err
+		}
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s you won't be tracked anymore", msg.Username)})
+		return nil
+	case "optin":
+		conn := pool.Get()
+		defer conn.Close()
+		if err := seen.OptIn(conn, msg.Username); err != nil {
+			return err
+		}
+		stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s you are tracked again", msg.Username)})
+		return nil
+	}
+	return s.StalkCommand(msg, stream)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	pb "twitchStats/commands/pb"
+	"twitchStats/tells"
+)
+
+// !tell [whisper] <user> <message>
+func (s *CommandsServer) TellCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	params := strings.Fields(body)
+	whisper := false
+	if len(params) > 2 && params[0] == "whisper" {
+		whisper = true
+		params = params[1:]
+	}
+	if len(params) < 2 {
+		return badUsage("need user and message")
+	}
+	t := &tells.Tell{
+		Channel:   msg.Channel,
+		Sender:    msg.Username,
+		Recipient: argUser(params[0]),
+		Message:   strings.Join(params[1:], " "),
+		Whisper:   whisper,
+	}
+	if err := tells.Send(t); err != nil {
+		return badUsage(err.Error())
+	}
+	// the bot looks for messages only of the recipients it knows about
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PUBLISH", "tells:"+msg.Channel, t.Recipient); err != nil {
+		fmt.Println(err)
+	}
+	stream.Send(&pb.ReturnMessage{Text: fmt.Sprintf("@%s I'll tell %s when they chat next time", msg.Username, t.Recipient)})
+	return nil
+}
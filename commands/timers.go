@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	pb "twitchStats/commands/pb"
+	"twitchStats/timers"
+)
+
+// !timer list | add <name> <interval> [lines <n>] [live] <message> | <message>... | msg <name> <message> | del <name> | on|off <name>
+func (s *CommandsServer) TimerCommand(msg *pb.Message, stream pb.Commands_ParseAndExecServer) error {
+	_, body := extractCommand(msg)
+	reply, err := timers.Manage(msg.Channel, strings.Fields(body))
+	if err != nil {
+		return badUsage(err.Error())
+	}
+	stream.Send(&pb.ReturnMessage{Text: reply})
+	return nil
+}
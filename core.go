@@ -2,17 +2,28 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	_ "net/http/pprof"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+	pb "twitchStats/commands/pb"
 	"twitchStats/database/cache"
+	"twitchStats/followers"
+	"twitchStats/followgraph"
+	"twitchStats/identity"
 	"twitchStats/logsparser"
 	"twitchStats/markov"
+	"twitchStats/modes"
+	"twitchStats/permissions"
+	"twitchStats/profile"
+	"twitchStats/roles"
 	"twitchStats/spotify"
 	"twitchStats/terminal"
+	"twitchStats/timers"
 
 	"github.com/gomodule/redigo/redis"
 	_ "github.com/mattn/go-sqlite3"
@@ -87,6 +98,383 @@ func parseCommand(str string, botInstances map[string]*Bot, ch chan<- func()) {
 					terminal.Output.Println("Provide valid channel name to which bot is currently connected")
 				}
 			}
+		case "role":
+			// role list | role set <username> <level> | role unset <username> | role define <name> <level>
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) == 0 {
+				terminal.Output.Println("role <list|set|unset|define>")
+				return
+			}
+			switch {
+			case args[0] == "list":
+				for _, override := range bot.Roles.Overrides() {
+					terminal.Output.Println(override)
+				}
+			case args[0] == "set" && len(args) == 3:
+				bot.changeAuthority(args[1], args[2])
+			case args[0] == "unset" && len(args) == 2:
+				bot.changeAuthority(args[1], "none")
+			case args[0] == "define" && len(args) == 3:
+				level, err := strconv.Atoi(args[2])
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				if err := roles.DefineLevel(bot.Channel, args[1], level); err != nil {
+					terminal.Output.Log(err)
+				}
+			default:
+				terminal.Output.Println("Provide valid args")
+			}
+		case "perm":
+			// perm list [command] | perm allow|deny <command> <subject> [live|offline] [#channel|*] | perm del <id> [#channel|*] | perm check <command> <username>
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) == 0 {
+				terminal.Output.Println("perm <list|allow|deny|del|check>")
+				return
+			}
+			ch <- func() {
+				switch {
+				case args[0] == "list":
+					command := ""
+					if len(args) > 1 {
+						command = args[1]
+					}
+					rules, err := permissions.List(bot.Channel, command)
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					for i := range rules {
+						terminal.Output.Println(rules[i].String())
+					}
+				case args[0] == "allow" || args[0] == "deny":
+					rule, err := permissions.ParseRule(bot.Channel, args)
+					if err != nil {
+						terminal.Output.Println(err)
+						return
+					}
+					if rule.ID, err = permissions.Add(rule); err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					terminal.Output.Println("Added rule " + rule.String())
+				case args[0] == "del" && (len(args) == 2 || len(args) == 3):
+					id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+					if err != nil {
+						terminal.Output.Println("id must be a number")
+						return
+					}
+					channel := bot.Channel
+					if len(args) == 3 {
+						channel = args[2]
+					}
+					if err := permissions.Remove(channel, id); err != nil {
+						terminal.Output.Log(err)
+					}
+				case args[0] == "check" && len(args) == 3:
+					list, err := bot.GrpcClient.ListCommands(context.Background(), &pb.ChannelRequest{Channel: bot.Channel})
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					for _, cmd := range list.Commands {
+						if cmd.Name != args[1] {
+							continue
+						}
+						// badges are known only from chat, so role rules are checked against overrides only
+						decision, err := permissions.Check(permissions.Request{
+							Channel:      bot.Channel,
+							Command:      cmd.Name,
+							Username:     args[2],
+							Level:        bot.Roles.Level(args[2], nil),
+							CommandLevel: int(cmd.Level),
+							Live: func() bool {
+								live, err := terminal.IsLive(bot.Channel)
+								if err != nil {
+									terminal.Output.Log(err)
+								}
+								return live
+							},
+						})
+						if err != nil {
+							terminal.Output.Log(err)
+							return
+						}
+						terminal.Output.Println(decision.Reason)
+						return
+					}
+					terminal.Output.Println("unknown command " + args[1])
+				default:
+					terminal.Output.Println("Provide valid args")
+				}
+			}
+		case "profile":
+			// profile <username> [json]
+			if len(args) == 0 || len(args) > 2 || len(args) == 2 && args[1] != "json" {
+				terminal.Output.Println("profile <username> [json]")
+				return
+			}
+			ch <- func() {
+				conn := pool.Get()
+				defer conn.Close()
+				p, err := profile.Lookup(conn, args[0])
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				if len(args) == 2 {
+					data, err := p.JSON()
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					terminal.Output.Println(string(data))
+					return
+				}
+				for _, line := range p.Lines() {
+					terminal.Output.Println(line)
+				}
+			}
+		case "migrateids":
+			// links stats of logins to user ids through helix
+			ch <- func() {
+				linked, err := identity.Migrate(func(logins []string) ([]identity.User, error) {
+					users, err := terminal.GetUsers(logins)
+					if err != nil {
+						return nil, err
+					}
+					converted := make([]identity.User, len(users))
+					for i, u := range users {
+						converted[i] = identity.User{ID: u.ID, Login: u.Login, DisplayName: u.DisplayName}
+					}
+					return converted, nil
+				})
+				if err != nil {
+					terminal.Output.Log(err)
+				}
+				terminal.Output.Println(fmt.Sprintf("linked %d users", linked))
+			}
+		case "names":
+			// names <username>
+			if len(args) != 1 {
+				terminal.Output.Println("names <username>")
+				return
+			}
+			ch <- func() {
+				id, names, err := identity.History(args[0])
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				if id == "" {
+					terminal.Output.Println("unknown user " + args[0])
+					return
+				}
+				terminal.Output.Println("id " + id)
+				for _, name := range names {
+					terminal.Output.Println(fmt.Sprintf("%s %s (%s)", name.Since.Format("2006-01-02 15:04"), name.Login, name.DisplayName))
+				}
+			}
+		case "followers":
+			// followers report [days] | followers waves | followers block <wave id>
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) == 0 {
+				terminal.Output.Println("followers <report|waves|block>")
+				return
+			}
+			ch <- func() {
+				switch {
+				case args[0] == "report" && len(args) <= 2:
+					days := 30
+					if len(args) == 2 {
+						var err error
+						if days, err = strconv.Atoi(args[1]); err != nil || days <= 0 {
+							terminal.Output.Println("days must be a positive number")
+							return
+						}
+					}
+					lines, err := followers.Report(bot.ChannelId, days)
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					for _, line := range lines {
+						terminal.Output.Println(line)
+					}
+				case args[0] == "waves":
+					waves, err := followers.Waves(bot.ChannelId, 20)
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					for i := range waves {
+						terminal.Output.Println(waves[i].String())
+					}
+				case args[0] == "block" && len(args) == 2:
+					id, err := strconv.ParseInt(args[1], 10, 64)
+					if err != nil {
+						terminal.Output.Println("wave id must be a number")
+						return
+					}
+					// banning takes a while, so the console isn't blocked
+					go func() {
+						blocked, err := bot.blockWave(id)
+						if err != nil {
+							terminal.Output.Log(err)
+						}
+						terminal.Output.Println(fmt.Sprintf("banned %d followers of wave %d", blocked, id))
+					}()
+				default:
+					terminal.Output.Println("Provide valid args")
+				}
+			}
+		case "watch":
+			// watch list | watch add|del <login> | watch token <login> <token> | watch channels | watch channel add|del <channel> | watch events|presence <login> | watch check
+			if len(args) == 0 {
+				terminal.Output.Println("watch <list|add|del|token|channels|channel|events|presence|check>")
+				return
+			}
+			ch <- func() {
+				var err error
+				switch {
+				case args[0] == "list":
+					var people []followers.Person
+					people, err = followers.Tracked()
+					for _, p := range people {
+						// follows of people without the token aren't checked
+						if p.Token == "" {
+							terminal.Output.Print(p.Login + "(no token) ")
+						} else {
+							terminal.Output.Print(p.Login + " ")
+						}
+					}
+					terminal.Output.Println("")
+				case args[0] == "add" && len(args) == 2:
+					err = followers.Track(args[1])
+				case args[0] == "del" && len(args) == 2:
+					err = followers.Untrack(args[1])
+				case args[0] == "token" && len(args) == 3:
+					err = followers.SetToken(args[1], args[2])
+				case args[0] == "channels":
+					var channels []string
+					channels, err = followers.WatchedChannels()
+					terminal.Output.Println(strings.Join(channels, " "))
+				case args[0] == "channel" && len(args) == 3 && args[1] == "add":
+					err = followers.WatchChannel(args[2])
+				case args[0] == "channel" && len(args) == 3 && args[1] == "del":
+					err = followers.UnwatchChannel(args[2])
+				case args[0] == "events" && len(args) == 2:
+					var events []followers.Event
+					events, err = followers.Events(args[1], 20)
+					for _, e := range events {
+						terminal.Output.Println(e.Time.Format("2006-01-02 15:04") + " " + e.String())
+					}
+				case args[0] == "presence" && len(args) == 2:
+					var sessions []followers.Session
+					sessions, err = followers.Presence(args[1], 20)
+					for _, session := range sessions {
+						terminal.Output.Println(fmt.Sprintf("%s: %s - %s", session.Channel, session.Start.Format("2006-01-02 15:04"), session.End.Format("15:04")))
+					}
+				case args[0] == "check":
+					err = watcher.Check()
+				default:
+					terminal.Output.Println("Provide valid args")
+				}
+				if err != nil {
+					terminal.Output.Log(err)
+				}
+			}
+		case "graph":
+			// graph common <user> <user>... | graph clusters <user> <user>... | graph similar|also <channel> [n] | graph path <user> <user> | graph export <dot|graphml> <file> [user]...
+			if len(args) < 2 {
+				terminal.Output.Println("graph <common|clusters|similar|also|path|export>")
+				return
+			}
+			ch <- func() {
+				g, err := followgraph.Load()
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				limit := 10
+				if (args[0] == "similar" || args[0] == "also") && len(args) == 3 {
+					if limit, err = strconv.Atoi(args[2]); err != nil || limit <= 0 {
+						terminal.Output.Println("n must be a positive number")
+						return
+					}
+				}
+				switch {
+				case args[0] == "common":
+					terminal.Output.Println(strings.Join(g.Common(args[1:]), " "))
+				case args[0] == "clusters":
+					for _, cluster := range g.Clusters(args[1:]) {
+						terminal.Output.Println(strings.Join(cluster, " "))
+					}
+				case args[0] == "similar" && len(args) <= 3:
+					for _, score := range g.Similar(args[1], limit) {
+						terminal.Output.Println(fmt.Sprintf("%s %.3f (%d shared)", score.Channel, score.Score, score.Shared))
+					}
+				case args[0] == "also" && len(args) <= 3:
+					for _, score := range g.AlsoFollow(args[1], limit) {
+						terminal.Output.Println(fmt.Sprintf("%s %.1f%% (%d)", score.Channel, score.Score*100, score.Shared))
+					}
+				case args[0] == "path" && len(args) == 3:
+					path := g.Path(args[1], args[2])
+					if path == nil {
+						terminal.Output.Println("not connected")
+						return
+					}
+					terminal.Output.Println(strings.Join(path, " - "))
+				case args[0] == "export" && len(args) >= 3:
+					if len(args) > 3 {
+						g = g.Subgraph(args[3:])
+					}
+					file, err := os.Create(args[2])
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					defer file.Close()
+					switch args[1] {
+					case "dot":
+						err = g.WriteDOT(file)
+					case "graphml":
+						err = g.WriteGraphML(file)
+					default:
+						terminal.Output.Println("format must be dot or graphml")
+						return
+					}
+					if err != nil {
+						terminal.Output.Log(err)
+					}
+				default:
+					terminal.Output.Println("Provide valid args")
+				}
+			}
+		case "timer":
+			// timer list | timer add <name> <interval> [lines <n>] [live] <message> | <message>... | timer msg <name> <message> | timer del <name> | timer on|off <name>
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			ch <- func() {
+				reply, err := timers.Manage(bot.Channel, args)
+				if err != nil {
+					terminal.Output.Println(err)
+					return
+				}
+				terminal.Output.Println(reply)
+			}
 		case "clear":
 			if len(args) == 0 {
 				terminal.Output.Print("\033[H\033[J")
@@ -129,13 +517,14 @@ func parseCommand(str string, botInstances map[string]*Bot, ch chan<- func()) {
 				terminal.Output.Println(msg)
 			}
 		case "spam":
-			bot := botInstances[terminal.Output.CurrentChannel]
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
 			duration := time.Duration(90) * time.Second
 			switch len(args) {
 			case 0:
-				conn := pool.Get()
-				defer conn.Close()
-				_, err := conn.Do("HSET", bot.Channel, "status", "Running")
+				err := bot.setMode(modes.SpamAttack, false)
 				if err != nil {
 					terminal.Output.Log(err)
 				}
@@ -151,9 +540,7 @@ func parseCommand(str string, botInstances map[string]*Bot, ch chan<- func()) {
 			}
 			bot.Spam.Add(args[0])
 			bot.SpamHistory(args[0], duration)
-			conn := pool.Get()
-			defer conn.Close()
-			_, err := conn.Do("HSET", bot.Channel, "status", "SpamAttack")
+			err := bot.setMode(modes.SpamAttack, true)
 			if err != nil {
 				terminal.Output.Log(err)
 			}
@@ -222,15 +609,24 @@ func parseCommand(str string, botInstances map[string]*Bot, ch chan<- func()) {
 			}
 			terminal.Output.Comments = nil
 		case "searchtrack":
-			track, err := spotify.SearchTrack(s[strings.Index(s, " ")+1:])
-			if err != nil {
-				terminal.Output.Println(err)
-				return
+			ch <- func() {
+				client := spotify.ForChannel(terminal.Output.CurrentChannel)
+				track, err := client.Search(s[strings.Index(s, " ")+1:], 1)
+				if err != nil {
+					terminal.Output.Println(err)
+					return
+				}
+				if len(track.Tracks.Items) == 0 {
+					terminal.Output.Println("track wasn't found")
+					return
+				}
+				if _, err := client.AddToPlaylist(track.Tracks.Items[0].URI); err != nil {
+					terminal.Output.Log(err)
+				}
 			}
-			spotify.AddToPlaylist(track.Tracks.Items[0].URI)
 		case "currenttrack":
 			ch <- func() {
-				track, err := spotify.GetCurrentTrack()
+				track, err := spotify.ForChannel(terminal.Output.CurrentChannel).CurrentTrack()
 				if err != nil {
 					terminal.Output.Log(err)
 					return
@@ -238,18 +634,224 @@ func parseCommand(str string, botInstances map[string]*Bot, ch chan<- func()) {
 				terminal.Output.Println(track)
 			}
 		case "nexttrack":
-			spotify.SkipToNextTrack()
+			ch <- func() {
+				if err := spotify.ForChannel(terminal.Output.CurrentChannel).Next(); err != nil {
+					terminal.Output.Log(err)
+				}
+			}
+		case "playlist":
+			// playlist [id]
+			if len(args) == 0 {
+				terminal.Output.Println(spotify.ForChannel(terminal.Output.CurrentChannel).PlaylistID)
+				return
+			}
+			if err := spotify.SetPlaylist(terminal.Output.CurrentChannel, args[0]); err != nil {
+				terminal.Output.Log(err)
+			}
 		case "changestatus":
+			// changestatus <Running|mode,mode...> replaces all modes, e.g. changestatus Smartvote,SpamAttack
 			if len(args) != 1 {
-				terminal.Output.Println("something went wrong")
+				terminal.Output.Println("changestatus <Running|Smartvote|SpamAttack>[,<mode>...]")
+				return
+			}
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			err := bot.setStatus(args[0])
+			if err != nil {
+				terminal.Output.Log(err)
+			}
+		case "mode":
+			// mode <Smartvote|SpamAttack> <on|off>
+			if len(args) != 2 || args[1] != "on" && args[1] != "off" {
+				terminal.Output.Println("mode <Smartvote|SpamAttack> <on|off>")
+				return
+			}
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			mode, err := modes.ParseMode(args[0])
+			if err != nil {
+				terminal.Output.Println(err)
+				return
+			}
+			if err := bot.setMode(mode, args[1] == "on"); err != nil {
+				terminal.Output.Log(err)
+			}
+		case "commands":
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			ch <- func() {
+				list, err := bot.GrpcClient.ListCommands(context.Background(), &pb.ChannelRequest{Channel: bot.Channel})
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				for _, cmd := range list.Commands {
+					terminal.Output.Println(fmt.Sprintf("%s [enabled: %t, cd: %ds, level: %d]", cmd.Usage, cmd.Enabled, cmd.Cooldown, cmd.Level))
+				}
+			}
+		case "command":
+			// command <name> <enable|disable|cd|level> <value>
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) < 2 {
+				terminal.Output.Println("command <name> <enable|disable|cd|level> <value>")
+				return
+			}
+			info := &pb.CommandInfo{Name: args[0]}
+			var mask string
+			switch args[1] {
+			case "enable", "disable":
+				info.Enabled = args[1] == "enable"
+				mask = "enabled"
+			case "cd", "level":
+				if len(args) != 3 {
+					terminal.Output.Println("provide value")
+					return
+				}
+				if args[1] == "cd" {
+					value, err := strconv.Atoi(args[2])
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					info.Cooldown = int32(value)
+					mask = "cooldown"
+				} else {
+					value, err := roles.ParseLevel(bot.Channel, args[2])
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					info.Level = int32(value)
+					mask = "level"
+				}
+			default:
+				terminal.Output.Println("unknown field " + args[1])
+				return
+			}
+			cmd, err := bot.GrpcClient.UpdateCommand(context.Background(), &pb.UpdateCommandRequest{Channel: bot.Channel, Command: info, UpdateMask: []string{mask}})
+			if err != nil {
+				terminal.Output.Log(err)
+				return
+			}
+			terminal.Output.Println(fmt.Sprintf("%s [enabled: %t, cd: %ds, level: %d]", cmd.Usage, cmd.Enabled, cmd.Cooldown, cmd.Level))
+		case "poll":
+			// poll start [time] [weighted] <title> | <option> | <option>... | poll stop | poll results | poll history
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) == 0 {
+				terminal.Output.Println("poll <start|stop|results|history>")
+				return
+			}
+			var results *pb.PollResults
+			var err error
+			req := &pb.ChannelRequest{Channel: bot.Channel}
+			switch args[0] {
+			case "start":
+				parts := strings.Split(strings.Join(args[1:], " "), "|")
+				if len(parts) < 3 {
+					terminal.Output.Println("poll start [time] [weighted] <title> | <option> | <option>...")
+					return
+				}
+				start := &pb.StartPollRequest{Channel: bot.Channel, Options: parts[1:]}
+				words := strings.Fields(parts[0])
+				for len(words) > 0 {
+					if words[0] == "weighted" {
+						start.Weighted = true
+					} else if d, err := time.ParseDuration(words[0]); err == nil {
+						start.Duration = int32(d / time.Second)
+					} else {
+						break
+					}
+					words = words[1:]
+				}
+				start.Title = strings.Join(words, " ")
+				results, err = bot.GrpcClient.StartPoll(context.Background(), start)
+			case "stop":
+				results, err = bot.GrpcClient.StopPoll(context.Background(), req)
+			case "results":
+				results, err = bot.GrpcClient.GetPollResults(context.Background(), req)
+			case "history":
+				ch <- func() {
+					history, err := bot.GrpcClient.GetPollHistory(context.Background(), req)
+					if err != nil {
+						terminal.Output.Log(err)
+						return
+					}
+					for _, poll := range history.Polls {
+						printPollResults(poll)
+					}
+				}
+				return
+			default:
+				terminal.Output.Println("poll <start|stop|results|history>")
 				return
 			}
-			bot := botInstances[terminal.Output.CurrentChannel]
-			conn := pool.Get()
-			defer conn.Close()
-			_, err := conn.Do("HSET", bot.Channel, "status", args[0])
 			if err != nil {
 				terminal.Output.Log(err)
+				return
+			}
+			printPollResults(results)
+		case "queue":
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			ch <- func() {
+				queue, err := bot.GrpcClient.GetSongQueue(context.Background(), &pb.ChannelRequest{Channel: bot.Channel})
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				for _, song := range queue.Songs {
+					terminal.Output.Println(fmt.Sprintf("%s [%s] requested by %s", song.Name, time.Duration(song.Duration)*time.Second, song.Username))
+				}
+			}
+		case "userstats":
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) != 1 {
+				terminal.Output.Println("Provide username")
+				return
+			}
+			ch <- func() {
+				stats, err := bot.GrpcClient.GetUserStats(context.Background(), &pb.UserRequest{Channel: bot.Channel, Username: args[0]})
+				if err != nil {
+					terminal.Output.Log(err)
+					return
+				}
+				terminal.Output.Println(fmt.Sprintf("today: messages: %d, watch time: %s", stats.MsgCount, time.Duration(stats.WatchTime)*time.Second))
+				terminal.Output.Println(fmt.Sprintf("all time: messages: %d, watch time: %s", stats.TotalMsgCount, time.Duration(stats.TotalWatchTime)*time.Second))
+			}
+		case "policy":
+			bot := currentBot(botInstances)
+			if bot == nil {
+				return
+			}
+			if len(args) == 0 {
+				for t := range defaultResultPolicy {
+					terminal.Output.Println(strings.ToLower(t.String()) + ": " + bot.resultPolicy(t))
+				}
+				return
+			}
+			if len(args) != 2 {
+				terminal.Output.Println("policy <result> <reply|whisper|silent>")
+				return
+			}
+			if err := bot.setResultPolicy(args[0], args[1]); err != nil {
+				terminal.Output.Log(err)
 			}
 		case "crossfollow":
 			ch <- func() {
@@ -263,6 +865,26 @@ func parseCommand(str string, botInstances map[string]*Bot, ch chan<- func()) {
 	}
 }
 
+func printPollResults(results *pb.PollResults) {
+	terminal.Output.Println(fmt.Sprintf("%s [active: %t, weighted: %t, total votes: %d, remaining: %ds]",
+		results.Title, results.Active, results.Weighted, results.Total, results.Remaining))
+	for _, option := range results.Options {
+		terminal.Output.Println(fmt.Sprintf("%d. %s: %d (%d voters)", option.Number, option.Name, option.Votes, option.Voters))
+	}
+}
+
+// follows of tracked people are checked in the background
+var watcher = followers.NewWatcher(10 * time.Minute)
+
+func currentBot(botInstances map[string]*Bot) *Bot {
+	bot, ok := botInstances[terminal.Output.CurrentChannel]
+	if !ok {
+		terminal.Output.Println("connect to chat")
+		return nil
+	}
+	return bot
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	terminal.Output.CurrentChannel = "#"
@@ -273,6 +895,7 @@ func main() {
 	terminal.Output.Renderer = &coreRenderer
 	ch := make(chan func(), 20)
 	go execCommands(ch)
+	go watcher.Run()
 	for {
 		args, status := terminal.Output.ProcessConsole()
 		switch status {
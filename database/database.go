@@ -16,6 +16,11 @@ var (
 	params     = basepath + name + settings
 )
 
+// SetPath makes Connect open another database file, e.g. the temp one in tests
+func SetPath(path string) {
+	params = path + settings
+}
+
 func Connect() *sql.DB {
 	db, err := sql.Open("sqlite3", params)
 	if err != nil {
@@ -24,3 +29,14 @@ func Connect() *sql.DB {
 	db.SetMaxOpenConns(1)
 	return db
 }
+
+// ConnectImmediate opens the database where transactions take the write lock at BEGIN,
+// so rows read in the transaction can't change before its writes
+func ConnectImmediate() *sql.DB {
+	db, err := sql.Open("sqlite3", params+"&_txlock=immediate")
+	if err != nil {
+		panic(err)
+	}
+	db.SetMaxOpenConns(1)
+	return db
+}
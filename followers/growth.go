@@ -0,0 +1,324 @@
+package followers
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"twitchStats/terminal"
+)
+
+// Follower is the follow of the broadcaster
+type Follower struct {
+	UserID     string
+	Login      string
+	Created    time.Time
+	FollowedAt time.Time
+	WaveID     int64
+	Blocked    bool
+}
+
+// Count is the number of followers at the time of the poll
+type Count struct {
+	Total int
+	At    time.Time
+}
+
+func createGrowthTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS ChannelFollowers(ChannelId TEXT NOT NULL, UserId TEXT NOT NULL, Login TEXT NOT NULL, CreatedAt TIMESTAMP, FollowedAt TIMESTAMP NOT NULL, DetectedAt TIMESTAMP NOT NULL, WaveId INTEGER, Blocked INTEGER NOT NULL DEFAULT 0, PRIMARY KEY(ChannelId, UserId));")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS FollowerCounts(ChannelId TEXT NOT NULL, Total INTEGER NOT NULL, At TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS FollowWaves(Id INTEGER PRIMARY KEY, ChannelId TEXT NOT NULL, Start TIMESTAMP NOT NULL, End TIMESTAMP NOT NULL, Size INTEGER NOT NULL, Reasons TEXT NOT NULL, DetectedAt TIMESTAMP NOT NULL);")
+	return err
+}
+
+// Poll saves new followers of the channel and the follower count, returns new followers, the oldest first.
+// Pages are read until a known follower, the first poll reads only the newest page.
+// The bot token needs moderator:read:followers
+func Poll(channelID string) ([]Follower, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var known int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ChannelFollowers WHERE ChannelId=$1;", channelID).Scan(&known); err != nil {
+		return nil, err
+	}
+	var follows []terminal.Follow
+	total := 0
+	cursor := ""
+	for {
+		page, err := terminal.GetChannelFollowers(channelID, cursor)
+		if err != nil {
+			return nil, err
+		}
+		total = page.Total
+		done := known == 0 || page.Pagination.Cursor == "" || len(page.Data) == 0
+		for _, f := range page.Data {
+			var exists int
+			if err := db.QueryRow("SELECT COUNT(*) FROM ChannelFollowers WHERE ChannelId=$1 AND UserId=$2;", channelID, f.FromID).Scan(&exists); err != nil {
+				return nil, err
+			}
+			if exists > 0 {
+				done = true
+				break
+			}
+			follows = append(follows, f)
+		}
+		if done {
+			break
+		}
+		cursor = page.Pagination.Cursor
+	}
+	created := make(map[string]time.Time)
+	for i := 0; i < len(follows); i += 100 {
+		end := i + 100
+		if end > len(follows) {
+			end = len(follows)
+		}
+		ids := make([]string, 0, end-i)
+		for _, f := range follows[i:end] {
+			ids = append(ids, f.FromID)
+		}
+		users, err := terminal.GetUsersByID(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			created[u.ID] = u.CreatedAt
+		}
+	}
+	now := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	followers := make([]Follower, 0, len(follows))
+	// helix returns the newest first
+	for i := len(follows) - 1; i >= 0; i-- {
+		f := follows[i]
+		followedAt, err := time.Parse(time.RFC3339, f.FollowedAt)
+		if err != nil {
+			followedAt = now
+		}
+		login := f.FromLogin
+		if login == "" {
+			login = strings.ToLower(f.FromName)
+		}
+		follower := Follower{UserID: f.FromID, Login: login, Created: created[f.FromID], FollowedAt: followedAt}
+		_, err = tx.Exec("INSERT OR IGNORE INTO ChannelFollowers(ChannelId, UserId, Login, CreatedAt, FollowedAt, DetectedAt) VALUES($1,$2,$3,$4,$5,$6);",
+			channelID, follower.UserID, follower.Login, follower.Created, follower.FollowedAt, now)
+		if err != nil {
+			return nil, err
+		}
+		followers = append(followers, follower)
+	}
+	if _, err := tx.Exec("INSERT INTO FollowerCounts(ChannelId, Total, At) VALUES($1,$2,$3);", channelID, total, now); err != nil {
+		return nil, err
+	}
+	return followers, tx.Commit()
+}
+
+func scanFollowers(rows *sql.Rows) ([]Follower, error) {
+	defer rows.Close()
+	var followers []Follower
+	for rows.Next() {
+		var f Follower
+		var created sql.NullTime
+		var wave sql.NullInt64
+		if err := rows.Scan(&f.UserID, &f.Login, &created, &f.FollowedAt, &wave, &f.Blocked); err != nil {
+			return nil, err
+		}
+		f.Created, f.WaveID = created.Time, wave.Int64
+		followers = append(followers, f)
+	}
+	return followers, rows.Err()
+}
+
+// CheckWaves looks for waves among recent followers which aren't in a wave yet and saves them
+func CheckWaves(channelID string, cfg WaveConfig) ([]Wave, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	now := time.Now()
+	rows, err := db.Query("SELECT UserId, Login, CreatedAt, FollowedAt, WaveId, Blocked FROM ChannelFollowers WHERE ChannelId=$1 AND WaveId IS NULL AND FollowedAt>=$2 ORDER BY FollowedAt;",
+		channelID, now.Add(-cfg.Lookback))
+	if err != nil {
+		return nil, err
+	}
+	recent, err := scanFollowers(rows)
+	if err != nil {
+		return nil, err
+	}
+	waves := Detect(recent, cfg, now)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for i := range waves {
+		w := &waves[i]
+		w.ChannelID = channelID
+		res, err := tx.Exec("INSERT INTO FollowWaves(ChannelId, Start, End, Size, Reasons, DetectedAt) VALUES($1,$2,$3,$4,$5,$6);",
+			channelID, w.Start, w.End, len(w.Followers), strings.Join(w.Reasons, "; "), now)
+		if err != nil {
+			return nil, err
+		}
+		if w.ID, err = res.LastInsertId(); err != nil {
+			return nil, err
+		}
+		for j := range w.Followers {
+			w.Followers[j].WaveID = w.ID
+			if _, err := tx.Exec("UPDATE ChannelFollowers SET WaveId=$1 WHERE ChannelId=$2 AND UserId=$3;", w.ID, channelID, w.Followers[j].UserID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return waves, tx.Commit()
+}
+
+// Waves returns the last waves of the channel without their followers, the newest first
+func Waves(channelID string, limit int) ([]Wave, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Id, Start, End, Size, Reasons FROM FollowWaves WHERE ChannelId=$1 ORDER BY Id DESC LIMIT $2;", channelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var waves []Wave
+	for rows.Next() {
+		w := Wave{ChannelID: channelID}
+		var reasons string
+		if err := rows.Scan(&w.ID, &w.Start, &w.End, &w.Size, &reasons); err != nil {
+			return nil, err
+		}
+		w.Reasons = strings.Split(reasons, "; ")
+		waves = append(waves, w)
+	}
+	return waves, rows.Err()
+}
+
+// WaveFollowers returns followers of the wave which aren't blocked yet
+func WaveFollowers(channelID string, waveID int64) ([]Follower, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT UserId, Login, CreatedAt, FollowedAt, WaveId, Blocked FROM ChannelFollowers WHERE ChannelId=$1 AND WaveId=$2 AND Blocked=0 ORDER BY FollowedAt;", channelID, waveID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFollowers(rows)
+}
+
+func MarkBlocked(channelID, userID string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("UPDATE ChannelFollowers SET Blocked=1 WHERE ChannelId=$1 AND UserId=$2;", channelID, userID)
+	return err
+}
+
+// Counts returns the follower count history since the time, the oldest first
+func Counts(channelID string, since time.Time) ([]Count, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Total, At FROM FollowerCounts WHERE ChannelId=$1 AND At>=$2 ORDER BY At;", channelID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []Count
+	for rows.Next() {
+		var c Count
+		if err := rows.Scan(&c.Total, &c.At); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// Chart draws the last count of every day as a bar with the change from the previous day
+func Chart(counts []Count, width int) []string {
+	type day struct {
+		date  string
+		total int
+	}
+	var days []day
+	for _, c := range counts {
+		date := c.At.Format("2006-01-02")
+		if len(days) > 0 && days[len(days)-1].date == date {
+			days[len(days)-1].total = c.Total
+			continue
+		}
+		days = append(days, day{date, c.Total})
+	}
+	if len(days) == 0 {
+		return nil
+	}
+	min, max := days[0].total, days[0].total
+	for _, d := range days {
+		if d.total < min {
+			min = d.total
+		}
+		if d.total > max {
+			max = d.total
+		}
+	}
+	lines := make([]string, len(days))
+	for i, d := range days {
+		// the bar shows the growth over the period, so small changes of big channels are visible
+		size := 1
+		if max > min {
+			size += (d.total - min) * (width - 1) / (max - min)
+		}
+		change := ""
+		if i > 0 {
+			change = fmt.Sprintf(" (%+d)", d.total-days[i-1].total)
+		}
+		lines[i] = fmt.Sprintf("%s %s %d%s", d.date, strings.Repeat("#", size), d.total, change)
+	}
+	return lines
+}
+
+// Report is the chart of the follower count with the waves of the period
+func Report(channelID string, days int) ([]string, error) {
+	since := time.Now().AddDate(0, 0, -days)
+	counts, err := Counts(channelID, since)
+	if err != nil {
+		return nil, err
+	}
+	lines := Chart(counts, 40)
+	waves, err := Waves(channelID, 100)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(waves, func(i, j int) bool { return waves[i].Start.Before(waves[j].Start) })
+	for _, w := range waves {
+		if w.Start.After(since) {
+			lines = append(lines, w.String())
+		}
+	}
+	return lines, nil
+}
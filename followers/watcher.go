@@ -0,0 +1,471 @@
+package followers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"twitchStats/database"
+	"twitchStats/statistics"
+	"twitchStats/terminal"
+)
+
+// Kinds of the events
+const (
+	Followed   = "follow"
+	Unfollowed = "unfollow"
+	Present    = "present"
+)
+
+// Event is the change noticed by the watcher
+type Event struct {
+	Login      string    `json:"login"`
+	Channel    string    `json:"channel"`
+	Kind       string    `json:"kind"`
+	FollowedAt string    `json:"followed_at,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case Followed:
+		return fmt.Sprintf("%s followed %s", e.Login, e.Channel)
+	case Unfollowed:
+		return fmt.Sprintf("%s unfollowed %s", e.Login, e.Channel)
+	}
+	return fmt.Sprintf("%s is watching %s", e.Login, e.Channel)
+}
+
+// Notifier gets the events of the watcher
+type Notifier interface {
+	Notify(e Event) error
+}
+
+type TerminalNotifier struct{}
+
+func (TerminalNotifier) Notify(e Event) error {
+	terminal.Output.Println("[" + e.Time.Format("15:04:05") + "] " + e.String())
+	return nil
+}
+
+// WebhookNotifier posts the event as json
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(e Event) error {
+	data, err := json.Marshal(struct {
+		Event
+		Text string `json:"text"`
+	}{e, e.String()})
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Person is the tracked user
+type Person struct {
+	Login string
+	ID    string
+	// follows are saved once without events, so the first check doesn't report every follow
+	Synced bool
+	// token of the person with user:read:follows, helix doesn't show follows of other users,
+	// without it only presence is tracked
+	Token string
+}
+
+// Session is the time the person was in the chatters of the channel
+type Session struct {
+	Channel string
+	Start   time.Time
+	End     time.Time
+}
+
+func createTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Followers(Id INTEGER PRIMARY KEY, FromId TEXT, FromName TEXT, ToId TEXT, ToName TEXT, FollowedAt TEXT);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS TrackedPeople(Login TEXT PRIMARY KEY, UserId TEXT NOT NULL, Synced INTEGER NOT NULL DEFAULT 0, AddedAt TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE TrackedPeople ADD COLUMN Token TEXT NOT NULL DEFAULT '';")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS WatchedChannels(Channel TEXT PRIMARY KEY);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS FollowEvents(Id INTEGER PRIMARY KEY, UserId TEXT NOT NULL, Login TEXT NOT NULL, ChannelId TEXT NOT NULL, Channel TEXT NOT NULL, Kind TEXT NOT NULL, FollowedAt TEXT, DetectedAt TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS PresenceHistory(Id INTEGER PRIMARY KEY, Login TEXT NOT NULL, Channel TEXT NOT NULL, Start TIMESTAMP NOT NULL, End TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	return createGrowthTables(db)
+}
+
+func connect() (*sql.DB, error) {
+	db := database.Connect()
+	if err := createTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Track adds the person to the watcher
+func Track(login string) error {
+	login = strings.ToLower(login)
+	id, err := terminal.GetUserId(login)
+	if err != nil {
+		return err
+	}
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("INSERT OR IGNORE INTO TrackedPeople(Login, UserId, AddedAt) VALUES($1,$2,$3);", login, id, time.Now())
+	return err
+}
+
+// SetToken saves the token which the person gave to read their follows
+func SetToken(login, token string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	res, err := db.Exec("UPDATE TrackedPeople SET Token=$1 WHERE Login=$2;", strings.TrimPrefix(token, "oauth:"), strings.ToLower(login))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(login + " isn't tracked")
+	}
+	return nil
+}
+
+func Untrack(login string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("DELETE FROM TrackedPeople WHERE Login=$1;", strings.ToLower(login))
+	return err
+}
+
+func Tracked() ([]Person, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Login, UserId, Synced, Token FROM TrackedPeople ORDER BY Login;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var people []Person
+	for rows.Next() {
+		var p Person
+		if err := rows.Scan(&p.Login, &p.ID, &p.Synced, &p.Token); err != nil {
+			return nil, err
+		}
+		people = append(people, p)
+	}
+	return people, rows.Err()
+}
+
+// WatchChannel adds the channel where presence of tracked people is checked
+func WatchChannel(channel string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("INSERT OR IGNORE INTO WatchedChannels(Channel) VALUES($1);", strings.ToLower(strings.TrimPrefix(channel, "#")))
+	return err
+}
+
+func UnwatchChannel(channel string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("DELETE FROM WatchedChannels WHERE Channel=$1;", strings.ToLower(strings.TrimPrefix(channel, "#")))
+	return err
+}
+
+func WatchedChannels() ([]string, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Channel FROM WatchedChannels ORDER BY Channel;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var channels []string
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// Events returns the last follow events of the person, the newest first
+func Events(login string, limit int) ([]Event, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Login, Channel, Kind, COALESCE(FollowedAt, ''), DetectedAt FROM FollowEvents WHERE Login=$1 ORDER BY Id DESC LIMIT $2;", strings.ToLower(login), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.Login, &e.Channel, &e.Kind, &e.FollowedAt, &e.Time); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Presence returns the last sessions of the person in watched channels, the newest first
+func Presence(login string, limit int) ([]Session, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Channel, Start, End FROM PresenceHistory WHERE Login=$1 ORDER BY End DESC LIMIT $2;", strings.ToLower(login), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.Channel, &s.Start, &s.End); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Watcher polls follow lists of tracked people and chatters of watched channels
+type Watcher struct {
+	Interval  time.Duration
+	Notifiers []Notifier
+}
+
+// NewWatcher notifies the terminal and the webhook from FOLLOW_WEBHOOK_URL if it's set
+func NewWatcher(interval time.Duration) *Watcher {
+	w := &Watcher{Interval: interval, Notifiers: []Notifier{TerminalNotifier{}}}
+	if url := os.Getenv("FOLLOW_WEBHOOK_URL"); url != "" {
+		w.Notifiers = append(w.Notifiers, &WebhookNotifier{URL: url})
+	}
+	return w
+}
+
+func (w *Watcher) notify(e Event) {
+	for _, n := range w.Notifiers {
+		if err := n.Notify(e); err != nil {
+			terminal.Output.Log(err)
+		}
+	}
+}
+
+// Run checks everything every interval
+func (w *Watcher) Run() {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Check(); err != nil {
+			terminal.Output.Log(err)
+		}
+		<-ticker.C
+	}
+}
+
+// Check runs one poll of follows and presence
+func (w *Watcher) Check() error {
+	people, err := Tracked()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, p := range people {
+		if p.Token == "" {
+			continue
+		}
+		if err := w.checkFollows(p, now); err != nil {
+			terminal.Output.Log(err)
+		}
+	}
+	return w.checkPresence(people, now)
+}
+
+// checkFollows diffs the follow list with the Followers table and saves the events
+func (w *Watcher) checkFollows(p Person, now time.Time) error {
+	follows, err := terminal.GetFollowed(p.ID, p.Token)
+	if err != nil {
+		return err
+	}
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	known := make(map[string]string)
+	rows, err := tx.Query("SELECT ToId, ToName FROM Followers WHERE FromId=$1;", p.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		known[id] = name
+	}
+	rows.Close()
+	var events []Event
+	for _, f := range follows {
+		name := f.ToLogin
+		if name == "" {
+			name = f.ToName
+		}
+		if _, ok := known[f.ToID]; ok {
+			delete(known, f.ToID)
+			continue
+		}
+		_, err := tx.Exec("INSERT INTO Followers(FromId, FromName, ToId, ToName, FollowedAt) VALUES($1,$2,$3,$4,$5);", p.ID, p.Login, f.ToID, name, f.FollowedAt)
+		if err != nil {
+			return err
+		}
+		if p.Synced {
+			events = append(events, Event{Login: p.Login, Channel: name, Kind: Followed, FollowedAt: f.FollowedAt, Time: now})
+			if err := saveEvent(tx, p, f.ToID, events[len(events)-1]); err != nil {
+				return err
+			}
+		}
+	}
+	// what's left in known isn't followed anymore
+	for id, name := range known {
+		if _, err := tx.Exec("DELETE FROM Followers WHERE FromId=$1 AND ToId=$2;", p.ID, id); err != nil {
+			return err
+		}
+		if p.Synced {
+			events = append(events, Event{Login: p.Login, Channel: name, Kind: Unfollowed, Time: now})
+			if err := saveEvent(tx, p, id, events[len(events)-1]); err != nil {
+				return err
+			}
+		}
+	}
+	if !p.Synced {
+		if _, err := tx.Exec("UPDATE TrackedPeople SET Synced=1 WHERE Login=$1;", p.Login); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, e := range events {
+		w.notify(e)
+	}
+	return nil
+}
+
+func saveEvent(tx *sql.Tx, p Person, channelID string, e Event) error {
+	_, err := tx.Exec("INSERT INTO FollowEvents(UserId, Login, ChannelId, Channel, Kind, FollowedAt, DetectedAt) VALUES($1,$2,$3,$4,$5,$6,$7);",
+		p.ID, p.Login, channelID, e.Channel, e.Kind, e.FollowedAt, e.Time)
+	return err
+}
+
+// checkPresence asks chatters of every watched channel once and extends or starts the sessions
+func (w *Watcher) checkPresence(people []Person, now time.Time) error {
+	if len(people) == 0 {
+		return nil
+	}
+	channels, err := WatchedChannels()
+	if err != nil {
+		return err
+	}
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// a session continues if the person was seen on the previous check
+	gap := now.Add(-w.Interval * 3 / 2)
+	for _, channel := range channels {
+		chatters, err := statistics.GetUsers(channel)
+		if err != nil {
+			terminal.Output.Log(err)
+			continue
+		}
+		for _, p := range people {
+			if _, ok := chatters[p.Login]; !ok {
+				continue
+			}
+			res, err := db.Exec("UPDATE PresenceHistory SET End=$1 WHERE Id=(SELECT Id FROM PresenceHistory WHERE Login=$2 AND Channel=$3 ORDER BY End DESC LIMIT 1) AND End>=$4;",
+				now, p.Login, channel, gap)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				continue
+			}
+			if _, err := db.Exec("INSERT INTO PresenceHistory(Login, Channel, Start, End) VALUES($1,$2,$3,$3);", p.Login, channel, now); err != nil {
+				return err
+			}
+			w.notify(Event{Login: p.Login, Channel: channel, Kind: Present, Time: now})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package followers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// WaveConfig sets when many follows in a short time are a follow-bot wave
+type WaveConfig struct {
+	// follows in the window are counted together
+	Window     time.Duration
+	MinFollows int
+	// accounts younger than NewAccount at the time of the follow are suspicious
+	NewAccount time.Duration
+	// share of new accounts or sequential names which makes the burst a wave
+	Suspicious float64
+	// how far back followers are checked
+	Lookback time.Duration
+}
+
+var DefaultWaveConfig = WaveConfig{
+	Window:     5 * time.Minute,
+	MinFollows: 10,
+	NewAccount: 7 * 24 * time.Hour,
+	Suspicious: 0.5,
+	Lookback:   time.Hour,
+}
+
+type Wave struct {
+	ID        int64
+	ChannelID string
+	Start     time.Time
+	End       time.Time
+	Size      int
+	Followers []Follower
+	Reasons   []string
+}
+
+func (w *Wave) String() string {
+	return fmt.Sprintf("wave %d: %d follows %s - %s: %s", w.ID, w.Size, w.Start.Format("2006-01-02 15:04"), w.End.Format("15:04"), strings.Join(w.Reasons, "; "))
+}
+
+// stem is the login without the number at the end, bots are often named name123, name124...
+func stem(login string) string {
+	return strings.TrimRightFunc(login, unicode.IsDigit)
+}
+
+// Detect finds bursts of at least MinFollows follows within Window where enough accounts are new
+// or share the name stem. Followers must be sorted by the follow time, the burst ends when it's
+// still growing at now, so it's reported only once it's over
+func Detect(followers []Follower, cfg WaveConfig, now time.Time) []Wave {
+	var waves []Wave
+	for start := 0; start < len(followers); {
+		end := start + 1
+		// extend the burst while the next follow is within the window from the previous one
+		for end < len(followers) && followers[end].FollowedAt.Sub(followers[end-1].FollowedAt) <= cfg.Window {
+			end++
+		}
+		burst := followers[start:end]
+		start = end
+		if len(burst) < cfg.MinFollows || now.Sub(burst[len(burst)-1].FollowedAt) <= cfg.Window {
+			continue
+		}
+		if reasons := suspicious(burst, cfg); len(reasons) > 0 {
+			waves = append(waves, Wave{
+				Start:     burst[0].FollowedAt,
+				End:       burst[len(burst)-1].FollowedAt,
+				Size:      len(burst),
+				Followers: append([]Follower(nil), burst...),
+				Reasons:   reasons,
+			})
+		}
+	}
+	return waves
+}
+
+func suspicious(burst []Follower, cfg WaveConfig) []string {
+	var reasons []string
+	fresh := 0
+	stems := make(map[string]int)
+	biggest := ""
+	for _, f := range burst {
+		if !f.Created.IsZero() && f.FollowedAt.Sub(f.Created) < cfg.NewAccount {
+			fresh++
+		}
+		s := stem(f.Login)
+		// a short stem is just a common name
+		if len(s) >= 3 && s != f.Login {
+			stems[s]++
+			if stems[s] > stems[biggest] {
+				biggest = s
+			}
+		}
+	}
+	size := float64(len(burst))
+	if float64(fresh)/size >= cfg.Suspicious {
+		reasons = append(reasons, fmt.Sprintf("%d of %d accounts are younger than %s", fresh, len(burst), cfg.NewAccount))
+	}
+	if biggest != "" && float64(stems[biggest])/size >= cfg.Suspicious {
+		reasons = append(reasons, fmt.Sprintf("%d of %d names are %s + number", stems[biggest], len(burst), biggest))
+	}
+	return reasons
+}
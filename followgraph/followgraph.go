@@ -0,0 +1,450 @@
+package followgraph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"twitchStats/database"
+)
+
+// Graph is the follows from the Followers table. Nodes are twitch ids, so a user and the channel
+// of the same user are one node and renames or display names don't split it. Names are only labels
+type Graph struct {
+	// user -> channels the user follows
+	Follows map[string]map[string]bool
+	// channel -> users which follow it
+	Audience map[string]map[string]bool
+	// id -> name shown in results
+	Names map[string]string
+	// lowercase login or display name -> id
+	ids map[string]string
+}
+
+func New() *Graph {
+	return &Graph{
+		Follows:  make(map[string]map[string]bool),
+		Audience: make(map[string]map[string]bool),
+		Names:    make(map[string]string),
+		ids:      make(map[string]string),
+	}
+}
+
+var loginRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// name keeps the login as the label, older rows may have display names
+func (g *Graph) name(id, name string) {
+	if name == "" {
+		return
+	}
+	g.ids[strings.ToLower(name)] = id
+	if label, ok := g.Names[id]; !ok || !loginRe.MatchString(label) && loginRe.MatchString(name) {
+		g.Names[id] = name
+	}
+}
+
+// Add the follow of the user to the channel
+func (g *Graph) Add(userID, userName, channelID, channelName string) {
+	if userID == "" || channelID == "" {
+		return
+	}
+	g.name(userID, userName)
+	g.name(channelID, channelName)
+	if g.Follows[userID] == nil {
+		g.Follows[userID] = make(map[string]bool)
+	}
+	if g.Audience[channelID] == nil {
+		g.Audience[channelID] = make(map[string]bool)
+	}
+	g.Follows[userID][channelID] = true
+	g.Audience[channelID][userID] = true
+}
+
+// ID finds the node by any of its names, empty if it's unknown
+func (g *Graph) ID(name string) string {
+	return g.ids[strings.ToLower(strings.TrimPrefix(name, "#"))]
+}
+
+// Name returns the label of the node
+func (g *Graph) Name(id string) string {
+	if name, ok := g.Names[id]; ok {
+		return name
+	}
+	return id
+}
+
+func (g *Graph) idsOf(names []string) []string {
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		if id := g.ID(name); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (g *Graph) namesOf(ids []string) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = g.Name(id)
+	}
+	return names
+}
+
+// Load reads the whole Followers table
+func Load() (*Graph, error) {
+	db := database.Connect()
+	defer db.Close()
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Followers(Id INTEGER PRIMARY KEY, FromId TEXT, FromName TEXT, ToId TEXT, ToName TEXT, FollowedAt TEXT);")
+	if err != nil {
+		return nil, err
+	}
+	// newer rows go last, so their names win over the old ones
+	rows, err := db.Query("SELECT COALESCE(FromId, ''), COALESCE(FromName, ''), COALESCE(ToId, ''), COALESCE(ToName, '') FROM Followers ORDER BY Id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	g := New()
+	for rows.Next() {
+		var fromID, fromName, toID, toName string
+		if err := rows.Scan(&fromID, &fromName, &toID, &toName); err != nil {
+			return nil, err
+		}
+		g.Add(fromID, fromName, toID, toName)
+	}
+	return g, rows.Err()
+}
+
+// Common returns channels which all of the users follow
+func (g *Graph) Common(users []string) []string {
+	ids := g.idsOf(users)
+	if len(ids) == 0 || len(ids) != len(users) {
+		return nil
+	}
+	var common []string
+	for _, channel := range g.sorted(g.Follows[ids[0]]) {
+		all := true
+		for _, id := range ids[1:] {
+			if !g.Follows[id][channel] {
+				all = false
+				break
+			}
+		}
+		if all {
+			common = append(common, channel)
+		}
+	}
+	return g.namesOf(common)
+}
+
+// Clusters groups the users which are connected by mutual follows, the biggest first.
+// Users without mutual follows are clusters of one, unknown users are skipped
+func (g *Graph) Clusters(users []string) [][]string {
+	set := make(map[string]bool)
+	for _, id := range g.idsOf(users) {
+		set[id] = true
+	}
+	visited := make(map[string]bool)
+	var clusters [][]string
+	for _, u := range g.sorted(set) {
+		if visited[u] {
+			continue
+		}
+		var cluster []string
+		queue := []string{u}
+		visited[u] = true
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			cluster = append(cluster, current)
+			for other := range g.Follows[current] {
+				if set[other] && !visited[other] && g.Follows[other][current] {
+					visited[other] = true
+					queue = append(queue, other)
+				}
+			}
+		}
+		names := g.namesOf(cluster)
+		sort.Strings(names)
+		clusters = append(clusters, names)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i]) > len(clusters[j]) })
+	return clusters
+}
+
+// Score is the channel with its score
+type Score struct {
+	Channel string
+	Score   float64
+	Shared  int
+}
+
+// Jaccard is the share of the common audience of two channels
+func (g *Graph) Jaccard(a, b string) float64 {
+	return g.jaccard(g.ID(a), g.ID(b))
+}
+
+func (g *Graph) jaccard(a, b string) float64 {
+	audienceA, audienceB := g.Audience[a], g.Audience[b]
+	shared := intersection(audienceA, audienceB)
+	union := len(audienceA) + len(audienceB) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+func intersection(a, b map[string]bool) int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	n := 0
+	for k := range a {
+		if b[k] {
+			n++
+		}
+	}
+	return n
+}
+
+// Similar returns channels with the most similar audience by Jaccard index
+func (g *Graph) Similar(channel string, limit int) []Score {
+	id := g.ID(channel)
+	if id == "" {
+		return nil
+	}
+	candidates := make(map[string]bool)
+	for user := range g.Audience[id] {
+		for other := range g.Follows[user] {
+			if other != id {
+				candidates[other] = true
+			}
+		}
+	}
+	scores := make([]Score, 0, len(candidates))
+	for other := range candidates {
+		scores = append(scores, Score{Channel: g.Name(other), Score: g.jaccard(id, other), Shared: intersection(g.Audience[id], g.Audience[other])})
+	}
+	return top(scores, limit)
+}
+
+// AlsoFollow returns channels which followers of the channel follow most often,
+// the score is the share of the followers
+func (g *Graph) AlsoFollow(channel string, limit int) []Score {
+	id := g.ID(channel)
+	if id == "" {
+		return nil
+	}
+	audience := g.Audience[id]
+	counts := make(map[string]int)
+	for user := range audience {
+		for other := range g.Follows[user] {
+			if other != id {
+				counts[other]++
+			}
+		}
+	}
+	scores := make([]Score, 0, len(counts))
+	for other, n := range counts {
+		scores = append(scores, Score{Channel: g.Name(other), Score: float64(n) / float64(len(audience)), Shared: n})
+	}
+	return top(scores, limit)
+}
+
+func top(scores []Score, limit int) []Score {
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Channel < scores[j].Channel
+	})
+	if limit > 0 && len(scores) > limit {
+		scores = scores[:limit]
+	}
+	return scores
+}
+
+// Path returns the shortest chain of follows between two users, the direction of follows is ignored.
+// Nil is returned if they aren't connected
+func (g *Graph) Path(from, to string) []string {
+	from, to = g.ID(from), g.ID(to)
+	if from == "" || to == "" {
+		return nil
+	}
+	if from == to {
+		return g.namesOf([]string{from})
+	}
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range g.neighbours(current) {
+			if _, ok := previous[next]; ok {
+				continue
+			}
+			previous[next] = current
+			if next == to {
+				path := []string{to}
+				for node := current; node != ""; node = previous[node] {
+					path = append([]string{node}, path...)
+				}
+				return g.namesOf(path)
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// neighbours are sorted, so the path is the same on every run
+func (g *Graph) neighbours(node string) []string {
+	set := make(map[string]bool)
+	for k := range g.Follows[node] {
+		set[k] = true
+	}
+	for k := range g.Audience[node] {
+		set[k] = true
+	}
+	return g.sorted(set)
+}
+
+// sorted returns ids ordered by their names
+func (g *Graph) sorted(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := g.Name(list[i]), g.Name(list[j])
+		if a != b {
+			return a < b
+		}
+		return list[i] < list[j]
+	})
+	return list
+}
+
+// Subgraph keeps only follows of the users
+func (g *Graph) Subgraph(users []string) *Graph {
+	sub := New()
+	for _, user := range g.idsOf(users) {
+		for channel := range g.Follows[user] {
+			sub.Add(user, g.Name(user), channel, g.Name(channel))
+		}
+	}
+	return sub
+}
+
+type edge struct {
+	from, to string
+}
+
+func (g *Graph) edges() ([]string, []edge) {
+	nodes := make(map[string]bool)
+	users := make(map[string]bool, len(g.Follows))
+	for user := range g.Follows {
+		users[user] = true
+	}
+	var edges []edge
+	for _, user := range g.sorted(users) {
+		nodes[user] = true
+		for _, channel := range g.sorted(g.Follows[user]) {
+			nodes[channel] = true
+			edges = append(edges, edge{user, channel})
+		}
+	}
+	return g.sorted(nodes), edges
+}
+
+// WriteDOT writes the graph for graphviz, nodes are ids labeled with names, users which follow someone are boxes
+func (g *Graph) WriteDOT(w io.Writer) error {
+	nodes, edges := g.edges()
+	if _, err := fmt.Fprintln(w, "digraph follows {"); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		shape := "ellipse"
+		if len(g.Follows[node]) > 0 {
+			shape = "box"
+		}
+		if _, err := fmt.Fprintf(w, "  %q [label=%q, shape=%s];\n", node, g.Name(node), shape); err != nil {
+			return err
+		}
+	}
+	for _, e := range edges {
+		if _, err := fmt.Fprintf(w, "  %q -> %q;\n", e.from, e.to); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name   `xml:"graphml"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Keys    []graphKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// WriteGraphML writes the graph for gephi or yEd, nodes are ids with names and follower counts
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	nodes, edges := g.edges()
+	doc := graphML{Xmlns: "http://graphml.graphdrawing.org/xmlns"}
+	doc.Keys = []graphKey{
+		{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+		{ID: "followers", For: "node", AttrName: "followers", AttrType: "int"},
+	}
+	doc.Graph.ID = "follows"
+	doc.Graph.EdgeDefault = "directed"
+	for _, node := range nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: node, Data: []graphMLData{
+			{Key: "label", Value: g.Name(node)},
+			{Key: "followers", Value: fmt.Sprint(len(g.Audience[node]))},
+		}})
+	}
+	for _, e := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: e.from, Target: e.to})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
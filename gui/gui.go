@@ -4,16 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
-	"time"
 
+	"twitchStats/commands/auth"
 	pb "twitchStats/commands/pb"
-	"twitchStats/database/cache"
 
 	"github.com/AllenDang/giu"
 	"github.com/AllenDang/giu/imgui"
-	"github.com/gomodule/redigo/redis"
 	"google.golang.org/grpc"
 )
 
@@ -21,33 +18,59 @@ var (
 	total          int
 	layout         giu.Layout
 	layoutProgress giu.Layout
-	str            []string
+	str            []*string
 	counter        []int
+	names          []string
+	title          string
+	duration       int32
+	weighted       bool
 	client         pb.CommandsClient
-	redisConn      redis.Conn
-	quit           chan bool
+	stopWatch      context.CancelFunc
 	status         bool
 	channel        string
 )
 
 func addInputText() {
-	var s string
+	s := new(string)
 	str = append(str, s)
-	layout = append(layout, giu.InputText(strconv.Itoa(len(str)), 0, &s))
+	layout = append(layout, giu.InputText(strconv.Itoa(len(str)), 0, s))
 }
 
 func addProgressBar() {
 	layoutProgress = layoutProgress[:1]
 	for i := range counter {
-		fraction := float32(counter[i]) / float32(total)
-		layoutProgress = append(layoutProgress, giu.ProgressBar(fraction, -1, 0, fmt.Sprintf("%d (%0.1f%%)", counter[i], fraction*100)))
+		var fraction float32
+		if total > 0 {
+			fraction = float32(counter[i]) / float32(total)
+		}
+		layoutProgress = append(layoutProgress, giu.ProgressBar(fraction, -1, 0, fmt.Sprintf("%s: %d (%0.1f%%)", names[i], counter[i], fraction*100)))
 	}
 }
 
 func startVote() {
+	if status {
+		return
+	}
+	options := make([]string, len(str))
+	for i := range str {
+		options[i] = *str[i]
+	}
+	results, err := client.StartPoll(context.Background(), &pb.StartPollRequest{
+		Channel:  channel,
+		Title:    title,
+		Options:  options,
+		Duration: duration,
+		Weighted: weighted,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	status = true
-	quit = make(chan bool)
-	sendSmartVote()
+	updateResults(results)
+	var ctx context.Context
+	ctx, stopWatch = context.WithCancel(context.Background())
+	go watchResults(ctx)
 }
 
 func loop() {
@@ -55,12 +78,24 @@ func loop() {
 	giu.Window("progress", 410, 30, 400, 200, layoutProgress)
 }
 
-func sendSmartVote() {
-	stream, err := client.ParseAndExec(context.Background(), &pb.Message{
-		Channel: channel,
-		Text:    "!smartvote 1-" + strconv.Itoa(len(str)),
-		Level:   2,
-	})
+func updateResults(results *pb.PollResults) {
+	// weighted votes are shown, so the total is their sum
+	total = 0
+	counter = make([]int, len(results.Options))
+	names = make([]string, len(results.Options))
+	for i, option := range results.Options {
+		counter[i] = int(option.Votes)
+		names[i] = option.Name
+		total += counter[i]
+	}
+	// the poll may be closed by its timer
+	status = results.Active
+	addProgressBar()
+	giu.Update()
+}
+
+func watchResults(ctx context.Context) {
+	stream, err := client.WatchPollResults(ctx, &pb.ChannelRequest{Channel: channel})
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -74,82 +109,48 @@ func sendSmartVote() {
 			fmt.Println(err)
 			break
 		}
-		fmt.Println(in.Text)
-		counter = make([]int, len(str))
-		go func() {
-			ticker := time.NewTicker(time.Millisecond * 100)
-			for {
-				select {
-				case <-quit:
-					_, err := redisConn.Do("SET", "status:"+channel, "Running")
-					if err != nil {
-						fmt.Println(err)
-					}
-					return
-				default:
-					sendVoteOptions()
-					giu.Update()
-					<-ticker.C
-					addProgressBar()
-				}
-			}
-		}()
+		updateResults(in)
 	}
 }
 
 func stopVote() {
-	if status {
-		quit <- true
-		status = false
+	if stopWatch != nil {
+		stopWatch()
 	}
-}
-
-func sendVoteOptions() {
-	stream, err := client.ParseAndExec(context.Background(), &pb.Message{
-		Channel: channel,
-		Text:    "!voteoptions",
-		Level:   2,
-	})
+	if !status {
+		return
+	}
+	status = false
+	results, err := client.StopPoll(context.Background(), &pb.ChannelRequest{Channel: channel})
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	for {
-		in, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			break
-		}
-		re := regexp.MustCompile(`Total votes (\d*)|\((\d*)\)`)
-		match := re.FindAllStringSubmatch(in.Text, -1)
-		total, err = strconv.Atoi(match[0][1])
-		if err != nil {
-			break
-		}
-		for i := range counter {
-			counter[i], err = strconv.Atoi(match[i+1][2])
-			if err != nil {
-				break
-			}
-		}
-	}
+	updateResults(results)
 }
 
 func main() {
 	wnd := giu.NewMasterWindow("Vote", 820, 260, 0, nil)
 	layout = append(layout, giu.Line(giu.Button("Add", addInputText), giu.InputText("", 0, &channel)))
+	layout = append(layout, giu.Line(giu.Label("title"), giu.InputText("##title", 150, &title), giu.InputInt("seconds", 60, &duration), giu.Checkbox("weighted", &weighted, nil)))
 	layoutProgress = append(layoutProgress, giu.Line(giu.Button("start vote", startVote), giu.Button("stop vote", stopVote)))
 	imgui.StyleColorsDark()
-	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock()}
-	grpcConn, err := grpc.Dial("localhost:3434", opts...)
+	config, err := auth.LoadConfig()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	opts, err := config.DialOptions("gui")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	opts = append(opts, grpc.WithBlock())
+	grpcConn, err := grpc.Dial(config.Address, opts...)
 	if err != nil {
 		fmt.Println("Unable to connect to grpc")
 	}
 	defer grpcConn.Close()
 	client = pb.NewCommandsClient(grpcConn)
-	redisConn = cache.GetPool().Get()
-	defer redisConn.Close()
 	wnd.Main(loop)
 }
@@ -0,0 +1,306 @@
+package identity
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"twitchStats/database"
+	"twitchStats/points"
+)
+
+// User is the twitch account, the id never changes while the login can
+type User struct {
+	ID          string
+	Login       string
+	DisplayName string
+}
+
+// Change is the new name of the known user
+type Change struct {
+	ID             string
+	OldLogin       string
+	NewLogin       string
+	OldDisplayName string
+	NewDisplayName string
+}
+
+// Name is the entry of the name history
+type Name struct {
+	Login       string
+	DisplayName string
+	Since       time.Time
+}
+
+// Lookup finds accounts by logins, it's helix in the bot and a stub in tests
+type Lookup func(logins []string) ([]User, error)
+
+func CreateTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Users(Id TEXT PRIMARY KEY, Login TEXT NOT NULL, DisplayName TEXT NOT NULL, FirstSeen TIMESTAMP NOT NULL, LastSeen TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS UsersLogin ON Users(Login);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS NameHistory(Id INTEGER PRIMARY KEY, UserId TEXT NOT NULL, Login TEXT NOT NULL, DisplayName TEXT NOT NULL, Since TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS NameHistoryUser ON NameHistory(UserId, Id);")
+	return err
+}
+
+// Observe saves the user seen in chat and returns the change if the known user has a new name
+func Observe(db *sql.DB, u User, t time.Time) (*Change, error) {
+	u.Login = strings.ToLower(u.Login)
+	if u.DisplayName == "" {
+		u.DisplayName = u.Login
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// the write goes first, so concurrent observers don't read the same old name
+	_, err = tx.Exec("INSERT INTO Users(Id, Login, DisplayName, FirstSeen, LastSeen) VALUES($1,$2,$3,$4,$4) ON CONFLICT(Id) DO UPDATE SET LastSeen=$4;",
+		u.ID, u.Login, u.DisplayName, t)
+	if err != nil {
+		return nil, err
+	}
+	change := &Change{ID: u.ID, NewLogin: u.Login, NewDisplayName: u.DisplayName}
+	var first time.Time
+	err = tx.QueryRow("SELECT Login, DisplayName, FirstSeen FROM Users WHERE Id=$1;", u.ID).Scan(&change.OldLogin, &change.OldDisplayName, &first)
+	if err != nil {
+		return nil, err
+	}
+	if first.Equal(t) {
+		// the new user, its first name starts the history
+		change.OldLogin, change.OldDisplayName = "", ""
+	} else if change.OldLogin == u.Login && change.OldDisplayName == u.DisplayName {
+		return nil, tx.Commit()
+	}
+	if _, err := tx.Exec("UPDATE Users SET Login=$1, DisplayName=$2 WHERE Id=$3;", u.Login, u.DisplayName, u.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("INSERT INTO NameHistory(UserId, Login, DisplayName, Since) VALUES($1,$2,$3,$4);", u.ID, u.Login, u.DisplayName, t); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if change.OldLogin == "" {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// Renamed is true if the data keyed by login has to be moved
+func (c *Change) Renamed() bool {
+	return c.OldLogin != c.NewLogin
+}
+
+// History returns names of the user with the login, the oldest first
+func History(login string) (string, []Name, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTables(db); err != nil {
+		return "", nil, err
+	}
+	var id string
+	// the login may be an old name of the user
+	err := db.QueryRow("SELECT UserId FROM NameHistory WHERE Login=$1 ORDER BY Id DESC LIMIT 1;", strings.ToLower(login)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	rows, err := db.Query("SELECT Login, DisplayName, Since FROM NameHistory WHERE UserId=$1 ORDER BY Id;", id)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+	var names []Name
+	for rows.Next() {
+		var name Name
+		if err := rows.Scan(&name.Login, &name.DisplayName, &name.Since); err != nil {
+			return "", nil, err
+		}
+		names = append(names, name)
+	}
+	return id, names, rows.Err()
+}
+
+func missingTable(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such table")
+}
+
+// Rename moves the data keyed by login to the new login.
+// Stats of both names are summed, points are moved with ledger entries, because the ledger is append-only
+func Rename(change *Change) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := points.CreateTable(db); err != nil {
+		return err
+	}
+	linked, err := hasColumn(db, "Stats", "UserId")
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	oldLogin, newLogin := change.OldLogin, change.NewLogin
+	_, err = tx.Exec(`INSERT INTO Stats(Channel, Username, MsgCount, WatchTime) SELECT Channel, $1, MsgCount, WatchTime FROM Stats WHERE Username=$2 AND true
+ON CONFLICT(Channel, Username) DO UPDATE SET MsgCount=MsgCount+excluded.MsgCount, WatchTime=WatchTime+excluded.WatchTime;`, newLogin, oldLogin)
+	if err != nil && !missingTable(err) {
+		return err
+	}
+	if err == nil {
+		if _, err := tx.Exec("DELETE FROM Stats WHERE Username=$1;", oldLogin); err != nil {
+			return err
+		}
+		if linked {
+			if _, err := tx.Exec("UPDATE Stats SET UserId=$1 WHERE Username=$2;", change.ID, newLogin); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO UserChannels(Channel, Username, FirstSeen, LastSeen) SELECT Channel, $1, FirstSeen, LastSeen FROM UserChannels WHERE Username=$2 AND true
+ON CONFLICT(Channel, Username) DO UPDATE SET FirstSeen=MIN(FirstSeen, excluded.FirstSeen), LastSeen=MAX(LastSeen, excluded.LastSeen);`, newLogin, oldLogin)
+	if err != nil && !missingTable(err) {
+		return err
+	}
+	if err == nil {
+		if _, err := tx.Exec("DELETE FROM UserChannels WHERE Username=$1;", oldLogin); err != nil {
+			return err
+		}
+	}
+	for _, update := range []string{
+		"UPDATE Strikes SET Username=$1 WHERE Username=$2;",
+		"UPDATE OR REPLACE RoleOverrides SET Username=$1 WHERE Username=$2;",
+		"UPDATE Tells SET Recipient=$1 WHERE Recipient=$2;",
+		"UPDATE Tells SET Sender=$1 WHERE Sender=$2;",
+		"UPDATE Reminders SET Target=$1 WHERE Target=$2;",
+		"UPDATE Reminders SET Author=$1 WHERE Author=$2;",
+		// bets of both logins in one prediction can't be merged, they stay with the old login
+		"UPDATE OR IGNORE Bets SET Username=$1 WHERE Username=$2;",
+	} {
+		if _, err := tx.Exec(update, newLogin, oldLogin); err != nil && !missingTable(err) {
+			return err
+		}
+	}
+	rows, err := tx.Query("SELECT DISTINCT Channel FROM PointsLedger WHERE Username=$1;", oldLogin)
+	if err != nil {
+		return err
+	}
+	var channels []string
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			rows.Close()
+			return err
+		}
+		channels = append(channels, channel)
+	}
+	rows.Close()
+	for _, channel := range channels {
+		balance, err := points.BalanceOf(tx, channel, oldLogin)
+		if err != nil {
+			return err
+		}
+		if err := points.Debit(tx, channel, oldLogin, balance, points.Rename); err != nil {
+			return err
+		}
+		if err := points.Credit(tx, channel, newLogin, balance, points.Rename); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info($1);", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// PrepareStats adds the UserId column to the Stats table
+func PrepareStats(db *sql.DB) error {
+	ok, err := hasColumn(db, "Stats", "UserId")
+	if err != nil || ok {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE Stats ADD COLUMN UserId TEXT;")
+	if missingTable(err) {
+		return nil
+	}
+	return err
+}
+
+// Migrate links Stats rows without ids to users found by lookup, 100 logins per call.
+// Returns the number of linked logins
+func Migrate(lookup Lookup) (int, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTables(db); err != nil {
+		return 0, err
+	}
+	if err := PrepareStats(db); err != nil {
+		return 0, err
+	}
+	rows, err := db.Query("SELECT DISTINCT Username FROM Stats WHERE UserId IS NULL ORDER BY Username;")
+	if err != nil {
+		return 0, err
+	}
+	var logins []string
+	for rows.Next() {
+		var login string
+		if err := rows.Scan(&login); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		logins = append(logins, login)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	linked := 0
+	for len(logins) > 0 {
+		batch := logins
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		logins = logins[len(batch):]
+		users, err := lookup(batch)
+		if err != nil {
+			return linked, err
+		}
+		for _, u := range users {
+			if _, err := Observe(db, u, time.Now()); err != nil {
+				return linked, err
+			}
+			if _, err := db.Exec("UPDATE Stats SET UserId=$1 WHERE Username=$2;", u.ID, strings.ToLower(u.Login)); err != nil {
+				return linked, err
+			}
+			linked++
+		}
+	}
+	return linked, nil
+}
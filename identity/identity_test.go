@@ -0,0 +1,211 @@
+package identity
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"twitchStats/database"
+	"twitchStats/points"
+)
+
+// tempDB points the database package to a fresh file with the tables which the bot creates
+func tempDB(t *testing.T) *sql.DB {
+	dir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	database.SetPath(filepath.Join(dir, "data.db"))
+	db := database.Connect()
+	t.Cleanup(func() { db.Close() })
+	for _, query := range []string{
+		"CREATE TABLE Stats(Channel TEXT NOT NULL, Username TEXT NOT NULL, MsgCount INTEGER NOT NULL DEFAULT 0, WatchTime INTEGER NOT NULL DEFAULT 0, PRIMARY KEY(Channel, Username));",
+		"CREATE TABLE UserChannels(Channel TEXT NOT NULL, Username TEXT NOT NULL, FirstSeen TIMESTAMP NOT NULL, LastSeen TIMESTAMP NOT NULL, PRIMARY KEY(Channel, Username));",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := points.CreateTable(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestObserve(t *testing.T) {
+	db := tempDB(t)
+	start := time.Now()
+	steps := []struct {
+		name    string
+		user    User
+		change  *Change
+		renamed bool
+	}{
+		{"new user", User{ID: "1", Login: "Alice", DisplayName: "Alice"}, nil, false},
+		{"same name", User{ID: "1", Login: "alice", DisplayName: "Alice"}, nil, false},
+		{"display name", User{ID: "1", Login: "alice", DisplayName: "ALICE"},
+			&Change{ID: "1", OldLogin: "alice", NewLogin: "alice", OldDisplayName: "Alice", NewDisplayName: "ALICE"}, false},
+		{"login", User{ID: "1", Login: "alicia"},
+			&Change{ID: "1", OldLogin: "alice", NewLogin: "alicia", OldDisplayName: "ALICE", NewDisplayName: "alicia"}, true},
+	}
+	for i, step := range steps {
+		change, err := Observe(db, step.user, start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if (change == nil) != (step.change == nil) || change != nil && *change != *step.change {
+			t.Errorf("%s: got change %+v, want %+v", step.name, change, step.change)
+		}
+		if change != nil && change.Renamed() != step.renamed {
+			t.Errorf("%s: got renamed %t, want %t", step.name, change.Renamed(), step.renamed)
+		}
+	}
+	// the old login still finds the user
+	id, names, err := History("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" || len(names) != 3 {
+		t.Fatalf("got id %q and %d names, want 1 and 3", id, len(names))
+	}
+	if names[0].Login != "alice" || names[2].Login != "alicia" {
+		t.Errorf("got names %+v, want alice first and alicia last", names)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db := tempDB(t)
+	var logins []string
+	for i := 0; i < 150; i++ {
+		logins = append(logins, fmt.Sprintf("user%03d", i))
+	}
+	logins = append(logins, "deleted")
+	for _, login := range logins {
+		if _, err := db.Exec("INSERT INTO Stats(Channel, Username, MsgCount) VALUES('chan', $1, 1);", login); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var batches []int
+	// the deleted account isn't found like in helix
+	lookup := func(batch []string) ([]User, error) {
+		batches = append(batches, len(batch))
+		var users []User
+		for _, login := range batch {
+			if login != "deleted" {
+				users = append(users, User{ID: "id-" + login, Login: login, DisplayName: login})
+			}
+		}
+		return users, nil
+	}
+	linked, err := Migrate(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked != 150 {
+		t.Errorf("linked %d, want 150", linked)
+	}
+	if len(batches) != 2 || batches[0] != 100 || batches[1] != 51 {
+		t.Errorf("got batches %v, want [100 51]", batches)
+	}
+	var id string
+	if err := db.QueryRow("SELECT UserId FROM Stats WHERE Username='user042';").Scan(&id); err != nil || id != "id-user042" {
+		t.Errorf("got id %q (%v), want id-user042", id, err)
+	}
+	var unlinked int
+	db.QueryRow("SELECT COUNT(*) FROM Stats WHERE UserId IS NULL;").Scan(&unlinked)
+	if unlinked != 1 {
+		t.Errorf("got %d unlinked rows, want 1", unlinked)
+	}
+	// linked rows aren't looked up again
+	batches = nil
+	if linked, err := Migrate(lookup); err != nil || linked != 0 || len(batches) != 1 || batches[0] != 1 {
+		t.Errorf("second run linked %d (%v) in batches %v, want 0 in [1]", linked, err, batches)
+	}
+}
+
+func TestRename(t *testing.T) {
+	db := tempDB(t)
+	if err := PrepareStats(db); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, query := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"INSERT INTO Stats(Channel, Username, MsgCount, WatchTime) VALUES($1,$2,$3,$4);", []interface{}{"one", "old", 5, 10}},
+		{"INSERT INTO Stats(Channel, Username, MsgCount, WatchTime) VALUES($1,$2,$3,$4);", []interface{}{"one", "new", 2, 3}},
+		{"INSERT INTO Stats(Channel, Username, MsgCount, WatchTime) VALUES($1,$2,$3,$4);", []interface{}{"two", "old", 1, 1}},
+		{"INSERT INTO UserChannels(Channel, Username, FirstSeen, LastSeen) VALUES($1,$2,$3,$4);", []interface{}{"one", "old", day, day.Add(24 * time.Hour)}},
+		{"INSERT INTO UserChannels(Channel, Username, FirstSeen, LastSeen) VALUES($1,$2,$3,$4);", []interface{}{"one", "new", day.Add(2 * 24 * time.Hour), day.Add(3 * 24 * time.Hour)}},
+	} {
+		if _, err := db.Exec(query.sql, query.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := points.Credit(db, "one", "old", 100, points.Manual); err != nil {
+		t.Fatal(err)
+	}
+	if err := points.Credit(db, "one", "new", 20, points.Manual); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Rename(&Change{ID: "7", OldLogin: "old", NewLogin: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := map[string][2]int{}
+	rows, err := db.Query("SELECT Channel, Username, MsgCount, WatchTime, UserId FROM Stats;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var channel, username string
+		var msgs, watch int
+		var id sql.NullString
+		if err := rows.Scan(&channel, &username, &msgs, &watch, &id); err != nil {
+			t.Fatal(err)
+		}
+		if username != "new" || id.String != "7" {
+			t.Errorf("row %s/%s has id %q, want only new with id 7", channel, username, id.String)
+		}
+		stats[channel] = [2]int{msgs, watch}
+	}
+	rows.Close()
+	if stats["one"] != [2]int{7, 13} || stats["two"] != [2]int{1, 1} {
+		t.Errorf("got stats %v, want one:[7 13] two:[1 1]", stats)
+	}
+
+	var first, last time.Time
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM UserChannels;").Scan(&n)
+	if err := db.QueryRow("SELECT FirstSeen, LastSeen FROM UserChannels WHERE Username='new';").Scan(&first, &last); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || !first.Equal(day) || !last.Equal(day.Add(3*24*time.Hour)) {
+		t.Errorf("got %d rows seen %s - %s, want 1 row seen %s - %s", n, first, last, day, day.Add(3*24*time.Hour))
+	}
+
+	for login, want := range map[string]int{"old": 0, "new": 120} {
+		balance, err := points.BalanceOf(db, "one", login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != want {
+			t.Errorf("%s has %d points, want %d", login, balance, want)
+		}
+	}
+	// the move is recorded in the ledger instead of rewriting it
+	var entries int
+	db.QueryRow("SELECT COUNT(*) FROM PointsLedger WHERE Reason=$1;", points.Rename).Scan(&entries)
+	if entries != 2 {
+		t.Errorf("got %d rename entries, want 2", entries)
+	}
+}
@@ -0,0 +1,105 @@
+package modes
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+// Mode changes how the bot processes the chat on top of the normal processing
+type Mode uint32
+
+const (
+	// single-character messages are counted as votes
+	Vote Mode = 1 << iota
+	// messages with the spam are banned
+	SpamAttack
+)
+
+var names = []struct {
+	mode Mode
+	name string
+}{
+	{Vote, "Smartvote"},
+	{SpamAttack, "SpamAttack"},
+}
+
+func (m Mode) String() string {
+	for _, n := range names {
+		if n.mode == m {
+			return n.name
+		}
+	}
+	return "Unknown"
+}
+
+func ParseMode(name string) (Mode, error) {
+	for _, n := range names {
+		if strings.EqualFold(n.name, name) {
+			return n.mode, nil
+		}
+	}
+	return 0, errors.New("unknown mode " + name)
+}
+
+// Set of the enabled modes, it's stored as status:<channel> in redis
+type Set uint32
+
+// Running is the channel without any modes
+const Running Set = 0
+
+func (s Set) Has(m Mode) bool {
+	return s&Set(m) != 0
+}
+
+func (s Set) With(m Mode) Set {
+	return s | Set(m)
+}
+
+func (s Set) Without(m Mode) Set {
+	return s &^ Set(m)
+}
+
+// String returns Running or enabled modes separated by |
+func (s Set) String() string {
+	if s == Running {
+		return "Running"
+	}
+	var str []string
+	for _, n := range names {
+		if s.Has(n.mode) {
+			str = append(str, n.name)
+		}
+	}
+	return strings.Join(str, "|")
+}
+
+// Parse accepts Running, a single mode like the old statuses or modes separated by | or ,
+func Parse(str string) (Set, error) {
+	set := Running
+	for _, name := range strings.FieldsFunc(str, func(r rune) bool { return r == '|' || r == ',' }) {
+		name = strings.TrimSpace(name)
+		if strings.EqualFold(name, "Running") {
+			continue
+		}
+		mode, err := ParseMode(name)
+		if err != nil {
+			return Running, err
+		}
+		set = set.With(mode)
+	}
+	return set, nil
+}
+
+// State is the set which is read and changed from different goroutines
+type State struct {
+	v uint32
+}
+
+func (s *State) Load() Set {
+	return Set(atomic.LoadUint32(&s.v))
+}
+
+func (s *State) Store(set Set) {
+	atomic.StoreUint32(&s.v, uint32(set))
+}
@@ -0,0 +1,271 @@
+package permissions
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"twitchStats/database"
+	"twitchStats/roles"
+)
+
+// Rules with this channel or command apply to all of them
+const Any = "*"
+
+// Subjects of the rule
+const (
+	SubjectUser = "user"
+	SubjectRole = "role"
+	SubjectAll  = "all"
+)
+
+// Stream states in which the rule applies
+const (
+	StateAny     = "any"
+	StateLive    = "live"
+	StateOffline = "offline"
+)
+
+type Rule struct {
+	ID      int64
+	Channel string
+	Command string
+	Subject string
+	Value   string
+	Allow   bool
+	State   string
+}
+
+func (rule *Rule) String() string {
+	action := "deny"
+	if rule.Allow {
+		action = "allow"
+	}
+	subject := rule.Subject
+	if rule.Subject != SubjectAll {
+		subject += ":" + rule.Value
+	}
+	str := fmt.Sprintf("#%d %s %s !%s in %s", rule.ID, action, subject, rule.Command, rule.Channel)
+	if rule.State != StateAny {
+		str += " when " + rule.State
+	}
+	return str
+}
+
+// Request describes who wants to use the command
+type Request struct {
+	Channel  string
+	Command  string
+	Username string
+	Roles    []string
+	Level    int
+	// level required by the command itself
+	CommandLevel int
+	// Live is called only if there are rules which depend on the stream state
+	Live func() bool
+}
+
+type Decision struct {
+	Allowed bool
+	// nil when the decision was made by the level of the command
+	Rule   *Rule
+	Reason string
+}
+
+func (rule *Rule) matches(req *Request) bool {
+	if rule.Channel != Any && rule.Channel != req.Channel {
+		return false
+	}
+	if rule.Command != Any && rule.Command != req.Command {
+		return false
+	}
+	switch rule.Subject {
+	case SubjectUser:
+		return strings.EqualFold(rule.Value, req.Username)
+	case SubjectRole:
+		for _, role := range req.Roles {
+			if role == rule.Value {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// rules about the user are more specific than rules about roles,
+// then rules about the command and the channel are more specific than global ones
+func (rule *Rule) specificity() int {
+	score := 0
+	switch rule.Subject {
+	case SubjectUser:
+		score += 4
+	case SubjectRole:
+		score += 2
+	}
+	score *= 4
+	if rule.Command != Any {
+		score += 2
+	}
+	if rule.Channel != Any {
+		score++
+	}
+	return score
+}
+
+// Evaluate rules for the request, the most specific matching rule wins and deny wins over allow.
+// Deny rules don't apply to the top level.
+// Without matching rules the level of the user is compared with the level of the command
+func Evaluate(rules []Rule, req Request) Decision {
+	var best *Rule
+	live, liveChecked := false, false
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.matches(&req) {
+			continue
+		}
+		// the top level can't be locked out, so it can always remove wrong rules
+		if !rule.Allow && req.Level >= roles.Top {
+			continue
+		}
+		if rule.State != StateAny {
+			if !liveChecked {
+				live = req.Live != nil && req.Live()
+				liveChecked = true
+			}
+			if (rule.State == StateLive) != live {
+				continue
+			}
+		}
+		if best == nil || rule.specificity() > best.specificity() ||
+			rule.specificity() == best.specificity() && best.Allow && !rule.Allow {
+			best = rule
+		}
+	}
+	if best != nil {
+		action := "denied"
+		if best.Allow {
+			action = "allowed"
+		}
+		return Decision{Allowed: best.Allow, Rule: best, Reason: action + " by rule " + best.String()}
+	}
+	if req.Level >= req.CommandLevel {
+		return Decision{Allowed: true, Reason: "allowed by level"}
+	}
+	return Decision{Allowed: false, Reason: "Not enough rights"}
+}
+
+func createTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Permissions(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Command TEXT NOT NULL, Subject TEXT NOT NULL, Value TEXT NOT NULL DEFAULT '', Allow INTEGER NOT NULL, State TEXT NOT NULL DEFAULT 'any');")
+	return err
+}
+
+// Check the request against the rules stored in the database
+func Check(req Request) (Decision, error) {
+	rules, err := List(req.Channel, req.Command)
+	if err != nil {
+		return Decision{}, err
+	}
+	return Evaluate(rules, req), nil
+}
+
+// List rules which may apply to the command in the channel, empty command lists rules of all commands
+func List(channel, command string) ([]Rule, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+	query := "SELECT Id, Channel, Command, Subject, Value, Allow, State FROM Permissions WHERE (Channel=$1 OR Channel=$2)"
+	args := []interface{}{channel, Any}
+	if command != "" {
+		query += " AND (Command=$3 OR Command=$2)"
+		args = append(args, command)
+	}
+	rows, err := db.Query(query+" ORDER BY Id;", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []Rule
+	for rows.Next() {
+		var rule Rule
+		if err := rows.Scan(&rule.ID, &rule.Channel, &rule.Command, &rule.Subject, &rule.Value, &rule.Allow, &rule.State); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func Add(rule Rule) (int64, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return 0, err
+	}
+	res, err := db.Exec("INSERT INTO Permissions(Channel, Command, Subject, Value, Allow, State) VALUES($1,$2,$3,$4,$5,$6);",
+		rule.Channel, rule.Command, rule.Subject, rule.Value, rule.Allow, rule.State)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Remove the rule of the channel, rules of other channels aren't touched
+func Remove(channel string, id int64) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	res, err := db.Exec("DELETE FROM Permissions WHERE Id=$1 AND Channel=$2;", id, channel)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("rule #" + strconv.FormatInt(id, 10) + " wasn't found in " + channel)
+	}
+	return nil
+}
+
+// ParseRule parses <allow|deny> <command|*> <user:name|role:name|all> [live|offline] [#channel|*],
+// channel defaults to the given one
+func ParseRule(channel string, args []string) (Rule, error) {
+	if len(args) < 3 {
+		return Rule{}, errors.New("<allow|deny> <command|*> <user:name|role:name|all> [live|offline] [#channel|*]")
+	}
+	rule := Rule{Channel: channel, State: StateAny}
+	switch args[0] {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return Rule{}, errors.New("action must be allow or deny")
+	}
+	rule.Command = strings.TrimPrefix(args[1], "!")
+	split := strings.SplitN(args[2], ":", 2)
+	rule.Subject = split[0]
+	switch rule.Subject {
+	case SubjectUser, SubjectRole:
+		if len(split) != 2 || split[1] == "" {
+			return Rule{}, errors.New(rule.Subject + " needs a value, e.g. " + rule.Subject + ":name")
+		}
+		rule.Value = strings.ToLower(split[1])
+	case SubjectAll:
+	default:
+		return Rule{}, errors.New("subject must be user:<name>, role:<name> or all")
+	}
+	for _, arg := range args[3:] {
+		switch {
+		case arg == StateLive || arg == StateOffline:
+			rule.State = arg
+		case arg == Any || strings.HasPrefix(arg, "#"):
+			rule.Channel = arg
+		default:
+			return Rule{}, errors.New("unknown option " + arg)
+		}
+	}
+	return rule, nil
+}
@@ -0,0 +1,95 @@
+package player
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var audioExtensions = map[string]bool{".mp3": true, ".flac": true, ".ogg": true, ".wav": true, ".m4a": true}
+
+// Local serves requests by the files from LOCAL_MUSIC_DIR, they are requested as local:<file name>
+// and played by an external player which reads the queue
+type Local struct{}
+
+func (*Local) Name() string {
+	return "local"
+}
+
+func (*Local) Match(query string) bool {
+	return strings.HasPrefix(query, "local:")
+}
+
+func musicDir() (string, error) {
+	dir := os.Getenv("LOCAL_MUSIC_DIR")
+	if dir == "" {
+		return "", errors.New("LOCAL_MUSIC_DIR isn't set")
+	}
+	return dir, nil
+}
+
+func localTrack(file string) Track {
+	name := strings.TrimSuffix(file, filepath.Ext(file))
+	track := Track{Provider: "local", URI: "local:" + file, Name: name}
+	// files are usually named "artist - title"
+	if i := strings.Index(name, " - "); i != -1 {
+		track.Artists = []string{name[:i]}
+	}
+	return track
+}
+
+func (*Local) Resolve(query string) (*Track, error) {
+	dir, err := musicDir()
+	if err != nil {
+		return nil, err
+	}
+	// only files from the directory can be requested
+	file := filepath.Base(strings.TrimPrefix(query, "local:"))
+	if file == "." || file == ".." || !audioExtensions[strings.ToLower(filepath.Ext(file))] {
+		return nil, errors.New("file wasn't found")
+	}
+	if info, err := os.Stat(filepath.Join(dir, file)); err != nil || !info.Mode().IsRegular() {
+		return nil, errors.New("file wasn't found")
+	}
+	track := localTrack(file)
+	return &track, nil
+}
+
+func (*Local) Search(query string, limit int) ([]Track, error) {
+	dir, err := musicDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []Track
+	for _, file := range files {
+		if file.IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(file.Name()))] {
+			continue
+		}
+		tracks = append(tracks, localTrack(file.Name()))
+	}
+	best := []Track{}
+	for len(best) < limit && len(tracks) > 0 {
+		i, score := Best(query, tracks)
+		if score == 0 {
+			break
+		}
+		best = append(best, tracks[i])
+		tracks = append(tracks[:i], tracks[i+1:]...)
+	}
+	return best, nil
+}
+
+// the queue itself is the playlist of the external player, it reports played songs to /api/player of the overlay
+func (*Local) Enqueue(channel string, track *Track) (string, error) {
+	return "", nil
+}
+
+func (*Local) Remove(channel, uri string, position int) error {
+	return nil
+}
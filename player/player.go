@@ -0,0 +1,114 @@
+package player
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Track is the song which can be requested from one of the providers
+type Track struct {
+	// name of the provider which serves the track
+	Provider string
+	URI      string
+	Name     string
+	Artists  []string
+	Duration time.Duration
+	Explicit bool
+}
+
+// Provider is a backend which finds tracks and plays them
+type Provider interface {
+	Name() string
+	// Match reports if the query is a link or an uri of the provider
+	Match(query string) bool
+	// Resolve the link or the uri to the track
+	Resolve(query string) (*Track, error)
+	// Search tracks by the text, the most relevant go first
+	Search(query string, limit int) ([]Track, error)
+	// Enqueue returns the snapshot of the provider playlist of the channel if it has one
+	Enqueue(channel string, track *Track) (string, error)
+	// Remove the track at the position of the provider playlist of the channel
+	Remove(channel, uri string, position int) error
+}
+
+// Providers in the order they are matched, free text is searched with the first one
+var Providers = []Provider{&Spotify{}, &YouTube{}, &Local{}}
+
+// How many candidates are compared by the free text search
+const candidates = 5
+
+// ForURI returns provider which owns the uri of the track
+func ForURI(uri string) (Provider, error) {
+	for _, provider := range Providers {
+		if strings.HasPrefix(uri, provider.Name()+":") {
+			return provider, nil
+		}
+	}
+	return nil, errors.New("no provider for " + uri)
+}
+
+// Find resolves links and uris directly, free text is searched and the best match is returned.
+// If none of the candidates matches well enough, they are returned instead
+func Find(query string) (*Track, []Track, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil, errors.New("empty query")
+	}
+	for _, provider := range Providers {
+		if provider.Match(query) {
+			track, err := provider.Resolve(query)
+			return track, nil, err
+		}
+	}
+	tracks, err := Providers[0].Search(query, candidates)
+	if err != nil || len(tracks) == 0 {
+		return nil, nil, err
+	}
+	best, score := Best(query, tracks)
+	if score < 0.5 {
+		return nil, tracks, nil
+	}
+	return &tracks[best], nil, nil
+}
+
+func tokens(str string) []string {
+	return strings.FieldsFunc(strings.ToLower(str), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Best returns index of the track which matches the query the most and its score from 0 to 1.
+// Tracks with the same score keep the order of the search
+func Best(query string, tracks []Track) (int, float64) {
+	words := tokens(query)
+	if len(words) == 0 {
+		return 0, 0
+	}
+	best, bestScore := 0, -1.0
+	for i := range tracks {
+		have := make(map[string]bool)
+		for _, word := range tokens(tracks[i].Name + " " + strings.Join(tracks[i].Artists, " ")) {
+			have[word] = true
+		}
+		found := 0
+		for _, word := range words {
+			if have[word] {
+				found++
+			}
+		}
+		score := float64(found) / float64(len(words))
+		// "artist - title" is the most common way to request
+		if strings.EqualFold(strings.TrimSpace(query), tracks[i].Name) {
+			score += 1
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if bestScore > 1 {
+		bestScore = 1
+	}
+	return best, bestScore
+}
@@ -0,0 +1,67 @@
+package player
+
+import (
+	"regexp"
+	"time"
+	"twitchStats/spotify"
+)
+
+var spotifyLink = regexp.MustCompile(`(?:open\.spotify\.com/(?:[\w-]+/)?track/|spotify:track:)([A-Za-z0-9]{22})`)
+
+type Spotify struct{}
+
+func (*Spotify) Name() string {
+	return "spotify"
+}
+
+func (*Spotify) Match(query string) bool {
+	return spotifyLink.MatchString(query)
+}
+
+func convertTrack(track *spotify.Track) Track {
+	converted := Track{
+		Provider: "spotify",
+		URI:      track.URI,
+		Name:     track.Name,
+		Duration: time.Duration(track.DurationMs) * time.Millisecond,
+		Explicit: track.Explicit,
+	}
+	for _, artist := range track.Artists {
+		converted.Artists = append(converted.Artists, artist.Name)
+	}
+	if len(converted.Artists) > 0 {
+		converted.Name = converted.Artists[0] + " - " + track.Name
+	}
+	return converted
+}
+
+func (*Spotify) Resolve(query string) (*Track, error) {
+	// tracks don't depend on the playlist of the channel
+	track, err := spotify.ForChannel("").Track(spotifyLink.FindStringSubmatch(query)[1])
+	if err != nil {
+		return nil, err
+	}
+	converted := convertTrack(track)
+	return &converted, nil
+}
+
+func (*Spotify) Search(query string, limit int) ([]Track, error) {
+	search, err := spotify.ForChannel("").Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, len(search.Tracks.Items))
+	for i := range search.Tracks.Items {
+		tracks[i] = convertTrack(&search.Tracks.Items[i])
+	}
+	return tracks, nil
+}
+
+func (*Spotify) Enqueue(channel string, track *Track) (string, error) {
+	return spotify.ForChannel(channel).AddToPlaylist(track.URI)
+}
+
+func (*Spotify) Remove(channel, uri string, position int) error {
+	_, err := spotify.ForChannel(channel).RemoveTrack(uri, position)
+	return err
+}
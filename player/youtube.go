@@ -0,0 +1,133 @@
+package player
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"twitchStats/request"
+)
+
+var (
+	youtubeLink     = regexp.MustCompile(`(?:youtube\.com/watch\?(?:\S*&)?v=|youtu\.be/|youtube\.com/shorts/)([\w-]{11})`)
+	youtubeDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+)
+
+// YouTube serves requests by the videos, they are played by an external player which reads the queue.
+// YOUTUBE_API_KEY must be set to resolve and search videos
+type YouTube struct{}
+
+type youtubeVideos struct {
+	Items []struct {
+		ID      string `json:"id"`
+		Snippet struct {
+			Title        string `json:"title"`
+			ChannelTitle string `json:"channelTitle"`
+		} `json:"snippet"`
+		ContentDetails struct {
+			Duration      string `json:"duration"`
+			ContentRating struct {
+				YtRating string `json:"ytRating"`
+			} `json:"contentRating"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+}
+
+type youtubeSearch struct {
+	Items []struct {
+		ID struct {
+			VideoID string `json:"videoId"`
+		} `json:"id"`
+	} `json:"items"`
+}
+
+func (*YouTube) Name() string {
+	return "youtube"
+}
+
+func (*YouTube) Match(query string) bool {
+	return youtubeLink.MatchString(query)
+}
+
+func parseYoutubeDuration(str string) time.Duration {
+	match := youtubeDuration.FindStringSubmatch(str)
+	if match == nil {
+		return 0
+	}
+	var duration time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, _ := strconv.Atoi(match[i+1])
+		duration += time.Duration(n) * unit
+	}
+	return duration
+}
+
+func youtubeGet(endpoint string, params url.Values, obj interface{}) error {
+	key := os.Getenv("YOUTUBE_API_KEY")
+	if key == "" {
+		return errors.New("YOUTUBE_API_KEY isn't set")
+	}
+	params.Set("key", key)
+	req, _ := http.NewRequest("GET", "https://www.googleapis.com/youtube/v3/"+endpoint+"?"+params.Encode(), nil)
+	return request.JSON(req, 10, obj)
+}
+
+func (*YouTube) videos(ids []string) ([]Track, error) {
+	var videos youtubeVideos
+	err := youtubeGet("videos", url.Values{"part": {"snippet,contentDetails"}, "id": {strings.Join(ids, ",")}}, &videos)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, len(videos.Items))
+	for i, video := range videos.Items {
+		tracks[i] = Track{
+			Provider: "youtube",
+			URI:      "youtube:" + video.ID,
+			Name:     video.Snippet.Title,
+			Artists:  []string{video.Snippet.ChannelTitle},
+			Duration: parseYoutubeDuration(video.ContentDetails.Duration),
+			Explicit: video.ContentDetails.ContentRating.YtRating == "ytAgeRestricted",
+		}
+	}
+	return tracks, nil
+}
+
+func (yt *YouTube) Resolve(query string) (*Track, error) {
+	tracks, err := yt.videos([]string{youtubeLink.FindStringSubmatch(query)[1]})
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("video wasn't found")
+	}
+	return &tracks[0], nil
+}
+
+func (yt *YouTube) Search(query string, limit int) ([]Track, error) {
+	var search youtubeSearch
+	err := youtubeGet("search", url.Values{"part": {"id"}, "type": {"video"}, "videoCategoryId": {"10"}, "maxResults": {strconv.Itoa(limit)}, "q": {query}}, &search)
+	if err != nil {
+		return nil, err
+	}
+	if len(search.Items) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(search.Items))
+	for i, item := range search.Items {
+		ids[i] = item.ID.VideoID
+	}
+	return yt.videos(ids)
+}
+
+// the queue itself is the playlist of the external player, it reports played songs to /api/player of the overlay
+func (*YouTube) Enqueue(channel string, track *Track) (string, error) {
+	return "", nil
+}
+
+func (*YouTube) Remove(channel, uri string, position int) error {
+	return nil
+}
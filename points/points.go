@@ -0,0 +1,349 @@
+package points
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"twitchStats/database"
+)
+
+// Reasons of the ledger entries
+const (
+	Watch     = "watch"
+	Chat      = "chat"
+	Sub       = "sub"
+	Raid      = "raid"
+	Bet       = "bet"
+	Payout    = "payout"
+	Refund    = "refund"
+	Give      = "give"
+	Manual    = "manual"
+	Migration = "migration"
+	Rename    = "rename"
+)
+
+var ErrInsufficient = errors.New("not enough points")
+
+// Execer is *sql.DB or *sql.Tx, so points can be changed in the transaction of the caller
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Entry is the change of the balance, entries are never updated or deleted,
+// so the balance of the user is the balance of the last entry
+type Entry struct {
+	ID        int64
+	Channel   string
+	Username  string
+	Amount    int
+	Balance   int
+	Reason    string
+	CreatedAt time.Time
+}
+
+// CreateTable creates the ledger and moves balances of the old Points table into it
+func CreateTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS PointsLedger(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Username TEXT NOT NULL, Amount INTEGER NOT NULL, Balance INTEGER NOT NULL CHECK(Balance >= 0), Reason TEXT NOT NULL, CreatedAt TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS PointsLedgerUser ON PointsLedger(Channel, Username, Id);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TRIGGER IF NOT EXISTS PointsLedgerNoUpdate BEFORE UPDATE ON PointsLedger BEGIN SELECT RAISE(ABORT, 'points ledger is append-only'); END;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TRIGGER IF NOT EXISTS PointsLedgerNoDelete BEFORE DELETE ON PointsLedger BEGIN SELECT RAISE(ABORT, 'points ledger is append-only'); END;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS PointsRates(Channel TEXT PRIMARY KEY, PerMinute INTEGER NOT NULL, PerMessage INTEGER NOT NULL, MaxMessages INTEGER NOT NULL, Sub INTEGER NOT NULL, Raid INTEGER NOT NULL);")
+	if err != nil {
+		return err
+	}
+	return migrate(db)
+}
+
+func migrate(db *sql.DB) error {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type='table' AND name='Points';").Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO PointsLedger(Channel, Username, Amount, Balance, Reason, CreatedAt) SELECT Channel, Username, Balance, Balance, $1, $2 FROM Points WHERE Balance > 0;",
+		Migration, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DROP TABLE Points;"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// add appends the entry with the new balance in one statement, so the balance is read under the write lock.
+// Nothing is inserted if the balance would become negative
+func add(tx Execer, channel, username string, amount int, reason string) error {
+	res, err := tx.Exec(`INSERT INTO PointsLedger(Channel, Username, Amount, Balance, Reason, CreatedAt)
+SELECT $1, $2, $3, b.Balance+$3, $4, $5 FROM (SELECT COALESCE((SELECT Balance FROM PointsLedger WHERE Channel=$1 AND Username=$2 ORDER BY Id DESC LIMIT 1), 0) AS Balance) b
+WHERE b.Balance+$3 >= 0;`, channel, username, amount, reason, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInsufficient
+	}
+	return nil
+}
+
+// Credit adds points to the user
+func Credit(tx Execer, channel, username string, amount int, reason string) error {
+	if amount < 0 {
+		return errors.New("amount can't be negative")
+	}
+	if amount == 0 {
+		return nil
+	}
+	return add(tx, channel, username, amount, reason)
+}
+
+// Debit takes points from the user, ErrInsufficient is returned if the balance is too low
+func Debit(tx Execer, channel, username string, amount int, reason string) error {
+	if amount < 0 {
+		return errors.New("amount can't be negative")
+	}
+	if amount == 0 {
+		return nil
+	}
+	return add(tx, channel, username, -amount, reason)
+}
+
+// Transfer moves points between users in one transaction
+func Transfer(channel, from, to string, amount int) error {
+	if amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	if from == to {
+		return errors.New("can't give points to yourself")
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := Debit(tx, channel, from, amount, Give); err != nil {
+		return err
+	}
+	if err := Credit(tx, channel, to, amount, Give); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Adjust changes the balance by the mod, negative amount takes points
+func Adjust(channel, username string, amount int) (int, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if err := add(tx, channel, username, amount, Manual); err != nil {
+		return 0, err
+	}
+	balance, err := BalanceOf(tx, channel, username)
+	if err != nil {
+		return 0, err
+	}
+	return balance, tx.Commit()
+}
+
+// Bonus credits the user with the bonus of the channel for the sub or the raid
+func Bonus(channel, username, reason string) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return err
+	}
+	rates, err := RatesOf(db, channel)
+	if err != nil {
+		return err
+	}
+	var amount int
+	switch reason {
+	case Sub:
+		amount = rates.Sub
+	case Raid:
+		amount = rates.Raid
+	default:
+		return errors.New("unknown bonus " + reason)
+	}
+	return Credit(db, channel, username, amount, reason)
+}
+
+func BalanceOf(tx Execer, channel, username string) (int, error) {
+	var balance int
+	err := tx.QueryRow("SELECT Balance FROM PointsLedger WHERE Channel=$1 AND Username=$2 ORDER BY Id DESC LIMIT 1;", channel, username).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+func Balance(channel, username string) (int, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return 0, err
+	}
+	return BalanceOf(db, channel, username)
+}
+
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Scan(&entry.ID, &entry.Channel, &entry.Username, &entry.Amount, &entry.Balance, &entry.Reason, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// History returns the last changes of the balance, the newest first
+func History(channel, username string, limit int) ([]Entry, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT Id, Channel, Username, Amount, Balance, Reason, CreatedAt FROM PointsLedger WHERE Channel=$1 AND Username=$2 ORDER BY Id DESC LIMIT $3;", channel, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Top returns the last entries of the users with the biggest balances
+func Top(channel string, limit int) ([]Entry, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT l.Id, l.Channel, l.Username, l.Amount, l.Balance, l.Reason, l.CreatedAt FROM PointsLedger l
+WHERE l.Channel=$1 AND l.Id=(SELECT MAX(Id) FROM PointsLedger WHERE Channel=l.Channel AND Username=l.Username) AND l.Balance > 0
+ORDER BY l.Balance DESC, l.Username LIMIT $2;`, channel, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// Rates are points which viewers of the channel earn
+type Rates struct {
+	// for a minute of watch time
+	PerMinute int
+	// for a message, but not more than MaxMessages messages between checks of the stats
+	PerMessage  int
+	MaxMessages int
+	Sub         int
+	Raid        int
+}
+
+var DefaultRates = Rates{PerMinute: 1, PerMessage: 1, MaxMessages: 10, Sub: 500, Raid: 250}
+
+// names of the rates for SetRate
+var rateColumns = map[string]string{
+	"minute":   "PerMinute",
+	"message":  "PerMessage",
+	"messages": "MaxMessages",
+	"sub":      "Sub",
+	"raid":     "Raid",
+}
+
+func RateNames() []string {
+	return []string{"minute", "message", "messages", "sub", "raid"}
+}
+
+func RatesOf(q Execer, channel string) (Rates, error) {
+	rates := DefaultRates
+	err := q.QueryRow("SELECT PerMinute, PerMessage, MaxMessages, Sub, Raid FROM PointsRates WHERE Channel=$1;", channel).
+		Scan(&rates.PerMinute, &rates.PerMessage, &rates.MaxMessages, &rates.Sub, &rates.Raid)
+	if err == sql.ErrNoRows {
+		return DefaultRates, nil
+	}
+	return rates, err
+}
+
+func GetRates(channel string) (Rates, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return Rates{}, err
+	}
+	return RatesOf(db, channel)
+}
+
+// SetRate changes one rate of the channel, the others keep their values
+func SetRate(channel, name string, value int) (Rates, error) {
+	column, ok := rateColumns[name]
+	if !ok {
+		return Rates{}, fmt.Errorf("unknown rate %s", name)
+	}
+	if value < 0 {
+		return Rates{}, errors.New("rate can't be negative")
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTable(db); err != nil {
+		return Rates{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return Rates{}, err
+	}
+	defer tx.Rollback()
+	d := DefaultRates
+	_, err = tx.Exec("INSERT OR IGNORE INTO PointsRates(Channel, PerMinute, PerMessage, MaxMessages, Sub, Raid) VALUES($1,$2,$3,$4,$5,$6);",
+		channel, d.PerMinute, d.PerMessage, d.MaxMessages, d.Sub, d.Raid)
+	if err != nil {
+		return Rates{}, err
+	}
+	// column comes from rateColumns, not from the user
+	if _, err := tx.Exec("UPDATE PointsRates SET "+column+"=$1 WHERE Channel=$2;", value, channel); err != nil {
+		return Rates{}, err
+	}
+	rates, err := RatesOf(tx, channel)
+	if err != nil {
+		return Rates{}, err
+	}
+	return rates, tx.Commit()
+}
+
+func (r Rates) String() string {
+	return fmt.Sprintf("minute=%d message=%d messages=%d sub=%d raid=%d", r.PerMinute, r.PerMessage, r.MaxMessages, r.Sub, r.Raid)
+}
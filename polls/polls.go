@@ -0,0 +1,300 @@
+package polls
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"twitchStats/database"
+)
+
+// Votes of subscribers count this many times in weighted polls
+const SubWeight = 2
+
+type Option struct {
+	Number int
+	// empty for numeric polls
+	Name string
+	// sum of the weights of the votes
+	Votes  int
+	Voters int
+}
+
+type Results struct {
+	ID       int64
+	Channel  string
+	Title    string
+	Weighted bool
+	Options  []Option
+	// number of voters
+	Total     int
+	Active    bool
+	StartedAt time.Time
+	// zero if the poll is closed by hand
+	Ends    time.Time
+	EndedAt time.Time
+}
+
+// Winners returns options with the most votes, none if nobody voted
+func (r *Results) Winners() []Option {
+	var winners []Option
+	max := 0
+	for _, option := range r.Options {
+		switch {
+		case option.Votes > max:
+			max = option.Votes
+			winners = []Option{option}
+		case option.Votes == max && max > 0:
+			winners = append(winners, option)
+		}
+	}
+	return winners
+}
+
+type vote struct {
+	option int
+	weight int
+}
+
+// Poll accepts one vote per user, the vote can be changed until the poll is closed
+type Poll struct {
+	mu      sync.Mutex
+	results Results
+	numbers map[int]int
+	votes   map[string]vote
+}
+
+func newPoll(channel, title string, weighted bool, duration time.Duration) *Poll {
+	p := &Poll{
+		results: Results{Channel: channel, Title: title, Weighted: weighted, Active: true, StartedAt: time.Now()},
+		numbers: make(map[int]int),
+		votes:   make(map[string]vote),
+	}
+	if duration > 0 {
+		p.results.Ends = p.results.StartedAt.Add(duration)
+	}
+	return p
+}
+
+// MaxOptions limits the size of the poll, options are kept in memory
+const MaxOptions = 100
+
+// New creates poll with named options numbered from 1, it's closed by hand if duration is 0
+func New(channel, title string, options []string, weighted bool, duration time.Duration) (*Poll, error) {
+	if len(options) < 2 {
+		return nil, errors.New("poll needs at least two options")
+	}
+	if len(options) > MaxOptions {
+		return nil, fmt.Errorf("poll can't have more than %d options", MaxOptions)
+	}
+	p := newPoll(channel, title, weighted, duration)
+	seen := make(map[string]bool)
+	for i, name := range options {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, errors.New("option can't be empty")
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, errors.New("option " + name + " is repeated")
+		}
+		seen[strings.ToLower(name)] = true
+		p.numbers[i+1] = i
+		p.results.Options = append(p.results.Options, Option{Number: i + 1, Name: name})
+	}
+	return p, nil
+}
+
+// NewRange creates poll with numeric options from lower to upper
+func NewRange(channel, title string, lower, upper int, weighted bool, duration time.Duration) (*Poll, error) {
+	if lower < 0 || lower >= upper {
+		return nil, errors.New("wrong bounds")
+	}
+	if upper-lower >= MaxOptions {
+		return nil, fmt.Errorf("poll can't have more than %d options", MaxOptions)
+	}
+	p := newPoll(channel, title, weighted, duration)
+	for i := lower; i <= upper; i++ {
+		p.numbers[i] = len(p.results.Options)
+		p.results.Options = append(p.results.Options, Option{Number: i})
+	}
+	return p, nil
+}
+
+// find option by its number, name or unique prefix of the name
+func (p *Poll) find(choice string) (int, error) {
+	choice = strings.TrimSpace(choice)
+	if n, err := strconv.Atoi(choice); err == nil {
+		if i, ok := p.numbers[n]; ok {
+			return i, nil
+		}
+		return 0, errors.New("option is out of bounds")
+	}
+	names := make([]string, len(p.results.Options))
+	for i, option := range p.results.Options {
+		names[i] = option.Name
+	}
+	return Choose(names, choice)
+}
+
+// Choose finds the option by its number from 1, name or unique prefix of the name
+func Choose(names []string, choice string) (int, error) {
+	choice = strings.TrimSpace(choice)
+	if n, err := strconv.Atoi(choice); err == nil {
+		if n < 1 || n > len(names) {
+			return 0, errors.New("option is out of bounds")
+		}
+		return n - 1, nil
+	}
+	found := -1
+	lower := strings.ToLower(choice)
+	for i, name := range names {
+		name = strings.ToLower(name)
+		if name == "" {
+			continue
+		}
+		if name == lower {
+			return i, nil
+		}
+		if lower != "" && strings.HasPrefix(name, lower) {
+			if found != -1 {
+				return 0, errors.New("option " + choice + " is ambiguous")
+			}
+			found = i
+		}
+	}
+	if found == -1 {
+		return 0, errors.New("option " + choice + " wasn't found")
+	}
+	return found, nil
+}
+
+// Vote replaces the previous vote of the user
+func (p *Poll) Vote(username, choice string, subscriber bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.results.Active {
+		return errors.New("poll is closed")
+	}
+	i, err := p.find(choice)
+	if err != nil {
+		return err
+	}
+	weight := 1
+	if p.results.Weighted && subscriber {
+		weight = SubWeight
+	}
+	if prev, ok := p.votes[username]; ok {
+		p.results.Options[prev.option].Votes -= prev.weight
+		p.results.Options[prev.option].Voters--
+	} else {
+		p.results.Total++
+	}
+	p.votes[username] = vote{option: i, weight: weight}
+	p.results.Options[i].Votes += weight
+	p.results.Options[i].Voters++
+	return nil
+}
+
+func (p *Poll) Results() Results {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := p.results
+	results.Options = append([]Option(nil), p.results.Options...)
+	return results
+}
+
+// Close returns final results, closed is false if the poll was already closed
+func (p *Poll) Close() (results Results, closed bool) {
+	p.mu.Lock()
+	closed = p.results.Active
+	if closed {
+		p.results.Active = false
+		p.results.EndedAt = time.Now()
+	}
+	p.mu.Unlock()
+	return p.Results(), closed
+}
+
+func createTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Polls(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Title TEXT NOT NULL, Weighted INTEGER NOT NULL, Total INTEGER NOT NULL, StartedAt TIMESTAMP NOT NULL, EndedAt TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS PollOptions(PollId INTEGER NOT NULL REFERENCES Polls(Id), Number INTEGER NOT NULL, Name TEXT NOT NULL, Votes INTEGER NOT NULL, Voters INTEGER NOT NULL);")
+	return err
+}
+
+// Save closed poll into the history
+func Save(results *Results) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO Polls(Channel, Title, Weighted, Total, StartedAt, EndedAt) VALUES($1,$2,$3,$4,$5,$6);",
+		results.Channel, results.Title, results.Weighted, results.Total, results.StartedAt, results.EndedAt)
+	if err != nil {
+		return err
+	}
+	if results.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	for _, option := range results.Options {
+		_, err := tx.Exec("INSERT INTO PollOptions(PollId, Number, Name, Votes, Voters) VALUES($1,$2,$3,$4,$5);",
+			results.ID, option.Number, option.Name, option.Votes, option.Voters)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// History returns the last polls of the channel, the latest go first
+func History(channel string, limit int) ([]Results, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT Id, Title, Weighted, Total, StartedAt, EndedAt FROM Polls WHERE Channel=$1 ORDER BY Id DESC LIMIT $2;", channel, limit)
+	if err != nil {
+		return nil, err
+	}
+	var history []Results
+	for rows.Next() {
+		results := Results{Channel: channel}
+		if err := rows.Scan(&results.ID, &results.Title, &results.Weighted, &results.Total, &results.StartedAt, &results.EndedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		history = append(history, results)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range history {
+		rows, err := db.Query("SELECT Number, Name, Votes, Voters FROM PollOptions WHERE PollId=$1 ORDER BY Number;", history[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var option Option
+			if err := rows.Scan(&option.Number, &option.Name, &option.Votes, &option.Voters); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			history[i].Options = append(history[i].Options, option)
+		}
+		rows.Close()
+	}
+	return history, nil
+}
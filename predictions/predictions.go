@@ -0,0 +1,386 @@
+package predictions
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"twitchStats/database"
+	"twitchStats/points"
+	"twitchStats/polls"
+)
+
+// Statuses of the prediction
+const (
+	Open      = "open"
+	Locked    = "locked"
+	Resolved  = "resolved"
+	Cancelled = "cancelled"
+)
+
+var ErrNoPrediction = errors.New("there is no active prediction")
+
+type Outcome struct {
+	Number  int
+	Name    string
+	Pool    int
+	Bettors int
+}
+
+type Prediction struct {
+	ID       int64
+	Channel  string
+	Title    string
+	Status   string
+	Outcomes []Outcome
+	// number of the winning outcome, 0 if there is none
+	Winner    int
+	CreatedAt time.Time
+}
+
+func (p *Prediction) Pool() int {
+	total := 0
+	for _, outcome := range p.Outcomes {
+		total += outcome.Pool
+	}
+	return total
+}
+
+type Payout struct {
+	Username string
+	Amount   int
+}
+
+func createTables(db *sql.DB) error {
+	if err := points.CreateTable(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Predictions(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Title TEXT NOT NULL, Status TEXT NOT NULL, Winner INTEGER NOT NULL DEFAULT 0, CreatedAt TIMESTAMP NOT NULL, ResolvedAt TIMESTAMP);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS PredictionOutcomes(PredictionId INTEGER NOT NULL REFERENCES Predictions(Id), Number INTEGER NOT NULL, Name TEXT NOT NULL, PRIMARY KEY(PredictionId, Number));")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS Bets(PredictionId INTEGER NOT NULL REFERENCES Predictions(Id), Username TEXT NOT NULL, Outcome INTEGER NOT NULL, Amount INTEGER NOT NULL, Payout INTEGER, PRIMARY KEY(PredictionId, Username));")
+	return err
+}
+
+// begin opens the immediate transaction, sqlite takes the write lock before anything is read,
+// so concurrent commands can't pay or bet on the same state
+func begin() (*sql.DB, *sql.Tx, error) {
+	db := database.ConnectImmediate()
+	if err := createTables(db); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, tx, nil
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// active returns the open or locked prediction of the channel with its pools
+func active(q queryer, channel string) (*Prediction, error) {
+	p := &Prediction{Channel: channel}
+	err := q.QueryRow("SELECT Id, Title, Status, Winner, CreatedAt FROM Predictions WHERE Channel=$1 AND Status IN ($2,$3) ORDER BY Id DESC LIMIT 1;", channel, Open, Locked).
+		Scan(&p.ID, &p.Title, &p.Status, &p.Winner, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoPrediction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, outcomes(q, p)
+}
+
+func outcomes(q queryer, p *Prediction) error {
+	rows, err := q.Query("SELECT o.Number, o.Name, COALESCE(SUM(b.Amount), 0), COUNT(b.Username) FROM PredictionOutcomes o LEFT JOIN Bets b ON b.PredictionId=o.PredictionId AND b.Outcome=o.Number WHERE o.PredictionId=$1 GROUP BY o.Number, o.Name ORDER BY o.Number;", p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	p.Outcomes = nil
+	for rows.Next() {
+		var outcome Outcome
+		if err := rows.Scan(&outcome.Number, &outcome.Name, &outcome.Pool, &outcome.Bettors); err != nil {
+			return err
+		}
+		p.Outcomes = append(p.Outcomes, outcome)
+	}
+	return rows.Err()
+}
+
+func (p *Prediction) choose(choice string) (int, error) {
+	names := make([]string, len(p.Outcomes))
+	for i, outcome := range p.Outcomes {
+		names[i] = outcome.Name
+	}
+	i, err := polls.Choose(names, choice)
+	if err != nil {
+		return 0, err
+	}
+	return p.Outcomes[i].Number, nil
+}
+
+// Start opens the prediction, there can be only one active prediction in the channel
+func Start(channel, title string, names []string) (*Prediction, error) {
+	if len(names) < 2 {
+		return nil, errors.New("prediction needs at least two outcomes")
+	}
+	db, tx, err := begin()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	defer tx.Rollback()
+	p := &Prediction{Channel: channel, Title: title, Status: Open, CreatedAt: time.Now()}
+	res, err := tx.Exec("INSERT INTO Predictions(Channel, Title, Status, CreatedAt) VALUES($1,$2,$3,$4);", channel, title, Open, p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if p.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM Predictions WHERE Channel=$1 AND Status IN ($2,$3);", channel, Open, Locked).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count > 1 {
+		return nil, errors.New("there is already an active prediction")
+	}
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, errors.New("outcome can't be empty")
+		}
+		if _, err := tx.Exec("INSERT INTO PredictionOutcomes(PredictionId, Number, Name) VALUES($1,$2,$3);", p.ID, i+1, name); err != nil {
+			return nil, err
+		}
+		p.Outcomes = append(p.Outcomes, Outcome{Number: i + 1, Name: name})
+	}
+	return p, tx.Commit()
+}
+
+func Current(channel string) (*Prediction, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return nil, err
+	}
+	return active(db, channel)
+}
+
+// Bet takes points from the user and bets them on the outcome, the user can raise the bet
+// only on the same outcome. Returns the outcome and the total bet of the user
+func Bet(channel, username, choice string, amount int) (*Outcome, int, error) {
+	if amount <= 0 {
+		return nil, 0, errors.New("amount must be positive")
+	}
+	db, tx, err := begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+	defer tx.Rollback()
+	if err := points.Debit(tx, channel, username, amount, points.Bet); err != nil {
+		return nil, 0, err
+	}
+	p, err := active(tx, channel)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.Status != Open {
+		return nil, 0, errors.New("bets are closed")
+	}
+	number, err := p.choose(choice)
+	if err != nil {
+		return nil, 0, err
+	}
+	var previous int
+	err = tx.QueryRow("SELECT Outcome FROM Bets WHERE PredictionId=$1 AND Username=$2;", p.ID, username).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, 0, err
+	}
+	if err == nil && previous != number {
+		return nil, 0, errors.New("you already bet on " + p.Outcomes[previous-1].Name)
+	}
+	_, err = tx.Exec("INSERT INTO Bets(PredictionId, Username, Outcome, Amount) VALUES($1,$2,$3,$4) ON CONFLICT(PredictionId, Username) DO UPDATE SET Amount=Amount+$4;",
+		p.ID, username, number, amount)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	if err := tx.QueryRow("SELECT Amount FROM Bets WHERE PredictionId=$1 AND Username=$2;", p.ID, username).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	outcome := p.Outcomes[number-1]
+	return &outcome, total, tx.Commit()
+}
+
+// Lock stops accepting bets
+func Lock(channel string) (*Prediction, error) {
+	db, tx, err := begin()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE Predictions SET Status=$1 WHERE Channel=$2 AND Status=$3;", Locked, channel, Open)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNoPrediction
+	}
+	p, err := active(tx, channel)
+	if err != nil {
+		return nil, err
+	}
+	return p, tx.Commit()
+}
+
+// finish changes the status of the active prediction, it's the first write of the transaction,
+// so the same prediction can't be finished twice
+func finish(tx *sql.Tx, channel, status string) (*Prediction, error) {
+	p, err := active(tx, channel)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec("UPDATE Predictions SET Status=$1, ResolvedAt=$2 WHERE Id=$3 AND Status IN ($4,$5);", status, time.Now(), p.ID, Open, Locked)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNoPrediction
+	}
+	p.Status = status
+	return p, nil
+}
+
+// refund returns all bets of the prediction
+func refund(tx *sql.Tx, p *Prediction) ([]Payout, error) {
+	return pay(tx, p, points.Refund, func(outcome, amount int) int { return amount })
+}
+
+// pay credits every bet with the amount returned by payout and saves it.
+// Points lost by rounding the shares down go to the biggest paid bet, so the whole pool is paid
+func pay(tx *sql.Tx, p *Prediction, reason string, payout func(outcome, amount int) int) ([]Payout, error) {
+	rows, err := tx.Query("SELECT Username, Outcome, Amount FROM Bets WHERE PredictionId=$1 ORDER BY Username;", p.ID)
+	if err != nil {
+		return nil, err
+	}
+	type bet struct {
+		username        string
+		outcome, amount int
+	}
+	var bets []bet
+	for rows.Next() {
+		var b bet
+		if err := rows.Scan(&b.username, &b.outcome, &b.amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bets = append(bets, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	amounts := make([]int, len(bets))
+	paid, biggest := 0, -1
+	for i, b := range bets {
+		amounts[i] = payout(b.outcome, b.amount)
+		paid += amounts[i]
+		if amounts[i] > 0 && (biggest == -1 || b.amount > bets[biggest].amount) {
+			biggest = i
+		}
+	}
+	if pool := p.Pool(); biggest != -1 && paid < pool {
+		amounts[biggest] += pool - paid
+	}
+	var payouts []Payout
+	for i, b := range bets {
+		amount := amounts[i]
+		if _, err := tx.Exec("UPDATE Bets SET Payout=$1 WHERE PredictionId=$2 AND Username=$3;", amount, p.ID, b.username); err != nil {
+			return nil, err
+		}
+		if amount == 0 {
+			continue
+		}
+		if err := points.Credit(tx, p.Channel, b.username, amount, reason); err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, Payout{Username: b.username, Amount: amount})
+	}
+	return payouts, nil
+}
+
+// Resolve pays the whole pool to the winners in proportion to their bets.
+// If nobody bet on the winner, all bets are returned
+func Resolve(channel, choice string) (*Prediction, []Payout, error) {
+	db, tx, err := begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	defer tx.Rollback()
+	p, err := active(tx, channel)
+	if err != nil {
+		return nil, nil, err
+	}
+	winner, err := p.choose(choice)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p, err = finish(tx, channel, Resolved); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec("UPDATE Predictions SET Winner=$1 WHERE Id=$2;", winner, p.ID); err != nil {
+		return nil, nil, err
+	}
+	p.Winner = winner
+	total, winners := p.Pool(), p.Outcomes[winner-1].Pool
+	var payouts []Payout
+	if winners == 0 {
+		payouts, err = refund(tx, p)
+	} else {
+		payouts, err = pay(tx, p, points.Payout, func(outcome, amount int) int {
+			if outcome != winner {
+				return 0
+			}
+			return amount * total / winners
+		})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, payouts, tx.Commit()
+}
+
+// Cancel returns all bets
+func Cancel(channel string) (*Prediction, []Payout, error) {
+	db, tx, err := begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	defer tx.Rollback()
+	p, err := finish(tx, channel, Cancelled)
+	if err != nil {
+		return nil, nil, err
+	}
+	payouts, err := refund(tx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, payouts, tx.Commit()
+}
@@ -0,0 +1,40 @@
+package profile
+
+import (
+	"database/sql"
+	"time"
+	"twitchStats/database"
+)
+
+// Execer is *sql.DB or *sql.Tx
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func CreateTables(db Execer) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Strikes(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Username TEXT NOT NULL, Reason TEXT NOT NULL, Seconds INTEGER NOT NULL, CreatedAt TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS UserChannels(Channel TEXT NOT NULL, Username TEXT NOT NULL, FirstSeen TIMESTAMP NOT NULL, LastSeen TIMESTAMP NOT NULL, PRIMARY KEY(Channel, Username));")
+	return err
+}
+
+// AddStrike saves the timeout or the ban given by the bot, ban has 0 seconds
+func AddStrike(channel, username, reason string, duration time.Duration) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO Strikes(Channel, Username, Reason, Seconds, CreatedAt) VALUES($1,$2,$3,$4,$5);",
+		channel, username, reason, int64(duration/time.Second), time.Now())
+	return err
+}
+
+// MarkSeen updates the first and the last time the user was in the channel
+func MarkSeen(tx Execer, channel, username string, t time.Time) error {
+	_, err := tx.Exec("INSERT INTO UserChannels(Channel, Username, FirstSeen, LastSeen) VALUES($1,$2,$3,$3) ON CONFLICT(Channel, Username) DO UPDATE SET LastSeen=$3;",
+		channel, username, t)
+	return err
+}
@@ -0,0 +1,218 @@
+package profile
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"twitchStats/afk"
+	"twitchStats/database"
+	"twitchStats/identity"
+	"twitchStats/seen"
+	"twitchStats/terminal"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type Channel struct {
+	Channel   string        `json:"channel"`
+	FirstSeen time.Time     `json:"first_seen,omitempty"`
+	LastSeen  time.Time     `json:"last_seen,omitempty"`
+	Messages  int           `json:"messages"`
+	WatchTime time.Duration `json:"watch_time"`
+}
+
+type Strike struct {
+	Channel  string        `json:"channel"`
+	Reason   string        `json:"reason"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+}
+
+type Follow struct {
+	Channel    string `json:"channel"`
+	FollowedAt string `json:"followed_at"`
+}
+
+// Profile is everything known about the user
+type Profile struct {
+	Login          string          `json:"login"`
+	ID             string          `json:"id,omitempty"`
+	DisplayName    string          `json:"display_name,omitempty"`
+	CreatedAt      time.Time       `json:"created_at,omitempty"`
+	Channels       []Channel       `json:"channels"`
+	TotalMessages  int             `json:"total_messages"`
+	TotalWatchTime time.Duration   `json:"total_watch_time"`
+	Strikes        []Strike        `json:"strikes"`
+	Follows        []Follow        `json:"follows"`
+	Names          []identity.Name `json:"names,omitempty"`
+	LastMessage    *seen.Record    `json:"last_message,omitempty"`
+	Afk            []afk.Status    `json:"afk,omitempty"`
+}
+
+// Lookup collects the profile from helix, sqlite and redis.
+// Channels of the afk state are the ones where the user was seen
+func Lookup(conn redis.Conn, login string) (*Profile, error) {
+	p := &Profile{Login: strings.ToLower(login)}
+	user, err := terminal.GetUser(p.Login)
+	if err != nil {
+		// the profile is still useful without helix
+		terminal.Output.Log(err)
+	} else {
+		p.ID, p.DisplayName, p.CreatedAt = user.ID, user.DisplayName, user.CreatedAt
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := CreateTables(db); err != nil {
+		return nil, err
+	}
+	if err := p.loadChannels(db); err != nil {
+		return nil, err
+	}
+	if err := p.loadStrikes(db); err != nil {
+		return nil, err
+	}
+	if err := p.loadFollows(db); err != nil {
+		return nil, err
+	}
+	if _, p.Names, err = identity.History(p.Login); err != nil {
+		return nil, err
+	}
+	if p.LastMessage, _, err = seen.Lookup(conn, p.Login); err != nil {
+		return nil, err
+	}
+	for _, channel := range p.Channels {
+		status, err := afk.Get(conn, "#"+channel.Channel, p.Login)
+		if err != nil {
+			return nil, err
+		}
+		if status != nil {
+			p.Afk = append(p.Afk, *status)
+		}
+	}
+	return p, nil
+}
+
+func (p *Profile) loadChannels(db *sql.DB) error {
+	rows, err := db.Query(`SELECT s.Channel, s.MsgCount, s.WatchTime, u.FirstSeen, u.LastSeen FROM Stats s
+LEFT JOIN UserChannels u ON u.Channel=s.Channel AND u.Username=s.Username WHERE s.Username=$1 ORDER BY s.Channel;`, p.Login)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Channel
+		var watchTime int64
+		var first, last sql.NullTime
+		if err := rows.Scan(&c.Channel, &c.Messages, &watchTime, &first, &last); err != nil {
+			return err
+		}
+		c.WatchTime = time.Duration(watchTime)
+		c.FirstSeen, c.LastSeen = first.Time, last.Time
+		p.TotalMessages += c.Messages
+		p.TotalWatchTime += c.WatchTime
+		p.Channels = append(p.Channels, c)
+	}
+	return rows.Err()
+}
+
+func (p *Profile) loadStrikes(db *sql.DB) error {
+	rows, err := db.Query("SELECT Channel, Reason, Seconds, CreatedAt FROM Strikes WHERE Username=$1 ORDER BY CreatedAt DESC;", p.Login)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Strike
+		var seconds int64
+		if err := rows.Scan(&s.Channel, &s.Reason, &seconds, &s.Time); err != nil {
+			return err
+		}
+		s.Duration = time.Duration(seconds) * time.Second
+		p.Strikes = append(p.Strikes, s)
+	}
+	return rows.Err()
+}
+
+// follows are known only for users which were searched with find
+func (p *Profile) loadFollows(db *sql.DB) error {
+	rows, err := db.Query("SELECT ToName, FollowedAt FROM Followers WHERE FromName=$1 ORDER BY FollowedAt;", p.Login)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil
+		}
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f Follow
+		if err := rows.Scan(&f.Channel, &f.FollowedAt); err != nil {
+			return err
+		}
+		p.Follows = append(p.Follows, f)
+	}
+	return rows.Err()
+}
+
+func (p *Profile) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// Lines is the detailed text for the terminal
+func (p *Profile) Lines() []string {
+	lines := []string{p.Summary()}
+	for _, c := range p.Channels {
+		line := fmt.Sprintf("%s: messages: %d, watch time: %s", c.Channel, c.Messages, c.WatchTime.Truncate(time.Minute))
+		if !c.FirstSeen.IsZero() {
+			line += fmt.Sprintf(", first seen: %s, last seen: %s", c.FirstSeen.Format("2006-01-02"), c.LastSeen.Format("2006-01-02 15:04"))
+		}
+		lines = append(lines, line)
+	}
+	for _, s := range p.Strikes {
+		duration := "ban"
+		if s.Duration > 0 {
+			duration = s.Duration.String()
+		}
+		lines = append(lines, fmt.Sprintf("strike in %s at %s: %s (%s)", s.Channel, s.Time.Format("2006-01-02 15:04"), s.Reason, duration))
+	}
+	for _, f := range p.Follows {
+		lines = append(lines, fmt.Sprintf("follows %s since %s", f.Channel, f.FollowedAt))
+	}
+	if len(p.Names) > 1 {
+		names := make([]string, len(p.Names))
+		for i, name := range p.Names {
+			names[i] = fmt.Sprintf("%s (%s)", name.DisplayName, name.Since.Format("2006-01-02"))
+		}
+		lines = append(lines, "names: "+strings.Join(names, " -> "))
+	}
+	if p.LastMessage != nil {
+		lines = append(lines, fmt.Sprintf("last message in %s at %s: %s", p.LastMessage.Channel, p.LastMessage.Time.Format("2006-01-02 15:04"), p.LastMessage.Message))
+	}
+	for _, status := range p.Afk {
+		lines = append(lines, fmt.Sprintf("afk since %s: %s", status.Since.Format("2006-01-02 15:04"), status.Describe()))
+	}
+	return lines
+}
+
+// Summary is the short text for the chat
+func (p *Profile) Summary() string {
+	str := p.Login
+	if p.ID != "" {
+		str += fmt.Sprintf(" (id %s, created %s, %d days ago)", p.ID, p.CreatedAt.Format("2006-01-02"), int(time.Since(p.CreatedAt).Hours()/24))
+	}
+	if len(p.Names) > 1 {
+		var former []string
+		for _, name := range p.Names[:len(p.Names)-1] {
+			if name.Login != p.Login {
+				former = append(former, name.Login)
+			}
+		}
+		if len(former) > 0 {
+			str += ", formerly " + strings.Join(former, ", ")
+		}
+	}
+	str += fmt.Sprintf(": %d messages, %s watched in %d channels, %d strikes, %d follows",
+		p.TotalMessages, p.TotalWatchTime.Truncate(time.Minute), len(p.Channels), len(p.Strikes), len(p.Follows))
+	return str
+}
@@ -0,0 +1,247 @@
+package reminders
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"twitchStats/database"
+)
+
+// Limit of the active reminders created by one user in the channel
+const MaxPerUser = 10
+
+// Shortest period of the recurring reminder
+const MinEvery = time.Minute
+
+// Delay before the reminder which couldn't be sent is tried again
+const RetryDelay = time.Minute
+
+type Reminder struct {
+	ID      int64
+	Channel string
+	Author  string
+	Target  string
+	Message string
+	Due     time.Time
+	// period of the recurring reminder, 0 if it's sent once
+	Every   time.Duration
+	Created time.Time
+}
+
+func createTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Reminders(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Author TEXT NOT NULL, Target TEXT NOT NULL, Message TEXT NOT NULL, DueAt TIMESTAMP NOT NULL, Every INTEGER NOT NULL DEFAULT 0, CreatedAt TIMESTAMP NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS RemindersDue ON Reminders(DueAt);")
+	return err
+}
+
+// Add saves the reminder and sets its id
+func Add(r *Reminder) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Reminders WHERE Channel=$1 AND Author=$2;", r.Channel, r.Author).Scan(&count); err != nil {
+		return err
+	}
+	if count >= MaxPerUser {
+		return errors.New("too many reminders, cancel some of them")
+	}
+	r.Created = time.Now()
+	res, err := db.Exec("INSERT INTO Reminders(Channel, Author, Target, Message, DueAt, Every, CreatedAt) VALUES($1,$2,$3,$4,$5,$6,$7);",
+		r.Channel, r.Author, r.Target, r.Message, r.Due, int64(r.Every), r.Created)
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+func scan(rows *sql.Rows) ([]Reminder, error) {
+	defer rows.Close()
+	var reminders []Reminder
+	for rows.Next() {
+		var r Reminder
+		var every int64
+		if err := rows.Scan(&r.ID, &r.Channel, &r.Author, &r.Target, &r.Message, &r.Due, &every, &r.Created); err != nil {
+			return nil, err
+		}
+		r.Every = time.Duration(every)
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// List returns reminders which the user created or which are for the user, the nearest first
+func List(channel, username string) ([]Reminder, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT Id, Channel, Author, Target, Message, DueAt, Every, CreatedAt FROM Reminders WHERE Channel=$1 AND (Author=$2 OR Target=$2) ORDER BY DueAt;", channel, username)
+	if err != nil {
+		return nil, err
+	}
+	return scan(rows)
+}
+
+// Cancel deletes the reminder, only its author or target can do it
+func Cancel(channel, username string, id int64) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	res, err := db.Exec("DELETE FROM Reminders WHERE Id=$1 AND Channel=$2 AND (Author=$3 OR Target=$3);", id, channel, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("reminder wasn't found")
+	}
+	return nil
+}
+
+// Due returns reminders which should be sent by now, including the ones missed while the server was down
+func Due(db *sql.DB, now time.Time) ([]Reminder, error) {
+	rows, err := db.Query("SELECT Id, Channel, Author, Target, Message, DueAt, Every, CreatedAt FROM Reminders WHERE DueAt<=$1 ORDER BY DueAt;", now)
+	if err != nil {
+		return nil, err
+	}
+	return scan(rows)
+}
+
+// Done deletes the sent reminder or moves the recurring one to the next time after now,
+// so the missed repeats are sent only once
+func Done(db *sql.DB, r *Reminder, now time.Time) error {
+	if r.Every <= 0 {
+		_, err := db.Exec("DELETE FROM Reminders WHERE Id=$1;", r.ID)
+		return err
+	}
+	next := r.Due
+	for !next.After(now) {
+		next = next.Add(r.Every)
+	}
+	_, err := db.Exec("UPDATE Reminders SET DueAt=$1 WHERE Id=$2;", next, r.ID)
+	return err
+}
+
+// Postpone moves the reminder which couldn't be sent, so it doesn't hold back the others
+func Postpone(db *sql.DB, r *Reminder, until time.Time) error {
+	_, err := db.Exec("UPDATE Reminders SET DueAt=$1 WHERE Id=$2;", until, r.ID)
+	return err
+}
+
+// Run sends due reminders every second, it returns on database errors.
+// Reminders which couldn't be sent are retried after RetryDelay
+func Run(send func(r *Reminder) error) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		due, err := Due(db, now)
+		if err != nil {
+			return err
+		}
+		for i := range due {
+			if err := send(&due[i]); err != nil {
+				if err := Postpone(db, &due[i], now.Add(RetryDelay)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := Done(db, &due[i], now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Parse reads the time of the reminder from the front of the params:
+// <duration>, at <15:04>, at <2006-01-02> <15:04>, each of them optionally followed by every <duration>,
+// or every <duration> alone, which starts after one period
+func Parse(params []string, now time.Time) (time.Time, time.Duration, []string, error) {
+	var due time.Time
+	var every time.Duration
+	if len(params) == 0 {
+		return due, 0, nil, errors.New("need time")
+	}
+	switch params[0] {
+	case "every":
+	case "at":
+		if len(params) < 2 {
+			return due, 0, nil, errors.New("need time after at")
+		}
+		var err error
+		if due, params, err = parseAt(params[1:], now); err != nil {
+			return due, 0, nil, err
+		}
+	default:
+		d, err := time.ParseDuration(params[0])
+		if err != nil {
+			return due, 0, nil, errors.New("wrong time format, use e.g. 10m, 1h30m or at 21:00")
+		}
+		if d <= 0 {
+			return due, 0, nil, errors.New("time must be positive")
+		}
+		due = now.Add(d)
+		params = params[1:]
+	}
+	if len(params) > 0 && params[0] == "every" {
+		if len(params) < 2 {
+			return due, 0, nil, errors.New("need period after every")
+		}
+		d, err := time.ParseDuration(params[1])
+		if err != nil {
+			return due, 0, nil, errors.New("wrong period format, use e.g. 30m or 24h")
+		}
+		if d < MinEvery {
+			return due, 0, nil, errors.New("period must be at least " + MinEvery.String())
+		}
+		every = d
+		params = params[2:]
+		if due.IsZero() {
+			due = now.Add(every)
+		}
+	}
+	return due, every, params, nil
+}
+
+func parseAt(params []string, now time.Time) (time.Time, []string, error) {
+	if t, err := time.ParseInLocation("15:04", params[0], now.Location()); err == nil {
+		due := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 1)
+		}
+		return due, params[1:], nil
+	}
+	if len(params) > 1 {
+		if due, err := time.ParseInLocation("2006-01-02 15:04", params[0]+" "+params[1], now.Location()); err == nil {
+			if !due.After(now) {
+				return due, nil, errors.New("time is in the past")
+			}
+			return due, params[2:], nil
+		}
+	}
+	return time.Time{}, nil, errors.New("wrong time format, use e.g. at 21:00 or at 2006-01-02 21:00")
+}
+
+// Text is the message sent to the chat
+func (r *Reminder) Text() string {
+	text := "@" + r.Target + " " + r.Message
+	if r.Author != r.Target {
+		text += " (from " + r.Author + ")"
+	}
+	return strings.TrimSpace(text)
+}
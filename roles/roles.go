@@ -0,0 +1,309 @@
+package roles
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"twitchStats/database"
+)
+
+// Levels of access. There are gaps between them, so new levels can be defined in between
+const (
+	Viewer      = 0
+	Subscriber  = 10
+	VIP         = 20
+	Middle      = 30
+	Moderator   = 40
+	Broadcaster = 50
+	Top         = 50
+)
+
+// Roles which are derived from the twitch badges
+const (
+	RoleBroadcaster = "broadcaster"
+	RoleModerator   = "moderator"
+	RoleVIP         = "vip"
+	RoleSubscriber  = "subscriber"
+)
+
+// overrides with this channel apply to every channel
+const AllChannels = "*"
+
+var levels = map[string]int{
+	"viewer":        Viewer,
+	"low":           Viewer,
+	RoleSubscriber:  Subscriber,
+	RoleVIP:         VIP,
+	"middle":        Middle,
+	RoleModerator:   Moderator,
+	RoleBroadcaster: Broadcaster,
+	"top":           Top,
+}
+
+var names = map[int]string{
+	Viewer:      "viewer",
+	Subscriber:  RoleSubscriber,
+	VIP:         RoleVIP,
+	Middle:      "middle",
+	Moderator:   RoleModerator,
+	Broadcaster: RoleBroadcaster,
+}
+
+// Badges from the badges tag, e.g. broadcaster/1,subscriber/12
+type Badges map[string]string
+
+func ParseBadges(tag string) Badges {
+	badges := make(Badges)
+	if tag == "" {
+		return badges
+	}
+	for _, badge := range strings.Split(tag, ",") {
+		split := strings.SplitN(badge, "/", 2)
+		if len(split) == 2 {
+			badges[split[0]] = split[1]
+		} else {
+			badges[split[0]] = ""
+		}
+	}
+	return badges
+}
+
+// FromBadges returns the roles of the user, sorted from the highest to the lowest
+func FromBadges(badges Badges) []string {
+	var roles []string
+	for _, role := range []string{RoleBroadcaster, RoleModerator, RoleVIP} {
+		if _, ok := badges[role]; ok {
+			roles = append(roles, role)
+		}
+	}
+	// founders are subscribers as well
+	_, sub := badges["subscriber"]
+	_, founder := badges["founder"]
+	if sub || founder {
+		roles = append(roles, RoleSubscriber)
+	}
+	return roles
+}
+
+// SubMonths returns the number of months from the badge-info tag, e.g. subscriber/14
+func SubMonths(badgeInfo string) int {
+	info := ParseBadges(badgeInfo)
+	for _, badge := range []string{"subscriber", "founder"} {
+		if months, err := strconv.Atoi(info[badge]); err == nil {
+			return months
+		}
+	}
+	return 0
+}
+
+// RoleLevel returns the highest level of the given roles
+func RoleLevel(roles []string) int {
+	level := Viewer
+	for _, role := range roles {
+		if l, ok := levels[role]; ok && l > level {
+			level = l
+		}
+	}
+	return level
+}
+
+// Name of the level, for levels without a name the number is returned
+func Name(level int) string {
+	if name, ok := names[level]; ok {
+		return name
+	}
+	return strconv.Itoa(level)
+}
+
+// ParseLevel accepts names of the levels (top, middle, moderator, ...), custom levels of the channel and numbers
+func ParseLevel(channel, name string) (int, error) {
+	name = strings.ToLower(name)
+	if level, ok := levels[name]; ok {
+		return level, nil
+	}
+	if level, err := strconv.Atoi(name); err == nil {
+		return level, nil
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return 0, err
+	}
+	var level int
+	err := db.QueryRow("SELECT Level FROM RoleLevels WHERE Name=$1 AND (Channel=$2 OR Channel=$3) ORDER BY Channel=$3 LIMIT 1;", name, channel, AllChannels).Scan(&level)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("unknown level: " + name)
+	}
+	return level, err
+}
+
+// DefineLevel adds a custom named level to the channel
+func DefineLevel(channel, name string, level int) error {
+	name = strings.ToLower(name)
+	if _, ok := levels[name]; ok {
+		return errors.New(name + " is a built-in level")
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO RoleLevels(Channel, Name, Level) VALUES($1,$2,$3);", channel, name, level)
+	return err
+}
+
+func createTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS RoleOverrides(Channel TEXT NOT NULL, Username TEXT NOT NULL, Level INTEGER NOT NULL, UNIQUE (Channel, Username) ON CONFLICT REPLACE);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS RoleLevels(Channel TEXT NOT NULL, Name TEXT NOT NULL, Level INTEGER NOT NULL, UNIQUE (Channel, Name) ON CONFLICT REPLACE);")
+	return err
+}
+
+// Resolver combines the roles from the badges with the overrides of the channel
+type Resolver struct {
+	sync.RWMutex
+	Channel   string
+	overrides map[string]int
+}
+
+func NewResolver(channel string) (*Resolver, error) {
+	r := &Resolver{Channel: channel}
+	if err := importAuthority(); err != nil {
+		return nil, err
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload overrides from the database, overrides of the channel take precedence over global ones
+func (r *Resolver) Reload() error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT Username, Level FROM RoleOverrides WHERE Channel=$1 OR Channel=$2 ORDER BY Channel=$1;", r.Channel, AllChannels)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	overrides := make(map[string]int)
+	for rows.Next() {
+		var username string
+		var level int
+		if err := rows.Scan(&username, &level); err != nil {
+			return err
+		}
+		overrides[username] = level
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	r.Lock()
+	r.overrides = overrides
+	r.Unlock()
+	return nil
+}
+
+// Level of the user, explicit override replaces the level from the roles
+func (r *Resolver) Level(username string, roles []string) int {
+	r.RLock()
+	defer r.RUnlock()
+	if level, ok := r.overrides[username]; ok {
+		return level
+	}
+	return RoleLevel(roles)
+}
+
+func (r *Resolver) Override(username string) (int, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	level, ok := r.overrides[username]
+	return level, ok
+}
+
+func (r *Resolver) SetOverride(username string, level int) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO RoleOverrides(Channel, Username, Level) VALUES($1,$2,$3);", r.Channel, username, level)
+	if err != nil {
+		return err
+	}
+	return r.Reload()
+}
+
+func (r *Resolver) RemoveOverride(username string) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM RoleOverrides WHERE Channel=$1 AND Username=$2;", r.Channel, username)
+	if err != nil {
+		return err
+	}
+	return r.Reload()
+}
+
+// Overrides of the channel sorted by username
+func (r *Resolver) Overrides() []string {
+	r.RLock()
+	defer r.RUnlock()
+	var list []string
+	for username, level := range r.overrides {
+		list = append(list, username+": "+Name(level))
+	}
+	sort.Strings(list)
+	return list
+}
+
+// authority.txt is imported once as global overrides
+func importAuthority() error {
+	file, err := ioutil.ReadFile("authority.txt")
+	if err != nil {
+		return nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal(file, &m); err != nil {
+		return err
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := createTables(db); err != nil {
+		return err
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM RoleOverrides WHERE Channel=$1;", AllChannels).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for username, name := range m {
+		level, ok := levels[name]
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO RoleOverrides(Channel, Username, Level) VALUES($1,$2,$3);", AllChannels, username, level); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,169 @@
+package seen
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// hash of the last messages, the field is the username
+	recordsKey  = "seen"
+	optOutKey   = "seen:optout"
+	channelsKey = "seen:channels"
+)
+
+// Record is the last message of the user in any channel
+type Record struct {
+	Username string    `json:"username"`
+	Channel  string    `json:"channel"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+func presentKey(channel string) string {
+	return "seen:present:" + channel
+}
+
+// Update saves the message as the last one of the user, unless the user opted out
+func Update(conn redis.Conn, channel, username, message string, t time.Time) error {
+	username = strings.ToLower(username)
+	optedOut, err := redis.Bool(conn.Do("SISMEMBER", optOutKey, username))
+	if err != nil || optedOut {
+		return err
+	}
+	data, err := json.Marshal(Record{Username: username, Channel: channel, Message: message, Time: t})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", recordsKey, username, data)
+	return err
+}
+
+// SetPresent replaces the chatters of the channel
+func SetPresent(conn redis.Conn, channel string, users map[string]struct{}) error {
+	key := presentKey(channel)
+	conn.Send("MULTI")
+	conn.Send("SADD", channelsKey, channel)
+	conn.Send("DEL", key)
+	if len(users) > 0 {
+		args := redis.Args{}.Add(key)
+		for name := range users {
+			args = args.Add(name)
+		}
+		conn.Send("SADD", args...)
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Lookup returns the last message of the user and channels where the user is in chatters now.
+// The record is nil if the user wasn't seen or opted out
+func Lookup(conn redis.Conn, username string) (*Record, []string, error) {
+	username = strings.ToLower(username)
+	optedOut, err := redis.Bool(conn.Do("SISMEMBER", optOutKey, username))
+	if err != nil || optedOut {
+		return nil, nil, err
+	}
+	var record *Record
+	data, err := redis.Bytes(conn.Do("HGET", recordsKey, username))
+	if err != nil && err != redis.ErrNil {
+		return nil, nil, err
+	}
+	if err == nil {
+		record = &Record{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, nil, err
+		}
+	}
+	channels, err := redis.Strings(conn.Do("SMEMBERS", channelsKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	var present []string
+	for _, channel := range channels {
+		in, err := redis.Bool(conn.Do("SISMEMBER", presentKey(channel), username))
+		if err != nil {
+			return nil, nil, err
+		}
+		if in {
+			present = append(present, channel)
+		}
+	}
+	return record, present, nil
+}
+
+// OptOut removes the record of the user and stops tracking
+func OptOut(conn redis.Conn, username string) error {
+	username = strings.ToLower(username)
+	conn.Send("MULTI")
+	conn.Send("SADD", optOutKey, username)
+	conn.Send("HDEL", recordsKey, username)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+func OptIn(conn redis.Conn, username string) error {
+	_, err := conn.Do("SREM", optOutKey, strings.ToLower(username))
+	return err
+}
+
+// Rename moves the record and the opt-out of the user to the new login, the newer record is kept
+func Rename(conn redis.Conn, oldLogin, newLogin string) error {
+	oldLogin, newLogin = strings.ToLower(oldLogin), strings.ToLower(newLogin)
+	optedOut, err := redis.Bool(conn.Do("SISMEMBER", optOutKey, oldLogin))
+	if err != nil {
+		return err
+	}
+	if optedOut {
+		conn.Send("MULTI")
+		conn.Send("SREM", optOutKey, oldLogin)
+		conn.Send("SADD", optOutKey, newLogin)
+		conn.Send("HDEL", recordsKey, newLogin)
+		_, err := conn.Do("EXEC")
+		return err
+	}
+	data, err := redis.Bytes(conn.Do("HGET", recordsKey, oldLogin))
+	if err == redis.ErrNil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	newOptedOut, err := redis.Bool(conn.Do("SISMEMBER", optOutKey, newLogin))
+	if err != nil {
+		return err
+	}
+	var current Record
+	if !newOptedOut {
+		data, err := redis.Bytes(conn.Do("HGET", recordsKey, newLogin))
+		switch err {
+		case nil:
+			if err := json.Unmarshal(data, &current); err != nil {
+				return err
+			}
+		case redis.ErrNil:
+		default:
+			return err
+		}
+	}
+	conn.Send("MULTI")
+	conn.Send("HDEL", recordsKey, oldLogin)
+	// the new login may already have opted out or chatted after the rename
+	if !newOptedOut && record.Time.After(current.Time) {
+		record.Username = newLogin
+		if data, err = json.Marshal(record); err != nil {
+			conn.Do("DISCARD")
+			return err
+		}
+		conn.Send("HSET", recordsKey, newLogin, data)
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
@@ -0,0 +1,55 @@
+package songqueue
+
+import (
+	"database/sql"
+	"time"
+	"twitchStats/database"
+)
+
+// HistoryEntry is the track from the history of the channel
+type HistoryEntry struct {
+	URI      string
+	Name     string
+	Username string
+	PlayedAt time.Time
+}
+
+func createHistoryTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS SongHistory(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, URI TEXT NOT NULL, Name TEXT NOT NULL, Username TEXT NOT NULL DEFAULT '', PlayedAt TIMESTAMP NOT NULL);")
+	return err
+}
+
+// AddHistory saves the track which started playing, username is empty if nobody requested it
+func AddHistory(channel string, played HistoryEntry) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createHistoryTable(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO SongHistory(Channel, URI, Name, Username, PlayedAt) VALUES($1,$2,$3,$4,$5);",
+		channel, played.URI, played.Name, played.Username, played.PlayedAt)
+	return err
+}
+
+// History returns the last played tracks, the latest go first
+func History(channel string, limit int) ([]HistoryEntry, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createHistoryTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT URI, Name, Username, PlayedAt FROM SongHistory WHERE Channel=$1 ORDER BY Id DESC LIMIT $2;", channel, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []HistoryEntry
+	for rows.Next() {
+		var played HistoryEntry
+		if err := rows.Scan(&played.URI, &played.Name, &played.Username, &played.PlayedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, played)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,304 @@
+package songqueue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"twitchStats/database"
+	"twitchStats/roles"
+)
+
+// Rules of the song requests in the channel
+type Rules struct {
+	MaxLength     time.Duration
+	UserLimit     int
+	UserCooldown  time.Duration
+	AllowExplicit bool
+	MinLevel      int
+	SubOnly       bool
+}
+
+var DefaultRules = Rules{
+	MaxLength:     10 * time.Minute,
+	UserLimit:     3,
+	AllowExplicit: true,
+}
+
+func (rules *Rules) String() string {
+	return fmt.Sprintf("maxlength: %s, limit: %d, cooldown: %s, explicit: %t, level: %d, subonly: %t",
+		rules.MaxLength, rules.UserLimit, rules.UserCooldown, rules.AllowExplicit, rules.MinLevel, rules.SubOnly)
+}
+
+// Kinds of the bans
+const (
+	BanArtist  = "artist"
+	BanTrack   = "track"
+	BanKeyword = "keyword"
+)
+
+type Ban struct {
+	Kind  string
+	Value string
+}
+
+// Candidate is the track which is going to be requested
+type Candidate struct {
+	URI      string
+	Name     string
+	Artists  []string
+	Duration time.Duration
+	Explicit bool
+}
+
+type Requester struct {
+	Username string
+	Level    int
+	Roles    []string
+}
+
+// Rejection describes why the song can't be requested
+type Rejection struct {
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+func reject(format string, a ...interface{}) error {
+	return &Rejection{Reason: fmt.Sprintf(format, a...)}
+}
+
+func createRulesTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS SongRules(Channel TEXT PRIMARY KEY ON CONFLICT REPLACE, MaxLength INTEGER NOT NULL, UserLimit INTEGER NOT NULL, UserCooldown INTEGER NOT NULL, AllowExplicit INTEGER NOT NULL, MinLevel INTEGER NOT NULL, SubOnly INTEGER NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS SongBans(Channel TEXT NOT NULL, Kind TEXT NOT NULL, Value TEXT NOT NULL, UNIQUE(Channel, Kind, Value) ON CONFLICT IGNORE);")
+	return err
+}
+
+func LoadRules(channel string) (Rules, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createRulesTables(db); err != nil {
+		return Rules{}, err
+	}
+	rules := DefaultRules
+	var maxLength, cooldown int64
+	err := db.QueryRow("SELECT MaxLength, UserLimit, UserCooldown, AllowExplicit, MinLevel, SubOnly FROM SongRules WHERE Channel=$1;", channel).
+		Scan(&maxLength, &rules.UserLimit, &cooldown, &rules.AllowExplicit, &rules.MinLevel, &rules.SubOnly)
+	if err == sql.ErrNoRows {
+		return rules, nil
+	}
+	if err != nil {
+		return Rules{}, err
+	}
+	rules.MaxLength = time.Duration(maxLength)
+	rules.UserCooldown = time.Duration(cooldown)
+	return rules, nil
+}
+
+func SaveRules(channel string, rules Rules) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createRulesTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO SongRules(Channel, MaxLength, UserLimit, UserCooldown, AllowExplicit, MinLevel, SubOnly) VALUES($1,$2,$3,$4,$5,$6,$7);",
+		channel, int64(rules.MaxLength), rules.UserLimit, int64(rules.UserCooldown), rules.AllowExplicit, rules.MinLevel, rules.SubOnly)
+	return err
+}
+
+// SetRule changes one rule of the channel: maxlength, limit, cooldown, explicit, level or subonly
+func SetRule(channel, name, value string) (Rules, error) {
+	rules, err := LoadRules(channel)
+	if err != nil {
+		return Rules{}, err
+	}
+	switch name {
+	case "maxlength":
+		rules.MaxLength, err = parseDuration(value)
+	case "limit":
+		rules.UserLimit, err = strconv.Atoi(value)
+	case "cooldown":
+		rules.UserCooldown, err = parseDuration(value)
+	case "explicit":
+		rules.AllowExplicit, err = strconv.ParseBool(value)
+	case "level":
+		rules.MinLevel, err = roles.ParseLevel(channel, value)
+	case "subonly":
+		rules.SubOnly, err = strconv.ParseBool(value)
+	default:
+		return Rules{}, errors.New("unknown rule " + name)
+	}
+	if err != nil {
+		return Rules{}, err
+	}
+	return rules, SaveRules(channel, rules)
+}
+
+// plain numbers are seconds
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func AddBan(channel, kind, value string) error {
+	switch kind {
+	case BanArtist, BanTrack, BanKeyword:
+	default:
+		return errors.New("kind must be artist, track or keyword")
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := createRulesTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO SongBans(Channel, Kind, Value) VALUES($1,$2,$3);", channel, kind, strings.ToLower(value))
+	return err
+}
+
+func RemoveBan(channel, kind, value string) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createRulesTables(db); err != nil {
+		return err
+	}
+	res, err := db.Exec("DELETE FROM SongBans WHERE Channel=$1 AND Kind=$2 AND Value=$3;", channel, kind, strings.ToLower(value))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New(kind + " " + value + " isn't banned")
+	}
+	return nil
+}
+
+func Bans(channel string) ([]Ban, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createRulesTables(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT Kind, Value FROM SongBans WHERE Channel=$1 ORDER BY Kind, Value;", channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bans []Ban
+	for rows.Next() {
+		var ban Ban
+		if err := rows.Scan(&ban.Kind, &ban.Value); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (ban *Ban) matches(candidate *Candidate) bool {
+	switch ban.Kind {
+	case BanArtist:
+		for _, artist := range candidate.Artists {
+			if strings.ToLower(artist) == ban.Value {
+				return true
+			}
+		}
+	case BanTrack:
+		return strings.ToLower(candidate.URI) == ban.Value || strings.ToLower(candidate.Name) == ban.Value
+	case BanKeyword:
+		if strings.Contains(strings.ToLower(candidate.Name), ban.Value) {
+			return true
+		}
+		for _, artist := range candidate.Artists {
+			if strings.Contains(strings.ToLower(artist), ban.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks the request against the rules of the channel, *Rejection is returned with the reason
+func Validate(channel string, candidate *Candidate, requester *Requester) error {
+	rules, err := LoadRules(channel)
+	if err != nil {
+		return err
+	}
+	if requester.Level < rules.MinLevel {
+		return reject("song requests are available from level %s", roles.Name(rules.MinLevel))
+	}
+	if rules.SubOnly && requester.Level < roles.Moderator && !hasRole(requester.Roles, roles.RoleSubscriber) {
+		return reject("song requests are for subscribers only")
+	}
+	if rules.MaxLength > 0 && candidate.Duration > rules.MaxLength {
+		return reject("track is longer than %s", rules.MaxLength)
+	}
+	if candidate.Explicit && !rules.AllowExplicit {
+		return reject("explicit tracks are not allowed")
+	}
+	bans, err := Bans(channel)
+	if err != nil {
+		return err
+	}
+	for i := range bans {
+		if bans[i].matches(candidate) {
+			return reject("%s %s is banned", bans[i].Kind, bans[i].Value)
+		}
+	}
+	entries, err := Pending(channel)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.URI == candidate.URI {
+			return reject("%s is already in the queue", entry.Name)
+		}
+		if entry.Username == requester.Username {
+			count++
+		}
+	}
+	if rules.UserLimit > 0 && count >= rules.UserLimit {
+		return reject("you already have %d songs in the queue", count)
+	}
+	if rules.UserCooldown > 0 {
+		last, err := lastRequest(channel, requester.Username)
+		if err != nil {
+			return err
+		}
+		if left := rules.UserCooldown - time.Since(last); left > 0 {
+			return reject("you can request the next song in %s", left.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+func hasRole(userRoles []string, role string) bool {
+	for _, r := range userRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func lastRequest(channel, username string) (time.Time, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	err := db.QueryRow("SELECT RequestedAt FROM SongQueue WHERE Channel=$1 AND Username=$2 AND Status!=$3 ORDER BY Id DESC LIMIT 1;", channel, username, Removed).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return last, err
+}
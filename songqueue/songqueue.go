@@ -0,0 +1,402 @@
+package songqueue
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"twitchStats/database"
+)
+
+// Statuses of the requested songs
+const (
+	Queued  = "queued"
+	Playing = "playing"
+	Played  = "played"
+	Skipped = "skipped"
+	Removed = "removed"
+)
+
+type Entry struct {
+	ID          int64
+	Channel     string
+	URI         string
+	Name        string
+	Username    string
+	Duration    time.Duration
+	RequestedAt time.Time
+	Status      string
+	// snapshot of the playlist in which the entry was seen last time
+	SnapshotID string
+}
+
+type Track struct {
+	URI      string
+	Duration time.Duration
+}
+
+// State of the playlist and the playback
+type State struct {
+	SnapshotID      string
+	Tracks          []Track
+	CurrentURI      string
+	CurrentName     string
+	CurrentDuration time.Duration
+	Progress        time.Duration
+	Playing         bool
+	Checked         time.Time
+}
+
+// Item is the pending entry with its place in the playlist
+type Item struct {
+	Entry
+	// index in the playlist, -1 if unknown
+	Position int
+	// time until the song starts, -1 if unknown
+	ETA time.Duration
+}
+
+func createTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS SongQueue(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, URI TEXT NOT NULL, Name TEXT NOT NULL, Username TEXT NOT NULL, Duration INTEGER NOT NULL, RequestedAt TIMESTAMP NOT NULL, Status TEXT NOT NULL, SnapshotID TEXT NOT NULL DEFAULT '');")
+	return err
+}
+
+func Add(entry Entry) (int64, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return 0, err
+	}
+	if entry.Status == "" {
+		entry.Status = Queued
+	}
+	if entry.RequestedAt.IsZero() {
+		entry.RequestedAt = time.Now()
+	}
+	res, err := db.Exec("INSERT INTO SongQueue(Channel, URI, Name, Username, Duration, RequestedAt, Status, SnapshotID) VALUES($1,$2,$3,$4,$5,$6,$7,$8);",
+		entry.Channel, entry.URI, entry.Name, entry.Username, int64(entry.Duration), entry.RequestedAt, entry.Status, entry.SnapshotID)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// Pending returns queued and playing entries in the order of requests
+func Pending(channel string) ([]Entry, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+	return pending(db, channel)
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func pending(db queryer, channel string) ([]Entry, error) {
+	rows, err := db.Query("SELECT Id, Channel, URI, Name, Username, Duration, RequestedAt, Status, SnapshotID FROM SongQueue WHERE Channel=$1 AND Status IN ($2,$3) ORDER BY Id;",
+		channel, Queued, Playing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var duration int64
+		if err := rows.Scan(&entry.ID, &entry.Channel, &entry.URI, &entry.Name, &entry.Username, &duration, &entry.RequestedAt, &entry.Status, &entry.SnapshotID); err != nil {
+			return nil, err
+		}
+		entry.Duration = time.Duration(duration)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func SetStatus(id int64, status string) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE SongQueue SET Status=$1 WHERE Id=$2;", status, id)
+	return err
+}
+
+// Reconcile statuses of the pending entries with the real playlist and playback
+func Reconcile(channel string, state *State) error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	entries, err := pending(tx, channel)
+	if err != nil {
+		return err
+	}
+	// entries removed from the playlist by hand
+	inPlaylist := make(map[string]int)
+	for _, track := range state.Tracks {
+		inPlaylist[track.URI]++
+	}
+	// only spotify requests are in the playlist, others are played from the queue by external players
+	synced := entries[:0]
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.URI, "spotify:") {
+			synced = append(synced, entry)
+		}
+	}
+	entries = synced
+	statuses := make([]string, len(entries))
+	for i := range entries {
+		statuses[i] = entries[i].Status
+		if inPlaylist[entries[i].URI] == 0 {
+			statuses[i] = Removed
+			continue
+		}
+		inPlaylist[entries[i].URI]--
+	}
+	current := -1
+	if state.CurrentURI != "" {
+		for i := range entries {
+			if statuses[i] != Removed && entries[i].URI == state.CurrentURI {
+				current = i
+				break
+			}
+		}
+		for i := range entries {
+			if statuses[i] == Removed {
+				continue
+			}
+			switch {
+			case i == current:
+				statuses[i] = Playing
+			case current == -1 || i < current:
+				// playback went past the entry
+				if statuses[i] == Playing {
+					statuses[i] = Played
+				} else if current != -1 {
+					statuses[i] = Skipped
+				}
+			case statuses[i] == Playing:
+				statuses[i] = Queued
+			}
+		}
+	}
+	for i := range entries {
+		if statuses[i] == entries[i].Status && entries[i].SnapshotID == state.SnapshotID {
+			continue
+		}
+		_, err := tx.Exec("UPDATE SongQueue SET Status=$1, SnapshotID=$2 WHERE Id=$3;", statuses[i], state.SnapshotID, entries[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Report is sent by the external player which plays youtube and local requests from the queue.
+// Playing marks the previous external request as played, spotify requests are reconciled with the playback instead
+func Report(channel, uri, status string) error {
+	if strings.HasPrefix(uri, "spotify:") {
+		return errors.New("spotify requests are tracked by the playback")
+	}
+	if status != Playing && status != Played && status != Skipped {
+		return errors.New("status must be playing, played or skipped")
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	entries, err := pending(tx, channel)
+	if err != nil {
+		return err
+	}
+	var entry *Entry
+	for i := range entries {
+		if entries[i].URI != uri {
+			continue
+		}
+		// the playing one is reported first, then the earliest request
+		if entry == nil || entries[i].Status == Playing && entry.Status != Playing {
+			entry = &entries[i]
+		}
+	}
+	if entry == nil {
+		return errors.New(uri + " isn't in the queue")
+	}
+	if status == Playing {
+		for i := range entries {
+			if entries[i].Status == Playing && entries[i].ID != entry.ID && !strings.HasPrefix(entries[i].URI, "spotify:") {
+				if _, err := tx.Exec("UPDATE SongQueue SET Status=$1 WHERE Id=$2;", Played, entries[i].ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if _, err := tx.Exec("UPDATE SongQueue SET Status=$1 WHERE Id=$2;", status, entry.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if status != Playing || entry.Status == Playing {
+		return nil
+	}
+	return AddHistory(channel, HistoryEntry{URI: entry.URI, Name: entry.Name, Username: entry.Username, PlayedAt: time.Now()})
+}
+
+// Items finds the pending entries in the playlist and calculates when they will be played
+func Items(entries []Entry, state *State) []Item {
+	items := make([]Item, len(entries))
+	// requests are appended to the end of the playlist, so they are matched from the end
+	last := len(state.Tracks)
+	for i := len(entries) - 1; i >= 0; i-- {
+		items[i] = Item{Entry: entries[i], Position: -1, ETA: -1}
+		for j := last - 1; j >= 0; j-- {
+			if state.Tracks[j].URI == entries[i].URI {
+				items[i].Position = j
+				last = j
+				break
+			}
+		}
+	}
+	current := -1
+	for i := range items {
+		if items[i].Status == Playing {
+			current = items[i].Position
+			items[i].ETA = 0
+		}
+	}
+	if current == -1 {
+		for i, track := range state.Tracks {
+			if track.URI == state.CurrentURI {
+				current = i
+				break
+			}
+		}
+	}
+	if current == -1 {
+		return items
+	}
+	left := state.CurrentDuration - state.Progress
+	if state.Playing {
+		left -= time.Since(state.Checked)
+	}
+	if left < 0 {
+		left = 0
+	}
+	for i := range items {
+		if items[i].Position <= current {
+			continue
+		}
+		eta := left
+		for _, track := range state.Tracks[current+1 : items[i].Position] {
+			eta += track.Duration
+		}
+		items[i].ETA = eta
+	}
+	return items
+}
+
+// Queue keeps the last known state of the channel playback
+type Queue struct {
+	Channel string
+
+	mu    sync.RWMutex
+	state State
+}
+
+func New(channel string) *Queue {
+	return &Queue{Channel: channel}
+}
+
+// Update reconciles the queue with the new state
+func (q *Queue) Update(state State) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := Reconcile(q.Channel, &state); err != nil {
+		return err
+	}
+	changed := state.CurrentURI != "" && state.CurrentURI != q.state.CurrentURI
+	q.state = state
+	if !changed {
+		return nil
+	}
+	played := HistoryEntry{URI: state.CurrentURI, Name: state.CurrentName, PlayedAt: state.Checked}
+	if entry, err := q.playing(); err == nil && entry != nil && entry.URI == state.CurrentURI {
+		played.Username = entry.Username
+	}
+	return AddHistory(q.Channel, played)
+}
+
+// State returns the last known state of the playback
+func (q *Queue) State() State {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.state
+}
+
+func (q *Queue) playing() (*Entry, error) {
+	entries, err := Pending(q.Channel)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].Status == Playing {
+			return &entries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Skipped marks the playing request as skipped
+func (q *Queue) Skipped(uri string) error {
+	entry, err := q.playing()
+	if err != nil || entry == nil || entry.URI != uri {
+		return err
+	}
+	return SetStatus(entry.ID, Skipped)
+}
+
+// Upcoming returns pending songs with their positions and ETA
+func (q *Queue) Upcoming() ([]Item, error) {
+	entries, err := Pending(q.Channel)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return Items(entries, &q.state), nil
+}
+
+// Find the last queued song of the user by its name, any user matches if username is empty
+func (q *Queue) Find(username, name string) (Item, error) {
+	items, err := q.Upcoming()
+	if err != nil {
+		return Item{}, err
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Status != Queued || username != "" && items[i].Username != username {
+			continue
+		}
+		if strings.EqualFold(items[i].Name, name) || strings.Contains(strings.ToLower(items[i].Name), strings.ToLower(name)) {
+			return items[i], nil
+		}
+	}
+	return Item{}, errors.New("track wasn't found in the queue")
+}
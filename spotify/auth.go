@@ -0,0 +1,153 @@
+package spotify
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is refreshed this long before it expires
+const refreshMargin = 5 * time.Minute
+
+type Auth struct {
+	Auth         string    `json:"auth"`
+	Refresh      string    `json:"refresh"`
+	Time         time.Time `json:"time"`
+	Expired      int       `json:"expired"`
+	ClientId     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+	// default playlist and playlists of the channels
+	Playlist  string            `json:"playlist,omitempty"`
+	Playlists map[string]string `json:"playlists,omitempty"`
+}
+
+type Refresh struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenStore keeps the token in memory and saves it to the file after refresh.
+// It's safe for concurrent use
+type TokenStore struct {
+	// AccountsURL is https://accounts.spotify.com if empty
+	AccountsURL string
+	HTTPClient  *http.Client
+
+	mu     sync.Mutex
+	path   string
+	auth   Auth
+	loaded bool
+}
+
+func NewTokenStore(path string) *TokenStore {
+	return &TokenStore{path: path}
+}
+
+// load must be called with the lock
+func (s *TokenStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	file, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(file, &s.auth); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save must be called with the lock
+func (s *TokenStore) save() error {
+	w, err := json.MarshalIndent(s.auth, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, w, 0600)
+}
+
+// Token returns the access token, it's refreshed if it expires soon
+func (s *TokenStore) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return "", err
+	}
+	expires := s.auth.Time.Add(time.Duration(s.auth.Expired) * time.Second)
+	if time.Until(expires) < refreshMargin {
+		if err := s.refresh(); err != nil {
+			return "", err
+		}
+	}
+	return s.auth.Auth, nil
+}
+
+// Invalidate makes the next Token call refresh the token, e.g. after 401
+func (s *TokenStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth.Expired = 0
+}
+
+// refresh must be called with the lock
+func (s *TokenStore) refresh() error {
+	accountsURL := s.AccountsURL
+	if accountsURL == "" {
+		accountsURL = "https://accounts.spotify.com"
+	}
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {s.auth.Refresh}}
+	req, _ := http.NewRequest("POST", accountsURL+"/api/token", strings.NewReader(form.Encode()))
+	client := base64.StdEncoding.EncodeToString([]byte(s.auth.ClientId + ":" + s.auth.ClientSecret))
+	req.Header.Set("Authorization", "Basic "+client)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var ref Refresh
+	if err := doJSON(httpClient(s.HTTPClient), req, &ref); err != nil {
+		return err
+	}
+	s.auth.Auth = ref.AccessToken
+	s.auth.Expired = ref.ExpiresIn
+	s.auth.Time = time.Now()
+	// spotify may rotate the refresh token
+	if ref.RefreshToken != "" {
+		s.auth.Refresh = ref.RefreshToken
+	}
+	return s.save()
+}
+
+// Playlist returns playlist of the channel or the default one
+func (s *TokenStore) Playlist(channel string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err == nil {
+		if id, ok := s.auth.Playlists[channel]; ok {
+			return id
+		}
+		if s.auth.Playlist != "" {
+			return s.auth.Playlist
+		}
+	}
+	return DefaultPlaylist
+}
+
+func (s *TokenStore) SetPlaylist(channel, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if s.auth.Playlists == nil {
+		s.auth.Playlists = make(map[string]string)
+	}
+	s.auth.Playlists[channel] = id
+	return s.save()
+}
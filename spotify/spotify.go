@@ -2,19 +2,18 @@ package spotify
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
-	"twitchStats/request"
-	"twitchStats/terminal"
 )
 
 var (
@@ -24,70 +23,72 @@ var (
 	path       = basepath + name
 )
 
-type Search struct {
-	Tracks struct {
-		Href  string `json:"href"`
-		Items []struct {
-			Album struct {
-				AlbumType string `json:"album_type"`
-				Artists   []struct {
-					ExternalUrls struct {
-						Spotify string `json:"spotify"`
-					} `json:"external_urls"`
-					Href string `json:"href"`
-					ID   string `json:"id"`
-					Name string `json:"name"`
-					Type string `json:"type"`
-					URI  string `json:"uri"`
-				} `json:"artists"`
-				AvailableMarkets []string `json:"available_markets"`
-				ExternalUrls     struct {
-					Spotify string `json:"spotify"`
-				} `json:"external_urls"`
-				Href   string `json:"href"`
-				ID     string `json:"id"`
-				Images []struct {
-					Height int    `json:"height"`
-					URL    string `json:"url"`
-					Width  int    `json:"width"`
-				} `json:"images"`
-				Name                 string `json:"name"`
-				ReleaseDate          string `json:"release_date"`
-				ReleaseDatePrecision string `json:"release_date_precision"`
-				TotalTracks          int    `json:"total_tracks"`
-				Type                 string `json:"type"`
-				URI                  string `json:"uri"`
-			} `json:"album"`
-			Artists []struct {
-				ExternalUrls struct {
-					Spotify string `json:"spotify"`
-				} `json:"external_urls"`
-				Href string `json:"href"`
-				ID   string `json:"id"`
-				Name string `json:"name"`
-				Type string `json:"type"`
-				URI  string `json:"uri"`
-			} `json:"artists"`
-			AvailableMarkets []string `json:"available_markets"`
-			DiscNumber       int      `json:"disc_number"`
-			DurationMs       int      `json:"duration_ms"`
-			Explicit         bool     `json:"explicit"`
-			ExternalIds      struct {
-				Isrc string `json:"isrc"`
-			} `json:"external_ids"`
+type Track struct {
+	Album struct {
+		AlbumType string `json:"album_type"`
+		Artists   []struct {
 			ExternalUrls struct {
 				Spotify string `json:"spotify"`
 			} `json:"external_urls"`
-			Href        string      `json:"href"`
-			ID          string      `json:"id"`
-			IsLocal     bool        `json:"is_local"`
-			Name        string      `json:"name"`
-			Popularity  int         `json:"popularity"`
-			PreviewURL  interface{} `json:"preview_url"`
-			TrackNumber int         `json:"track_number"`
-			Type        string      `json:"type"`
-			URI         string      `json:"uri"`
-		} `json:"items"`
+			Href string `json:"href"`
+			ID   string `json:"id"`
+			Name string `json:"name"`
+			Type string `json:"type"`
+			URI  string `json:"uri"`
+		} `json:"artists"`
+		AvailableMarkets []string `json:"available_markets"`
+		ExternalUrls     struct {
+			Spotify string `json:"spotify"`
+		} `json:"external_urls"`
+		Href   string `json:"href"`
+		ID     string `json:"id"`
+		Images []struct {
+			Height int    `json:"height"`
+			URL    string `json:"url"`
+			Width  int    `json:"width"`
+		} `json:"images"`
+		Name                 string `json:"name"`
+		ReleaseDate          string `json:"release_date"`
+		ReleaseDatePrecision string `json:"release_date_precision"`
+		TotalTracks          int    `json:"total_tracks"`
+		Type                 string `json:"type"`
+		URI                  string `json:"uri"`
+	} `json:"album"`
+	Artists []struct {
+		ExternalUrls struct {
+			Spotify string `json:"spotify"`
+		} `json:"external_urls"`
+		Href string `json:"href"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+		URI  string `json:"uri"`
+	} `json:"artists"`
+	AvailableMarkets []string `json:"available_markets"`
+	DiscNumber       int      `json:"disc_number"`
+	DurationMs       int      `json:"duration_ms"`
+	Explicit         bool     `json:"explicit"`
+	ExternalIds      struct {
+		Isrc string `json:"isrc"`
+	} `json:"external_ids"`
+	ExternalUrls struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+	Href        string      `json:"href"`
+	ID          string      `json:"id"`
+	IsLocal     bool        `json:"is_local"`
+	Name        string      `json:"name"`
+	Popularity  int         `json:"popularity"`
+	PreviewURL  interface{} `json:"preview_url"`
+	TrackNumber int         `json:"track_number"`
+	Type        string      `json:"type"`
+	URI         string      `json:"uri"`
+}
+
+type Search struct {
+	Tracks struct {
+		Href     string      `json:"href"`
+		Items    []Track     `json:"items"`
 		Limit    int         `json:"limit"`
 		Next     string      `json:"next"`
 		Offset   int         `json:"offset"`
@@ -256,143 +257,281 @@ type Playlist struct {
 	URI  string `json:"uri"`
 }
 
-type Auth struct {
-	Auth         string    `json:"auth"`
-	Refresh      string    `json:"refresh"`
-	Time         time.Time `json:"time"`
-	Expired      int       `json:"expired"`
-	ClientId     string    `json:"client_id"`
-	ClientSecret string    `json:"client_secret"`
+// DefaultPlaylist is used by channels without configured playlist
+const DefaultPlaylist = "6U9yUDYW4uN845DUERRiMH"
+
+// APIError is returned when spotify responds with an error status
+type APIError struct {
+	Status  int
+	Message string
+	// set on 429 Too Many Requests
+	RetryAfter time.Duration
 }
 
-type Refresh struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	Scope       string `json:"scope"`
-	ExpiresIn   int    `json:"expires_in"`
+func (e *APIError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("spotify: %d %s, retry after %s", e.Status, e.Message, e.RetryAfter)
+	}
+	return fmt.Sprintf("spotify: %d %s", e.Status, e.Message)
 }
 
-func checkAuth() string {
-	file, err := ioutil.ReadFile(path)
-	if err != nil {
-		terminal.Output.Log(err)
-		return ""
+// Longer Retry-After is returned to the caller instead of waiting
+const maxRetryWait = 5 * time.Second
+
+type Client struct {
+	// BaseURL is https://api.spotify.com/v1 if empty
+	BaseURL    string
+	PlaylistID string
+	HTTPClient *http.Client
+	Tokens     *TokenStore
+}
+
+func NewClient(playlistID string, tokens *TokenStore) *Client {
+	return &Client{PlaylistID: playlistID, Tokens: tokens}
+}
+
+var (
+	tokens  = NewTokenStore(path)
+	clients = struct {
+		sync.Mutex
+		m map[string]*Client
+	}{m: make(map[string]*Client)}
+)
+
+// ForChannel returns client with the playlist of the channel
+func ForChannel(channel string) *Client {
+	clients.Lock()
+	defer clients.Unlock()
+	playlist := tokens.Playlist(channel)
+	if client, ok := clients.m[channel]; ok && client.PlaylistID == playlist {
+		return client
 	}
-	var data Auth
-	err = json.Unmarshal(file, &data)
+	client := NewClient(playlist, tokens)
+	clients.m[channel] = client
+	return client
+}
+
+// SetPlaylist changes playlist of the channel and saves it to the config
+func SetPlaylist(channel, id string) error {
+	return tokens.SetPlaylist(channel, id)
+}
+
+func httpClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// doJSON sends the request and decodes the response into obj if it isn't nil
+func doJSON(client *http.Client, req *http.Request, obj interface{}) error {
+	res, err := client.Do(req)
 	if err != nil {
-		return ""
+		return err
 	}
-	client := base64.StdEncoding.EncodeToString([]byte(data.ClientId + ":" + data.ClientSecret))
-	t := time.Since(data.Time)
-	if t >= time.Duration(data.Expired)*time.Second {
-		body := strings.NewReader(`grant_type=refresh_token&refresh_token=` + data.Refresh)
-		url := "https://accounts.spotify.com/api/token"
-		req, _ := http.NewRequest("POST", url, body)
-		req.Header.Set("Authorization", "Basic "+client)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		var ref Refresh
-		err := request.JSON(req, 10, &ref)
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		apiErr := &APIError{Status: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+		// api errors are objects, accounts errors are strings with the description next to them
+		var body struct {
+			Error       json.RawMessage `json:"error"`
+			Description string          `json:"error_description"`
+		}
+		if json.NewDecoder(res.Body).Decode(&body) == nil {
+			var apiBody struct {
+				Message string `json:"message"`
+			}
+			if json.Unmarshal(body.Error, &apiBody) == nil && apiBody.Message != "" {
+				apiErr.Message = apiBody.Message
+			} else if body.Description != "" {
+				apiErr.Message = body.Description
+			}
+		}
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+	if obj == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	err = json.NewDecoder(res.Body).Decode(obj)
+	// some endpoints respond 200 without body
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// do sends the request to the api, it's retried once with the new token on 401 and on short 429
+func (c *Client) do(method, endpoint string, body interface{}, obj interface{}) error {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.spotify.com/v1"
+	}
+	if !strings.HasPrefix(endpoint, "http") {
+		endpoint = baseURL + endpoint
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := c.Tokens.Token()
 		if err != nil {
-			return ""
+			return err
+		}
+		req, err := http.NewRequest(method, endpoint, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		err = doJSON(httpClient(c.HTTPClient), req, obj)
+		var apiErr *APIError
+		if attempt > 0 || !errors.As(err, &apiErr) {
+			return err
+		}
+		switch {
+		case apiErr.Status == http.StatusUnauthorized:
+			c.Tokens.Invalidate()
+		case apiErr.Status == http.StatusTooManyRequests && apiErr.RetryAfter <= maxRetryWait:
+			time.Sleep(apiErr.RetryAfter)
+		default:
+			return err
 		}
-		data.Auth = ref.AccessToken
-		data.Expired = ref.ExpiresIn
-		data.Time = time.Now()
-		w, _ := json.Marshal(data)
-		ioutil.WriteFile(path, w, 0644)
 	}
-	return data.Auth
 }
 
-func SearchTrack(name string) (*Search, error) {
-	auth := checkAuth()
-	name = url.QueryEscape(name)
-	url := "https://api.spotify.com/v1/search?query=" + name + "&offset=0&limit=1&type=track"
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+auth)
-	req.Header.Set("Content-Type", "application/json")
+// Search returns up to limit tracks ordered by relevance
+func (c *Client) Search(name string, limit int) (*Search, error) {
+	query := url.Values{"q": {name}, "type": {"track"}, "offset": {"0"}, "limit": {strconv.Itoa(limit)}}
 	var search Search
-	err := request.JSON(req, 10, &search)
+	err := c.do("GET", "/search?"+query.Encode(), nil, &search)
 	if err != nil {
 		return nil, err
 	}
 	return &search, nil
 }
 
-func AddToPlaylist(uri string) error {
-	auth := checkAuth()
-	url := "https://api.spotify.com/v1/playlists/6U9yUDYW4uN845DUERRiMH/tracks?uris=" + uri
-	req, _ := http.NewRequest("POST", url, nil)
-	req.Header.Set("Authorization", "Bearer "+auth)
-	req.Header.Set("Content-Type", "application/json")
-	err := request.JSON(req, 10, nil)
+func (c *Client) Track(id string) (*Track, error) {
+	var track Track
+	err := c.do("GET", "/tracks/"+url.PathEscape(id), nil, &track)
 	if err != nil {
-		terminal.Output.Log(err)
-		return err
+		return nil, err
 	}
-	return nil
+	return &track, nil
 }
 
-func GetCurrentTrack() (string, error) {
-	auth := checkAuth()
-	url := "https://api.spotify.com/v1/me/player/currently-playing"
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+auth)
-	var curr Current
-	err := request.JSON(req, 10, &curr)
+// AddToPlaylist returns snapshot id of the playlist with the added track
+func (c *Client) AddToPlaylist(uri string) (string, error) {
+	var snapshot struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	body := map[string][]string{"uris": {uri}}
+	err := c.do("POST", "/playlists/"+c.PlaylistID+"/tracks", body, &snapshot)
 	if err != nil {
 		return "", err
 	}
-	name := curr.Item.Artists[0].Name
-	for i := 1; i < len(curr.Item.Artists); i++ {
-		name += ", " + curr.Item.Artists[i].Name
-	}
-	link := "https://open.spotify.com/track/" + strings.Split(curr.Item.URI, ":")[2]
-	return fmt.Sprintf("%s - %s. Link: %s", name, curr.Item.Name, link), nil
+	return snapshot.SnapshotID, nil
 }
 
-func SkipToNextTrack() {
-	auth := checkAuth()
-	url := "https://api.spotify.com/v1/me/player/next"
-	req, _ := http.NewRequest("POST", url, nil)
-	req.Header.Set("Authorization", "Bearer "+auth)
-	err := request.JSON(req, 10, nil)
+// RemoveTrack removes the track at the position, returns snapshot id of the playlist
+func (c *Client) RemoveTrack(uri string, pos int) (string, error) {
+	type track struct {
+		URI       string `json:"uri"`
+		Positions []int  `json:"positions"`
+	}
+	body := map[string][]track{"tracks": {{URI: uri, Positions: []int{pos}}}}
+	var snapshot struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	err := c.do("DELETE", "/playlists/"+c.PlaylistID+"/tracks", body, &snapshot)
 	if err != nil {
-		terminal.Output.Log(err)
-		return
+		return "", err
 	}
+	return snapshot.SnapshotID, nil
 }
 
-func RemoveTrack(uri string, pos int) error {
-	auth := checkAuth()
-	url := "https://api.spotify.com/v1/playlists/6U9yUDYW4uN845DUERRiMH/tracks"
-	req, _ := http.NewRequest("DELETE", url, bytes.NewBuffer([]byte("\"tracks\":[{\"uri\":\""+"spotify:track:"+uri+"\", \"positions\": ["+strconv.Itoa(pos)+"]}]")))
-	req.Header.Set("Authorization", "Bearer "+auth)
-	req.Header.Set("Content-Type", "application/json")
-	err := request.JSON(req, 10, nil)
+// Playlist returns the playlist with all of its tracks
+func (c *Client) Playlist() (*Playlist, error) {
+	var playlist Playlist
+	err := c.do("GET", "/playlists/"+c.PlaylistID, nil, &playlist)
 	if err != nil {
-		terminal.Output.Log(err)
-		return err
+		return nil, err
+	}
+	// only the first 100 tracks are returned with the playlist
+	for next := playlist.Tracks.Next; next != ""; {
+		page := playlist.Tracks
+		page.Items = nil
+		page.Next = ""
+		if err := c.do("GET", next, nil, &page); err != nil {
+			return nil, err
+		}
+		playlist.Tracks.Items = append(playlist.Tracks.Items, page.Items...)
+		next = page.Next
 	}
-	return nil
+	return &playlist, nil
 }
 
-func GetTotalInPlaylist() (int, error) {
-	auth := checkAuth()
-	url := "https://api.spotify.com/v1/playlists/6U9yUDYW4uN845DUERRiMH?fields=tracks.total"
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+auth)
-	req.Header.Set("Content-Type", "application/json")
-	var m map[string]interface{}
-	err := request.JSON(req, 10, &m)
+func (c *Client) TotalInPlaylist() (int, error) {
+	var playlist struct {
+		Tracks struct {
+			Total int `json:"total"`
+		} `json:"tracks"`
+	}
+	err := c.do("GET", "/playlists/"+c.PlaylistID+"?fields=tracks.total", nil, &playlist)
 	if err != nil {
-		terminal.Output.Log(err)
 		return 0, err
 	}
-	total, err := m["tracks"].(map[string]interface{})["total"].(json.Number).Int64()
+	return playlist.Tracks.Total, nil
+}
+
+// Playback returns the current playback, Item is empty if nothing is playing
+func (c *Client) Playback() (*Current, error) {
+	var curr Current
+	err := c.do("GET", "/me/player/currently-playing", nil, &curr)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	return &curr, nil
+}
+
+// CurrentTrack returns the playing track with the link to it
+func (c *Client) CurrentTrack() (string, error) {
+	curr, err := c.Playback()
+	if err != nil {
+		return "", err
 	}
-	return int(total), nil
+	if curr.Item.URI == "" {
+		return "", nil
+	}
+	names := make([]string, len(curr.Item.Artists))
+	for i, artist := range curr.Item.Artists {
+		names[i] = artist.Name
+	}
+	link := "https://open.spotify.com/track/" + curr.Item.ID
+	return fmt.Sprintf("%s - %s. Link: %s", strings.Join(names, ", "), curr.Item.Name, link), nil
+}
+
+func (c *Client) Next() error {
+	return c.do("POST", "/me/player/next", nil, nil)
+}
+
+func (c *Client) Pause() error {
+	return c.do("PUT", "/me/player/pause", nil, nil)
+}
+
+func (c *Client) Resume() error {
+	return c.do("PUT", "/me/player/play", nil, nil)
+}
+
+// SetVolume sets volume of the active device from 0 to 100
+func (c *Client) SetVolume(percent int) error {
+	return c.do("PUT", "/me/player/volume?volume_percent="+strconv.Itoa(percent), nil, nil)
 }
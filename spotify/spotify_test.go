@@ -0,0 +1,215 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSpotify serves both the accounts and the api endpoints
+type fakeSpotify struct {
+	*httptest.Server
+	refreshes int32
+	calls     int32
+	// handles /v1 requests, the token is already checked
+	api func(w http.ResponseWriter, r *http.Request, call int32)
+}
+
+func newFakeSpotify(t *testing.T) *fakeSpotify {
+	f := &fakeSpotify{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "id" || pass != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid client"}`))
+			return
+		}
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid refresh token"}`))
+			return
+		}
+		atomic.AddInt32(&f.refreshes, 1)
+		json.NewEncoder(w).Encode(Refresh{AccessToken: "fresh", ExpiresIn: 3600, RefreshToken: "rotated"})
+	})
+	mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		call := atomic.AddInt32(&f.calls, 1)
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"status":401,"message":"The access token expired"}}`))
+			return
+		}
+		f.api(w, r, call)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client with the token in the temp file, the token is valid if expiresIn is longer than refreshMargin
+func (f *fakeSpotify) client(t *testing.T, token string, expiresIn time.Duration) (*Client, string) {
+	dir, err := ioutil.TempDir("", "spotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "sp.json")
+	auth := Auth{Auth: token, Refresh: "refresh", Time: time.Now(), Expired: int(expiresIn / time.Second), ClientId: "id", ClientSecret: "secret"}
+	data, _ := json.Marshal(auth)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenStore(path)
+	tokens.AccountsURL = f.URL
+	client := NewClient("playlist", tokens)
+	client.BaseURL = f.URL + "/v1"
+	return client, path
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	f := newFakeSpotify(t)
+	f.api = func(w http.ResponseWriter, r *http.Request, call int32) {
+		w.Write([]byte(`{"id":"track","name":"Song","uri":"spotify:track:track"}`))
+	}
+	client, path := f.client(t, "stale", time.Minute)
+	track, err := client.Track("track")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Name != "Song" {
+		t.Errorf("got track %q, want Song", track.Name)
+	}
+	if atomic.LoadInt32(&f.refreshes) != 1 || atomic.LoadInt32(&f.calls) != 1 {
+		t.Errorf("got %d refreshes and %d calls, want 1 and 1", atomic.LoadInt32(&f.refreshes), atomic.LoadInt32(&f.calls))
+	}
+	// the rotated refresh token must survive the restart
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Auth
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Auth != "fresh" || saved.Refresh != "rotated" || saved.Expired != 3600 {
+		t.Errorf("saved %+v, want fresh token and rotated refresh token", saved)
+	}
+}
+
+func TestUnauthorizedRetriedWithNewToken(t *testing.T) {
+	f := newFakeSpotify(t)
+	f.api = func(w http.ResponseWriter, r *http.Request, call int32) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	// the token looks valid, but the api rejects it
+	client, _ := f.client(t, "revoked", time.Hour)
+	if err := client.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&f.refreshes) != 1 || atomic.LoadInt32(&f.calls) != 2 {
+		t.Errorf("got %d refreshes and %d calls, want 1 and 2", atomic.LoadInt32(&f.refreshes), atomic.LoadInt32(&f.calls))
+	}
+}
+
+func TestShortRetryAfterWaited(t *testing.T) {
+	f := newFakeSpotify(t)
+	f.api = func(w http.ResponseWriter, r *http.Request, call int32) {
+		if call == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	client, _ := f.client(t, "fresh", time.Hour)
+	start := time.Now()
+	if err := client.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %s, want at least 1s", waited)
+	}
+	if atomic.LoadInt32(&f.calls) != 2 {
+		t.Errorf("got %d calls, want 2", atomic.LoadInt32(&f.calls))
+	}
+}
+
+func TestLongRetryAfterReturned(t *testing.T) {
+	f := newFakeSpotify(t)
+	f.api = func(w http.ResponseWriter, r *http.Request, call int32) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	client, _ := f.client(t, "fresh", time.Hour)
+	err := client.Resume()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want APIError", err)
+	}
+	if apiErr.Status != http.StatusTooManyRequests || apiErr.RetryAfter != time.Minute {
+		t.Errorf("got %+v, want 429 with retry after 1m", apiErr)
+	}
+	if atomic.LoadInt32(&f.calls) != 1 {
+		t.Errorf("got %d calls, want 1", atomic.LoadInt32(&f.calls))
+	}
+}
+
+func TestAPIErrorMapping(t *testing.T) {
+	f := newFakeSpotify(t)
+	f.api = func(w http.ResponseWriter, r *http.Request, call int32) {
+		switch r.URL.Path {
+		case "/v1/tracks/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"status":404,"message":"Non existing id"}}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`<html>bad gateway</html>`))
+		}
+	}
+	tests := []struct {
+		name    string
+		call    func(c *Client) error
+		status  int
+		message string
+	}{
+		{"api message", func(c *Client) error { _, err := c.Track("missing"); return err }, http.StatusNotFound, "Non existing id"},
+		{"status text without json", func(c *Client) error { _, err := c.Playback(); return err }, http.StatusBadGateway, "Bad Gateway"},
+	}
+	client, _ := f.client(t, "fresh", time.Hour)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var apiErr *APIError
+			if err := tt.call(client); !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want APIError", err)
+			}
+			if apiErr.Status != tt.status || apiErr.Message != tt.message {
+				t.Errorf("got %d %q, want %d %q", apiErr.Status, apiErr.Message, tt.status, tt.message)
+			}
+		})
+	}
+}
+
+func TestRefreshErrorDescription(t *testing.T) {
+	f := newFakeSpotify(t)
+	client, _ := f.client(t, "stale", 0)
+	if err := client.Tokens.load(); err != nil {
+		t.Fatal(err)
+	}
+	client.Tokens.auth.ClientSecret = "wrong"
+	_, err := client.Tokens.Token()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want APIError", err)
+	}
+	if apiErr.Status != http.StatusBadRequest || apiErr.Message != "Invalid client" {
+		t.Errorf("got %d %q, want 400 \"Invalid client\"", apiErr.Status, apiErr.Message)
+	}
+}
@@ -11,6 +11,8 @@ type Stats struct {
 	MsgCountPrev int
 	WatchTime    time.Duration
 	LastCheck    time.Time
+	// part of WatchTime already paid with points, the rest is paid once it makes a full minute
+	PaidTime time.Duration
 }
 
 type ChatData struct {
@@ -0,0 +1,189 @@
+package tells
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"twitchStats/database"
+)
+
+const (
+	// undelivered messages of one sender in the channel
+	MaxPerSender = 5
+	MaxLength    = 300
+	Expiry       = 7 * 24 * time.Hour
+)
+
+// Tell is the message which is delivered when the recipient chats next time
+type Tell struct {
+	ID        int64
+	Channel   string
+	Sender    string
+	Recipient string
+	Message   string
+	Whisper   bool
+	Created   time.Time
+	Expires   time.Time
+}
+
+func createTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Tells(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Sender TEXT NOT NULL, Recipient TEXT NOT NULL, Message TEXT NOT NULL, Whisper INTEGER NOT NULL DEFAULT 0, CreatedAt TIMESTAMP NOT NULL, ExpiresAt TIMESTAMP NOT NULL, DeliveredAt TIMESTAMP);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS TellsRecipient ON Tells(Channel, Recipient, DeliveredAt);")
+	return err
+}
+
+// Send stores the message for the recipient
+func Send(t *Tell) error {
+	if t.Message = strings.TrimSpace(t.Message); t.Message == "" {
+		return errors.New("message can't be empty")
+	}
+	if len(t.Message) > MaxLength {
+		return fmt.Errorf("message is longer than %d characters", MaxLength)
+	}
+	if t.Sender == t.Recipient {
+		return errors.New("you can't tell yourself")
+	}
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	now := time.Now()
+	// expired messages are never delivered, so they are removed here
+	if _, err := db.Exec("DELETE FROM Tells WHERE ExpiresAt<=$1;", now); err != nil {
+		return err
+	}
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM Tells WHERE Channel=$1 AND Sender=$2 AND DeliveredAt IS NULL;", t.Channel, t.Sender).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count >= MaxPerSender {
+		return fmt.Errorf("you already have %d undelivered messages", count)
+	}
+	t.Created, t.Expires = now, now.Add(Expiry)
+	res, err := db.Exec("INSERT INTO Tells(Channel, Sender, Recipient, Message, Whisper, CreatedAt, ExpiresAt) VALUES($1,$2,$3,$4,$5,$6,$7);",
+		t.Channel, t.Sender, t.Recipient, t.Message, t.Whisper, t.Created, t.Expires)
+	if err != nil {
+		return err
+	}
+	t.ID, err = res.LastInsertId()
+	return err
+}
+
+// Take marks messages of the recipient as delivered and returns them, the oldest first
+func Take(channel, recipient string) ([]Tell, error) {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	// the update goes first, so the same messages can't be taken twice
+	res, err := tx.Exec("UPDATE Tells SET DeliveredAt=$1 WHERE Channel=$2 AND Recipient=$3 AND DeliveredAt IS NULL AND ExpiresAt>$1;", now, channel, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	rows, err := tx.Query("SELECT Id, Sender, Message, Whisper, CreatedAt, ExpiresAt FROM Tells WHERE Channel=$1 AND Recipient=$2 AND DeliveredAt=$3 ORDER BY Id;", channel, recipient, now)
+	if err != nil {
+		return nil, err
+	}
+	var tells []Tell
+	for rows.Next() {
+		t := Tell{Channel: channel, Recipient: recipient}
+		if err := rows.Scan(&t.ID, &t.Sender, &t.Message, &t.Whisper, &t.Created, &t.Expires); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tells = append(tells, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tells, tx.Commit()
+}
+
+// Pending are the recipients with undelivered messages in the channel,
+// so the database is touched only when one of them chats
+type Pending struct {
+	sync.Mutex
+	Channel    string
+	recipients map[string]struct{}
+}
+
+func NewPending(channel string) *Pending {
+	return &Pending{Channel: channel, recipients: make(map[string]struct{})}
+}
+
+// Load adds the recipients of the undelivered messages from the database
+func (p *Pending) Load() error {
+	db := database.Connect()
+	defer db.Close()
+	if err := createTable(db); err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT DISTINCT Recipient FROM Tells WHERE Channel=$1 AND DeliveredAt IS NULL AND ExpiresAt>$2;", p.Channel, time.Now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var recipients []string
+	for rows.Next() {
+		var recipient string
+		if err := rows.Scan(&recipient); err != nil {
+			return err
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		p.Add(recipient)
+	}
+	return nil
+}
+
+// Add marks the recipient of the new message
+func (p *Pending) Add(recipient string) {
+	p.Lock()
+	p.recipients[recipient] = struct{}{}
+	p.Unlock()
+}
+
+// Take returns messages of the recipient, the database is checked only for the pending recipients
+func (p *Pending) Take(recipient string) ([]Tell, error) {
+	p.Lock()
+	_, ok := p.recipients[recipient]
+	// removed before taking, so messages added meanwhile mark the recipient again
+	delete(p.recipients, recipient)
+	p.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	list, err := Take(p.Channel, recipient)
+	if err != nil {
+		p.Add(recipient)
+	}
+	return list, err
+}
+
+// Text is the delivered message
+func (t *Tell) Text() string {
+	return fmt.Sprintf("%s told you %s ago: %s", t.Sender, time.Since(t.Created).Truncate(time.Second), t.Message)
+}
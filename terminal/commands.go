@@ -25,23 +25,141 @@ type IdData struct {
 		ProfileImageURL string `json:"profile_image_url"`
 		OfflineImageURL string `json:"offline_image_url"`
 		ViewCount       int    `json:"view_count"`
+		CreatedAt       string `json:"created_at"`
 	} `json:"data"`
 }
 
+// User is the account from helix
+type User struct {
+	ID          string
+	Login       string
+	DisplayName string
+	CreatedAt   time.Time
+}
+
+// GetUser looks up the account by login
+func GetUser(login string) (*User, error) {
+	req := GetHelixGetRequest("https://api.twitch.tv/helix/users?login=" + url.QueryEscape(login))
+	var iddata IdData
+	if err := request.JSON(req, 10, &iddata); err != nil {
+		return nil, err
+	}
+	if len(iddata.Data) == 0 {
+		return nil, fmt.Errorf("user %s wasn't found", login)
+	}
+	data := iddata.Data[0]
+	createdAt, err := time.Parse(time.RFC3339, data.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: data.ID, Login: data.Login, DisplayName: data.DisplayName, CreatedAt: createdAt}, nil
+}
+
+// GetUsers looks up accounts by logins, helix accepts up to 100 logins per request
+func GetUsers(logins []string) ([]User, error) {
+	return getUsers("login", logins)
+}
+
+// GetUsersByID looks up accounts by ids, up to 100 per request
+func GetUsersByID(ids []string) ([]User, error) {
+	return getUsers("id", ids)
+}
+
+func getUsers(param string, values []string) ([]User, error) {
+	query := url.Values{param: values}
+	req := GetHelixGetRequest("https://api.twitch.tv/helix/users?" + query.Encode())
+	var iddata IdData
+	if err := request.JSON(req, 10, &iddata); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(iddata.Data))
+	for _, data := range iddata.Data {
+		createdAt, _ := time.Parse(time.RFC3339, data.CreatedAt)
+		users = append(users, User{ID: data.ID, Login: data.Login, DisplayName: data.DisplayName, CreatedAt: createdAt})
+	}
+	return users, nil
+}
+
+type Follow struct {
+	FromID     string `json:"from_id"`
+	FromLogin  string `json:"from_login"`
+	FromName   string `json:"from_name"`
+	ToID       string `json:"to_id"`
+	ToLogin    string `json:"to_login"`
+	ToName     string `json:"to_name"`
+	FollowedAt string `json:"followed_at"`
+}
+
 type Followers struct {
+	Total      int      `json:"total"`
+	Data       []Follow `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+// channels/followers and channels/followed entries are converted to the Follow of the removed users/follows
+type channelFollows struct {
 	Total int `json:"total"`
 	Data  []struct {
-		FromID     string `json:"from_id"`
-		FromName   string `json:"from_name"`
-		ToID       string `json:"to_id"`
-		ToName     string `json:"to_name"`
-		FollowedAt string `json:"followed_at"`
+		UserID           string `json:"user_id"`
+		UserLogin        string `json:"user_login"`
+		UserName         string `json:"user_name"`
+		BroadcasterID    string `json:"broadcaster_id"`
+		BroadcasterLogin string `json:"broadcaster_login"`
+		BroadcasterName  string `json:"broadcaster_name"`
+		FollowedAt       string `json:"followed_at"`
 	} `json:"data"`
 	Pagination struct {
 		Cursor string `json:"cursor"`
 	} `json:"pagination"`
 }
 
+// GetChannelFollowers returns one page of followers of the channel, the newest first.
+// The bot token needs moderator:read:followers and the bot must be the broadcaster or a moderator
+func GetChannelFollowers(channelID, cursor string) (Followers, error) {
+	query := url.Values{"first": {"100"}, "broadcaster_id": {channelID}}
+	if cursor != "" {
+		query.Set("after", cursor)
+	}
+	var page channelFollows
+	if err := request.JSON(GetHelixGetRequest("https://api.twitch.tv/helix/channels/followers?"+query.Encode()), 10, &page); err != nil {
+		return Followers{}, err
+	}
+	followers := Followers{Total: page.Total, Data: make([]Follow, len(page.Data))}
+	followers.Pagination.Cursor = page.Pagination.Cursor
+	for i, d := range page.Data {
+		followers.Data[i] = Follow{FromID: d.UserID, FromLogin: d.UserLogin, FromName: d.UserName, ToID: channelID, FollowedAt: d.FollowedAt}
+	}
+	return followers, nil
+}
+
+// GetFollowed returns every channel the user follows. Helix answers only with the token of that user
+// with user:read:follows issued for TWITCH_CLIENT_ID
+func GetFollowed(userID, token string) ([]Follow, error) {
+	var follows []Follow
+	cursor := ""
+	for {
+		query := url.Values{"first": {"100"}, "user_id": {userID}}
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+		req := GetHelixGetRequest("https://api.twitch.tv/helix/channels/followed?" + query.Encode())
+		req.Header.Set("Authorization", "Bearer "+strings.TrimPrefix(token, "oauth:"))
+		var page channelFollows
+		if err := request.JSON(req, 10, &page); err != nil {
+			return nil, err
+		}
+		for _, d := range page.Data {
+			follows = append(follows, Follow{FromID: userID, ToID: d.BroadcasterID, ToLogin: d.BroadcasterLogin, ToName: d.BroadcasterName, FollowedAt: d.FollowedAt})
+		}
+		cursor = page.Pagination.Cursor
+		if cursor == "" || len(page.Data) == 0 {
+			return follows, nil
+		}
+	}
+}
+
 func GetUserId(name string) (string, error) {
 	url := "https://api.twitch.tv/helix/users?login=" + name
 	req := GetHelixGetRequest(url)
@@ -54,6 +172,26 @@ func GetUserId(name string) (string, error) {
 	return iddata.Data[0].ID, nil
 }
 
+type Streams struct {
+	Data []struct {
+		ID        string `json:"id"`
+		UserLogin string `json:"user_login"`
+		Type      string `json:"type"`
+		Title     string `json:"title"`
+		StartedAt string `json:"started_at"`
+	} `json:"data"`
+}
+
+// IsLive checks if the channel is streaming right now
+func IsLive(login string) (bool, error) {
+	req := GetHelixGetRequest("https://api.twitch.tv/helix/streams?user_login=" + url.QueryEscape(strings.TrimPrefix(login, "#")))
+	var streams Streams
+	if err := request.JSON(req, 10, &streams); err != nil {
+		return false, err
+	}
+	return len(streams.Data) > 0 && streams.Data[0].Type == "live", nil
+}
+
 func GetHelixGetRequest(url string) *http.Request {
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("Authorization", "Bearer "+strings.Split(os.Getenv("TWITCH_OAUTH_ENV"), ":")[1])
@@ -0,0 +1,309 @@
+package timers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"twitchStats/database"
+)
+
+// Shortest interval of the timer
+const MinInterval = time.Minute
+
+// Timer posts its messages in turn every Interval,
+// if at least MinLines chat lines were written since the last post
+type Timer struct {
+	ID       int64
+	Channel  string
+	Name     string
+	Interval time.Duration
+	MinLines int
+	LiveOnly bool
+	Enabled  bool
+	Messages []string
+	// index of the next message
+	Position int
+}
+
+func createTables(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Timers(Id INTEGER PRIMARY KEY, Channel TEXT NOT NULL, Name TEXT NOT NULL, Interval INTEGER NOT NULL, MinLines INTEGER NOT NULL DEFAULT 0, LiveOnly INTEGER NOT NULL DEFAULT 0, Enabled INTEGER NOT NULL DEFAULT 1, Position INTEGER NOT NULL DEFAULT 0, UNIQUE(Channel, Name));")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS TimerMessages(Id INTEGER PRIMARY KEY, TimerId INTEGER NOT NULL REFERENCES Timers(Id) ON DELETE CASCADE, Text TEXT NOT NULL);")
+	return err
+}
+
+func connect() (*sql.DB, error) {
+	db := database.Connect()
+	if err := createTables(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Next returns the message which should be posted now
+func (t *Timer) Next() string {
+	if len(t.Messages) == 0 {
+		return ""
+	}
+	return t.Messages[t.Position%len(t.Messages)]
+}
+
+func (t *Timer) String() string {
+	str := fmt.Sprintf("%s: every %s", t.Name, t.Interval)
+	if t.MinLines > 0 {
+		str += fmt.Sprintf(", %d lines", t.MinLines)
+	}
+	if t.LiveOnly {
+		str += ", live only"
+	}
+	if !t.Enabled {
+		str += ", disabled"
+	}
+	return str + fmt.Sprintf(", %d messages", len(t.Messages))
+}
+
+// Parse reads the timer from the args: <name> <interval> [lines <n>] [live] <message> | <message>...
+func Parse(channel string, args []string) (*Timer, error) {
+	if len(args) < 3 {
+		return nil, errors.New("need name, interval and message")
+	}
+	interval, err := time.ParseDuration(args[1])
+	if err != nil {
+		return nil, errors.New("wrong interval format, use e.g. 15m")
+	}
+	if interval < MinInterval {
+		return nil, errors.New("interval must be at least " + MinInterval.String())
+	}
+	t := &Timer{Channel: channel, Name: strings.ToLower(args[0]), Interval: interval, Enabled: true}
+	args = args[2:]
+	for len(args) > 0 {
+		if args[0] == "live" {
+			t.LiveOnly = true
+			args = args[1:]
+		} else if args[0] == "lines" && len(args) > 1 {
+			if t.MinLines, err = strconv.Atoi(args[1]); err != nil || t.MinLines < 0 {
+				return nil, errors.New("lines must be a number")
+			}
+			args = args[2:]
+		} else {
+			break
+		}
+	}
+	for _, text := range strings.Split(strings.Join(args, " "), "|") {
+		if text = strings.TrimSpace(text); text != "" {
+			t.Messages = append(t.Messages, text)
+		}
+	}
+	if len(t.Messages) == 0 {
+		return nil, errors.New("need message")
+	}
+	return t, nil
+}
+
+// Add saves the new timer with its messages
+func Add(t *Timer) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO Timers(Channel, Name, Interval, MinLines, LiveOnly, Enabled) VALUES($1,$2,$3,$4,$5,$6);",
+		t.Channel, t.Name, int64(t.Interval), t.MinLines, t.LiveOnly, t.Enabled)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("timer %s already exists", t.Name)
+		}
+		return err
+	}
+	if t.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	for _, text := range t.Messages {
+		if _, err := tx.Exec("INSERT INTO TimerMessages(TimerId, Text) VALUES($1,$2);", t.ID, text); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func id(db *sql.DB, channel, name string) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT Id FROM Timers WHERE Channel=$1 AND Name=$2;", channel, strings.ToLower(name)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("timer %s wasn't found", name)
+	}
+	return id, err
+}
+
+// AddMessage adds the message to the rotation of the timer
+func AddMessage(channel, name, text string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	timerID, err := id(db, channel, name)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO TimerMessages(TimerId, Text) VALUES($1,$2);", timerID, text)
+	return err
+}
+
+func Remove(channel, name string) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	timerID, err := id(db, channel, name)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM TimerMessages WHERE TimerId=$1;", timerID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Timers WHERE Id=$1;", timerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func SetEnabled(channel, name string, enabled bool) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	res, err := db.Exec("UPDATE Timers SET Enabled=$1 WHERE Channel=$2 AND Name=$3;", enabled, channel, strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("timer %s wasn't found", name)
+	}
+	return nil
+}
+
+// Advance moves the timer to the next message
+func Advance(t *Timer) error {
+	db, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	t.Position++
+	if len(t.Messages) > 0 {
+		t.Position %= len(t.Messages)
+	}
+	_, err = db.Exec("UPDATE Timers SET Position=$1 WHERE Id=$2;", t.Position, t.ID)
+	return err
+}
+
+// List returns timers of the channel with their messages
+func List(channel string) ([]Timer, error) {
+	db, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT Id, Name, Interval, MinLines, LiveOnly, Enabled, Position FROM Timers WHERE Channel=$1 ORDER BY Name;", channel)
+	if err != nil {
+		return nil, err
+	}
+	var timers []Timer
+	for rows.Next() {
+		t := Timer{Channel: channel}
+		var interval int64
+		if err := rows.Scan(&t.ID, &t.Name, &interval, &t.MinLines, &t.LiveOnly, &t.Enabled, &t.Position); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Interval = time.Duration(interval)
+		timers = append(timers, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range timers {
+		rows, err := db.Query("SELECT Text FROM TimerMessages WHERE TimerId=$1 ORDER BY Id;", timers[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var text string
+			if err := rows.Scan(&text); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			timers[i].Messages = append(timers[i].Messages, text)
+		}
+		rows.Close()
+	}
+	return timers, nil
+}
+
+// Manage runs the subcommand from chat or terminal and returns the reply:
+// list | add <name> <interval> [lines <n>] [live] <message> | <message>... | msg <name> <message> | del <name> | on|off <name>
+func Manage(channel string, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("need subcommand")
+	}
+	switch {
+	case args[0] == "list":
+		timers, err := List(channel)
+		if err != nil {
+			return "", err
+		}
+		if len(timers) == 0 {
+			return "there are no timers", nil
+		}
+		items := make([]string, len(timers))
+		for i := range timers {
+			items[i] = timers[i].String()
+		}
+		return strings.Join(items, " | "), nil
+	case args[0] == "add":
+		t, err := Parse(channel, args[1:])
+		if err != nil {
+			return "", err
+		}
+		if err := Add(t); err != nil {
+			return "", err
+		}
+		return "added timer " + t.String(), nil
+	case args[0] == "msg" && len(args) > 2:
+		if err := AddMessage(channel, args[1], strings.Join(args[2:], " ")); err != nil {
+			return "", err
+		}
+		return "added message to " + args[1], nil
+	case args[0] == "del" && len(args) == 2:
+		if err := Remove(channel, args[1]); err != nil {
+			return "", err
+		}
+		return "removed timer " + args[1], nil
+	case (args[0] == "on" || args[0] == "off") && len(args) == 2:
+		if err := SetEnabled(channel, args[1], args[0] == "on"); err != nil {
+			return "", err
+		}
+		return "timer " + args[1] + " is " + args[0], nil
+	}
+	return "", errors.New("unknown subcommand " + args[0])
+}